@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AuditRecord — запись аудита: кто, когда и как изменил событие.
+type AuditRecord struct {
+	At     time.Time
+	Actor  int64
+	Action ChangeKind
+	// EventID и UserID — событие и владелец календаря.
+	EventID int64
+	UserID  int64
+	// Before — событие до изменения, nil для созданного и восстановленного;
+	// After — после, nil для удалённого.
+	Before *Event `json:",omitempty"`
+	After  *Event `json:",omitempty"`
+}
+
+// ErrNoVersion — в истории события нет запрошенной версии.
+const ErrNoVersion = domainError("event has no such version")
+
+// As возвращает тот же календарь, изменения в котором записываются в
+// аудит от имени пользователя actor.
+func (c *Calendar) As(actor int64) *Calendar {
+	return &Calendar{store: c.store, changes: c.changes, index: c.index, txMu: c.txMu, undo: c.undo, actor: actor}
+}
+
+// audit дописывает изменение события в аудит. Изменение к этому моменту
+// уже сохранено, ошибка аудита лишь сообщается вызывающему.
+func (c *Calendar) audit(kind ChangeKind, before, after *Event) error {
+	rec := AuditRecord{At: time.Now(), Actor: c.actor, Action: kind, Before: before, After: after}
+	e := after
+	if e == nil {
+		e = before
+	}
+	rec.EventID, rec.UserID = e.ID, e.UserID
+	if err := c.store.AppendAudit(rec); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// EventHistory возвращает записи аудита события id из календаря userID по
+// порядку, в том числе для уже удалённого события.
+func (c *Calendar) EventHistory(userID, id int64) ([]AuditRecord, error) {
+	records, err := c.store.Audit(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		// событие создано до появления аудита
+		if _, err := c.userEvent(userID, id); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	if records[0].UserID != userID {
+		return nil, ErrNotOwner
+	}
+	return records, nil
+}
+
+// RestoreEvent возвращает событие id к состоянию версии version из его
+// истории: существующее событие изменяется, если его версия равна
+// expected (0 — любая), удалённое сохраняется снова под тем же ID.
+// В обоих случаях событие получает новую версию. Повторения серии,
+// удалённые вместе с ней, восстанавливаются каждое отдельно.
+func (c *Calendar) RestoreEvent(userID, id, version, expected int64) (Event, error) {
+	records, err := c.EventHistory(userID, id)
+	if err != nil {
+		return Event{}, err
+	}
+	var (
+		target *Event
+		last   int64 // последняя версия события в истории
+	)
+	for _, r := range records {
+		if r.After != nil {
+			if r.After.Version == version {
+				target = r.After
+			}
+			last = r.After.Version
+		}
+	}
+	if target == nil {
+		return Event{}, ErrNoVersion
+	}
+	e := *target
+	cur, err := c.store.Get(id)
+	switch {
+	case err == ErrEventNotFound:
+		if expected != 0 {
+			return Event{}, ErrVersionConflict
+		}
+		e.Version = last
+		return c.restore(e)
+	case err != nil:
+		return Event{}, err
+	}
+	if err := checkVersion(cur, expected); err != nil {
+		return Event{}, err
+	}
+	e.Version = cur.Version
+	if err := c.update(&e); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// auditJSON — запись истории события в ответе /event_history.
+type auditJSON struct {
+	Action ChangeKind `json:"action"`
+	Actor  int64      `json:"actor,omitempty"`
+	At     string     `json:"at"`
+	// Version — версия события после изменения, для удаления — последняя.
+	Version int64     `json:"version"`
+	Event   eventJSON `json:"event"`
+}
+
+func newAuditJSON(r AuditRecord) auditJSON {
+	e := r.After
+	if e == nil {
+		e = r.Before
+	}
+	return auditJSON{Action: r.Action, Actor: r.Actor, At: r.At.Format(time.RFC3339Nano), Version: e.Version, Event: newEventJSON(*e)}
+}
+
+// actor возвращает пользователя, от имени которого запрос меняет
+// календарь userID: аутентифицированного в ctx или без аутентификации
+// владельца.
+func actor(ctx context.Context, userID int64) int64 {
+	if p := principalFrom(ctx); p != nil {
+		return p.UserID
+	}
+	return userID
+}
+
+// eventHistory отдаёт историю изменений события id, включая удалённое.
+func (s *Server) eventHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	userID, err := s.requestUser(r, params, AccessRead)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := parseInt(params, "id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	records, err := s.cal.EventHistory(userID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]auditJSON, 0, len(records))
+	for _, rec := range records {
+		res = append(res, newAuditJSON(rec))
+	}
+	writeResult(w, res)
+}
+
+// restoreEvent возвращает событие к версии to_version из его истории.
+func (s *Server) restoreEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err == nil {
+		err = ifMatch(r, params)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := s.requestUser(r, params, AccessWrite)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := parseInt(params, "id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := parseInt(params, "to_version")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	expected, err := paramVersion(params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	e, err := s.cal.As(actor(r.Context(), userID)).RestoreEvent(userID, id, version, expected)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setETag(w, e)
+	writeResult(w, newEventJSON(e))
+}
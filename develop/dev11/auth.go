@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Principal — аутентифицированный пользователь, от имени которого
+// выполняется запрос.
+type Principal struct {
+	UserID int64
+	// Method — способ аутентификации: key или token.
+	Method string
+}
+
+// Ошибки аутентификации, на них отвечается кодом 401.
+var (
+	errNoCredentials  = &statusError{status: http.StatusUnauthorized, msg: "authentication required"}
+	errBadCredentials = &statusError{status: http.StatusUnauthorized, msg: "invalid credentials"}
+	errTokenExpired   = &statusError{status: http.StatusUnauthorized, msg: "token expired"}
+)
+
+// Authenticator проверяет статические API ключи (заголовок X-API-Key) и
+// подписанные HMAC-SHA256 токены в формате JWT (Authorization: Bearer).
+// Без ключей и секрета аутентификация выключена.
+type Authenticator struct {
+	clock Clock
+
+	mu     sync.RWMutex
+	keys   map[string]int64
+	secret []byte
+}
+
+// NewAuthenticator создаёт выключенную аутентификацию; включается Configure.
+func NewAuthenticator(clock Clock) *Authenticator {
+	return &Authenticator{clock: clock}
+}
+
+// Configure задаёт API ключи (ключ → ID пользователя) и секрет токенов.
+func (a *Authenticator) Configure(keys map[string]int64, secret []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys, a.secret = keys, secret
+}
+
+// Enabled сообщает, требуется ли аутентификация.
+func (a *Authenticator) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.keys) > 0 || len(a.secret) > 0
+}
+
+// Authenticate определяет пользователя по заголовкам запроса.
+// Bearer значение сначала сверяется с API ключами, затем проверяется как токен.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if userID, ok := a.lookupKey(key); ok {
+			return &Principal{UserID: userID, Method: "key"}, nil
+		}
+		return nil, errBadCredentials
+	}
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return nil, errNoCredentials
+	}
+	if userID, ok := a.lookupKey(credentials); ok {
+		return &Principal{UserID: userID, Method: "key"}, nil
+	}
+	if len(a.secret) == 0 {
+		return nil, errBadCredentials
+	}
+	userID, err := verifyToken(a.secret, credentials, a.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{UserID: userID, Method: "token"}, nil
+}
+
+// lookupKey ищет API ключ, сравнивая за постоянное время. Вызывается под mu.
+func (a *Authenticator) lookupKey(key string) (int64, bool) {
+	var found int64
+	for k, userID := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found = userID
+		}
+	}
+	return found, found != 0
+}
+
+// middleware требует аутентификации для всех путей, кроме public,
+// и сохраняет Principal в сведениях о запросе.
+func (a *Authenticator) middleware(public ...string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range public {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if !a.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p, err := a.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="dev11"`)
+				writeError(w, err)
+				return
+			}
+			info := requestInfoFrom(r.Context())
+			if info == nil {
+				info = &requestInfo{}
+				r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+			}
+			info.principal = p
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// principalFrom возвращает пользователя запроса, nil если аутентификация выключена.
+func principalFrom(ctx context.Context) *Principal {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.principal
+	}
+	return nil
+}
+
+// requestUser возвращает владельца календаря, с которым работает запрос, —
+// параметр user_id или, если он не задан, аутентифицированного пользователя —
+// и проверяет, что у пользователя запроса есть к нему доступ need.
+func (s *Server) requestUser(r *http.Request, params url.Values, need AccessLevel) (int64, error) {
+	return s.contextUser(r.Context(), params, need)
+}
+
+// contextUser — requestUser для пользователя, аутентифицированного в ctx,
+// независимо от транспорта запроса.
+func (s *Server) contextUser(ctx context.Context, params url.Values, need AccessLevel) (int64, error) {
+	p := principalFrom(ctx)
+	if p != nil && params.Get("user_id") == "" {
+		params.Set("user_id", strconv.FormatInt(p.UserID, 10))
+	}
+	userID, err := parseInt(params, "user_id")
+	if err != nil {
+		return 0, err
+	}
+	return userID, s.authorize(ctx, userID, need)
+}
+
+// authorize проверяет доступ need пользователя, аутентифицированного в ctx,
+// к календарю owner. Без аутентификации доступ не ограничивается.
+func (s *Server) authorize(ctx context.Context, owner int64, need AccessLevel) error {
+	p := principalFrom(ctx)
+	if p == nil {
+		return nil
+	}
+	return s.cal.CheckAccess(p.UserID, owner, need)
+}
+
+// parseAPIKeys разбирает список ключей вида key=user_id через запятую.
+func parseAPIKeys(spec string) (map[string]int64, error) {
+	keys := make(map[string]int64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, id, ok := strings.Cut(part, "=")
+		userID, err := strconv.ParseInt(id, 10, 64)
+		if !ok || key == "" || err != nil || userID <= 0 {
+			return nil, errors.New("api_keys must be a comma separated list of key=user_id")
+		}
+		keys[key] = userID
+	}
+	return keys, nil
+}
+
+// tokenHeader — заголовок JWT, единственный поддерживаемый алгоритм HS256.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims — утверждения токена.
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SignToken выпускает токен пользователя userID, действующий до expires.
+func SignToken(secret []byte, userID int64, now, expires time.Time) string {
+	claims, _ := json.Marshal(tokenClaims{
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	payload := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+func tokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken проверяет подпись и срок действия токена и возвращает ID пользователя.
+func verifyToken(secret []byte, token string, now time.Time) (int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errBadCredentials
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(tokenSignature(secret, payload))) {
+		return 0, errBadCredentials
+	}
+	// подпись верна, поэтому заголовок может быть только нашим
+	if parts[0] != tokenHeader {
+		return 0, errBadCredentials
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, errBadCredentials
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return 0, errBadCredentials
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, errBadCredentials
+	}
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return 0, errTokenExpired
+	}
+	return userID, nil
+}
+
+// tokenCommand выпускает токен: dev11 token -user 1 -ttl 24h.
+// Секрет берётся из -secret или DEV11_TOKEN_SECRET.
+func tokenCommand(args []string, getenv func(string) string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	userID := fs.Int64("user", 0, "ID пользователя")
+	ttl := fs.Duration("ttl", 24*time.Hour, "срок действия токена")
+	secret := fs.String("secret", getenv(envPrefix+"TOKEN_SECRET"), "секрет подписи токенов")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *userID <= 0 || *secret == "" || *ttl <= 0 {
+		return errors.New("token: -user, -secret and positive -ttl are required")
+	}
+	now := time.Now()
+	fmt.Println(SignToken([]byte(*secret), *userID, now, now.Add(*ttl)))
+	return nil
+}
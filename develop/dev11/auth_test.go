@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	valid := SignToken(secret, 7, now, now.Add(time.Hour))
+	parts := strings.Split(valid, ".")
+	// resign подписывает произвольные заголовок и утверждения секретом secret
+	resign := func(header, claims string) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims))
+		return payload + "." + tokenSignature(secret, payload)
+	}
+	if userID, err := verifyToken(secret, valid, now.Add(59*time.Minute)); err != nil || userID != 7 {
+		t.Errorf("valid token: %d, %v", userID, err)
+	}
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":      {valid, errTokenExpired},
+		"wrong secret": {SignToken([]byte("other"), 7, now, now.Add(time.Hour)), errBadCredentials},
+		"forged subject": {parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","exp":9999999999}`)) +
+			"." + parts[2], errBadCredentials},
+		"alg none":       {resign(`{"alg":"none","typ":"JWT"}`, `{"sub":"7","exp":9999999999}`), errBadCredentials},
+		"no expiry":      {resign(`{"alg":"HS256","typ":"JWT"}`, `{"sub":"7"}`), errTokenExpired},
+		"bad subject":    {resign(`{"alg":"HS256","typ":"JWT"}`, `{"sub":"alice","exp":9999999999}`), errBadCredentials},
+		"two parts":      {parts[0] + "." + parts[1], errBadCredentials},
+		"signature only": {"." + "." + parts[2], errBadCredentials},
+	} {
+		at := now
+		if name == "expired" {
+			at = now.Add(time.Hour)
+		}
+		if _, err := verifyToken(secret, tc.token, at); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)}
+	secret := []byte("test secret")
+	a := NewAuthenticator(clock)
+	if a.Enabled() {
+		t.Fatal("enabled without credentials")
+	}
+	a.Configure(map[string]int64{"alice-key": 1}, secret)
+	token := SignToken(secret, 2, clock.Now(), clock.Now().Add(time.Hour))
+	for _, tc := range []struct {
+		header, value string
+		user          int64
+		method        string
+		err           error
+	}{
+		{"X-Api-Key", "alice-key", 1, "key", nil},
+		{"X-Api-Key", "alice", 0, "", errBadCredentials},
+		{"Authorization", "Bearer alice-key", 1, "key", nil},
+		{"Authorization", "bearer " + token, 2, "token", nil},
+		{"Authorization", "Basic YWxpY2U6a2V5", 0, "", errNoCredentials},
+		{"", "", 0, "", errNoCredentials},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/events_for_day", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		p, err := a.Authenticate(r)
+		if err != tc.err || err == nil && (p.UserID != tc.user || p.Method != tc.method) {
+			t.Errorf("%s: %q: %+v, %v", tc.header, tc.value, p, err)
+		}
+	}
+	clock.set(clock.Now().Add(2 * time.Hour))
+	r, _ := http.NewRequest(http.MethodGet, "/events_for_day", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := a.Authenticate(r); err != errTokenExpired {
+		t.Errorf("expired token: %v", err)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys(" alice-key=1, bob-key=2 ,")
+	if err != nil || len(keys) != 2 || keys["alice-key"] != 1 || keys["bob-key"] != 2 {
+		t.Errorf("keys %v, %v", keys, err)
+	}
+	for _, spec := range []string{"alice-key", "=1", "alice-key=0", "alice-key=one"} {
+		if _, err := parseAPIKeys(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBatchOps ограничивает число операций в одном запросе /batch.
+const maxBatchOps = 1000
+
+// batchRequest — тело запроса /batch.
+type batchRequest struct {
+	// Atomic — выполнить все операции или ни одной.
+	Atomic     bool      `json:"atomic,omitempty"`
+	Operations []batchOp `json:"operations"`
+}
+
+// batchOp — одна операция /batch: имя метода API и его параметры.
+type batchOp struct {
+	Op     string                 `json:"op"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// batchItemJSON — результат операции /batch: код ответа, который вернул
+// бы отдельный вызов метода, и его result или error.
+type batchItemJSON struct {
+	Status int         `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// batchOps — методы, доступные в /batch.
+var batchOps = []string{"create_event", "update_event", "delete_event"}
+
+// batch выполняет список операций create_event, update_event и
+// delete_event с теми же параметрами и проверками, что и отдельные вызовы.
+// Без atomic каждая операция выполняется сама по себе и результаты
+// возвращаются по порядку. С atomic первая неудачная операция отменяет
+// уже выполненные, и ответ — её ошибка с номером операции.
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != jsonType {
+		writeError(w, &statusError{status: http.StatusUnsupportedMediaType, msg: "batch body must be " + jsonType})
+		return
+	}
+	var req batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize))
+	dec.DisallowUnknownFields()
+	// числа параметров передаются дальше без потери точности
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, &inputError{param: "body", msg: err.Error()})
+		return
+	}
+	switch {
+	case len(req.Operations) == 0:
+		writeError(w, &inputError{param: "operations", msg: "required"})
+		return
+	case len(req.Operations) > maxBatchOps:
+		writeError(w, &inputError{param: "operations", msg: "at most " + strconv.Itoa(maxBatchOps) + " operations"})
+		return
+	}
+	// версия задаётся параметром version каждой операции, а не общим If-Match
+	ctx := r.Context()
+	items := make([]batchItemJSON, 0, len(req.Operations))
+	if !req.Atomic {
+		for i, op := range req.Operations {
+			result, err := s.batchOp(ctx, s.cal, i, op)
+			if err != nil {
+				items = append(items, batchItemJSON{Status: errorStatus(err), Error: err.Error()})
+				continue
+			}
+			items = append(items, batchItemJSON{Status: http.StatusOK, Result: result})
+		}
+		writeResult(w, items)
+		return
+	}
+	err := s.cal.Transaction(func(tx *Calendar) error {
+		for i, op := range req.Operations {
+			result, err := s.batchOp(ctx, tx, i, op)
+			if err != nil {
+				return err
+			}
+			items = append(items, batchItemJSON{Status: http.StatusOK, Result: result})
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, items)
+}
+
+// batchOp выполняет операцию номер i над календарём cal. Ошибка содержит
+// номер операции и сохраняет вид исходной ошибки для кода ответа.
+func (s *Server) batchOp(ctx context.Context, cal *Calendar, i int, op batchOp) (interface{}, error) {
+	result, err := s.runBatchOp(ctx, cal, op)
+	if err != nil {
+		return nil, fmt.Errorf("operations[%d] %s: %w", i, op.Op, err)
+	}
+	return result, nil
+}
+
+func (s *Server) runBatchOp(ctx context.Context, cal *Calendar, op batchOp) (interface{}, error) {
+	// параметры проходят тот же разбор, что и тело JSON отдельного вызова
+	body, err := json.Marshal(op.Params)
+	if err != nil {
+		return nil, &inputError{param: "params", msg: err.Error()}
+	}
+	params, err := jsonParams(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "create_event":
+		e, conflicts, err := s.createOp(ctx, cal, params)
+		if err != nil {
+			return nil, err
+		}
+		return newSavedEventJSON(e, conflicts), nil
+	case "update_event":
+		e, conflicts, err := s.updateOp(ctx, cal, params)
+		if err != nil {
+			return nil, err
+		}
+		return newSavedEventJSON(e, conflicts), nil
+	case "delete_event":
+		if err := s.deleteOp(ctx, cal, params); err != nil {
+			return nil, err
+		}
+		return "event deleted", nil
+	default:
+		return nil, &inputError{param: "op", msg: "must be one of " + strings.Join(batchOps, ", ")}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 9, 0, 0, 0, time.UTC) }
+	create := func(e Event) Event {
+		t.Helper()
+		e.UserID = 1
+		e.End = e.Start.Add(time.Hour)
+		created, _, err := cal.CreateEvent(e, AllowConflicts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+	kept := create(Event{Title: "Kept", Start: day(19)})
+	deleted := create(Event{Title: "Deleted", Start: day(20)})
+	series := create(Event{Title: "Standup", Start: day(19), Rule: &Rule{Freq: Daily, Interval: 1, Count: 5}})
+
+	last := cal.Changes().Last()
+	errAbort := errors.New("abort")
+	err := cal.Transaction(func(tx *Calendar) error {
+		if _, _, err := tx.CreateEvent(Event{UserID: 1, Title: "New", Start: day(21), End: day(21).Add(time.Hour)}, AllowConflicts); err != nil {
+			return err
+		}
+		title := "Changed"
+		if _, _, err := tx.UpdateEvent(1, kept.ID, EventUpdate{Title: &title}, AllowConflicts); err != nil {
+			return err
+		}
+		if err := tx.DeleteEvent(1, deleted.ID, 0); err != nil {
+			return err
+		}
+		if _, _, err := tx.UpdateOccurrence(1, series.ID, day(22), EventUpdate{Title: &title}, AllowConflicts); err != nil {
+			return err
+		}
+		if got := cal.Changes().Last(); got != last {
+			t.Errorf("%d changes published during the transaction", got-last)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("transaction: %v, want %v", err, errAbort)
+	}
+	// подписчики не видят ни отменённых изменений, ни их отката
+	if got := cal.Changes().Last(); got != last {
+		t.Errorf("%d changes published for a rolled back transaction", got-last)
+	}
+	events, err := cal.UserEvents(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, strconv.FormatInt(e.ID, 10)+":"+e.Title+"/"+strconv.Itoa(len(e.Exceptions)))
+	}
+	want := []string{
+		strconv.FormatInt(kept.ID, 10) + ":Kept/0",
+		strconv.FormatInt(deleted.ID, 10) + ":Deleted/0",
+		strconv.FormatInt(series.ID, 10) + ":Standup/0",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("after rollback %v, want %v", got, want)
+	}
+}
+
+// TestTransactionConcurrentChange проверяет, что откат не затирает
+// изменение, сделанное другим запросом во время транзакции.
+func TestTransactionConcurrentChange(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	e, _, err := cal.CreateEvent(Event{UserID: 1, Title: "Original", Start: start, End: start.Add(time.Hour)}, AllowConflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errAbort := errors.New("abort")
+	err = cal.Transaction(func(tx *Calendar) error {
+		title := "In transaction"
+		if _, _, err := tx.UpdateEvent(1, e.ID, EventUpdate{Title: &title}, AllowConflicts); err != nil {
+			return err
+		}
+		title = "Concurrent"
+		if _, _, err := cal.UpdateEvent(1, e.ID, EventUpdate{Title: &title}, AllowConflicts); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) || !strings.Contains(err.Error(), "rollback incomplete") {
+		t.Errorf("transaction: %v", err)
+	}
+	if cur, err := cal.Event(1, e.ID); err != nil || cur.Title != "Concurrent" {
+		t.Errorf("after rollback %+v, %v", cur, err)
+	}
+	// изменение транзакции осталось, поэтому подписчики о нём узнают
+	changes, _, _ := cal.Changes().Since(1, 0)
+	var titles []string
+	for _, c := range changes {
+		titles = append(titles, c.Event.Title)
+	}
+	if strings.Join(titles, ", ") != "Original, Concurrent, In transaction" {
+		t.Errorf("published %v after an incomplete rollback", titles)
+	}
+}
+
+func TestTransactionPublish(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	var created Event
+	err := cal.Transaction(func(tx *Calendar) error {
+		var err error
+		if created, _, err = tx.CreateEvent(Event{UserID: 1, Title: "New", Start: start, End: start.Add(time.Hour)}, AllowConflicts); err != nil {
+			return err
+		}
+		title := "Renamed"
+		_, _, err = tx.UpdateEvent(1, created.ID, EventUpdate{Title: &title}, AllowConflicts)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, _, ok := cal.Changes().Since(1, 0)
+	if !ok || len(changes) != 2 || changes[0].Kind != ChangeCreated || changes[1].Kind != ChangeUpdated ||
+		changes[1].Event.Title != "Renamed" || changes[0].Seq+1 != changes[1].Seq {
+		t.Errorf("published %+v, want created and updated", changes)
+	}
+}
+
+func TestBatchPartial(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	header := http.Header{"Content-Type": {jsonType}}
+	body := `{"operations": [
+		{"op": "create_event", "params": {"user_id": 1, "title": "New", "date": "2026-10-19"}},
+		{"op": "update_event", "params": {"user_id": 1, "id": 999, "title": "Missing"}},
+		{"op": "create_event", "params": {"user_id": 1, "title": ""}}
+	]}`
+	var items []batchItemJSON
+	env.do(http.MethodPost, "/batch", nil, header, body).expect(t, http.StatusOK).decode(t, &items)
+	var statuses []int
+	for _, it := range items {
+		statuses = append(statuses, it.Status)
+	}
+	if len(statuses) != 3 || statuses[0] != http.StatusOK || statuses[1] != http.StatusServiceUnavailable ||
+		statuses[2] != http.StatusBadRequest {
+		t.Errorf("statuses %v", statuses)
+	}
+	env.do(http.MethodPost, "/batch", nil, header, `{"operations": [{"op": "events_for_day", "params": {}}]}`).
+		expect(t, http.StatusOK).decode(t, &items)
+	if items[0].Status != http.StatusBadRequest {
+		t.Errorf("unsupported operation %+v", items[0])
+	}
+}
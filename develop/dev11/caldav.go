@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Минимальное подмножество CalDAV (RFC 4791): у каждого пользователя одна
+// коллекция /caldav/{user_id}/, а каждое событие (серия вместе с изменёнными
+// повторениями) — ресурс /caldav/{user_id}/{uid}.ics.
+// Поддерживаются OPTIONS, PROPFIND, REPORT (calendar-query с time-range и
+// calendar-multiget), GET, PUT и DELETE.
+
+const caldavPrefix = "/caldav/"
+
+// Пространства имён XML.
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+)
+
+// etagTime — фиксированный DTSTAMP для вычисления ETag, чтобы тег зависел
+// только от содержимого события.
+var etagTime = time.Unix(0, 0)
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XmlnsD    string        `xml:"xmlns:D,attr"`
+	XmlnsC    string        `xml:"xmlns:C,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string       `xml:"D:href"`
+	Propstat *davPropstat `xml:"D:propstat,omitempty"`
+	Status   string       `xml:"D:status,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	ResourceType *davResourceType `xml:"D:resourcetype,omitempty"`
+	DisplayName  string           `xml:"D:displayname,omitempty"`
+	ContentType  string           `xml:"D:getcontenttype,omitempty"`
+	ETag         string           `xml:"D:getetag,omitempty"`
+	ComponentSet *davCompSet      `xml:"C:supported-calendar-component-set,omitempty"`
+	CalendarData string           `xml:"C:calendar-data,omitempty"`
+	CurrentUser  *davHref         `xml:"D:current-user-principal,omitempty"`
+	CalendarHome *davHref         `xml:"C:calendar-home-set,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+	Calendar   *struct{} `xml:"C:calendar,omitempty"`
+}
+
+type davCompSet struct {
+	Comp []davComp `xml:"C:comp"`
+}
+
+type davComp struct {
+	Name string `xml:"name,attr"`
+}
+
+type davHref struct {
+	Href string `xml:"D:href"`
+}
+
+// davReport — тело REPORT: calendar-query или calendar-multiget.
+type davReport struct {
+	XMLName xml.Name
+	Filter  struct {
+		CompFilter davCompFilter `xml:"comp-filter"`
+	} `xml:"filter"`
+	Hrefs []string `xml:"href"`
+}
+
+type davCompFilter struct {
+	Name        string          `xml:"name,attr"`
+	TimeRange   *davTimeRange   `xml:"time-range"`
+	CompFilters []davCompFilter `xml:"comp-filter"`
+}
+
+type davTimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// timeRange ищет time-range во вложенных фильтрах компонентов.
+func (f davCompFilter) timeRange() *davTimeRange {
+	if f.TimeRange != nil {
+		return f.TimeRange
+	}
+	for _, sub := range f.CompFilters {
+		if tr := sub.timeRange(); tr != nil {
+			return tr
+		}
+	}
+	return nil
+}
+
+// caldavPath разбирает путь запроса на пользователя и имя ресурса.
+func caldavPath(p string) (userID int64, resource string, err error) {
+	rest := strings.Trim(strings.TrimPrefix(p, caldavPrefix), "/")
+	if rest == "" {
+		return 0, "", nil
+	}
+	parts := strings.SplitN(rest, "/", 2)
+	userID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, "", &inputError{param: "user_id", msg: "must be a positive integer"}
+	}
+	if len(parts) == 2 {
+		resource = parts[1]
+		if !strings.HasSuffix(resource, ".ics") || strings.Contains(resource, "/") {
+			return 0, "", &inputError{param: "resource", msg: "must be {uid}.ics"}
+		}
+	}
+	return userID, resource, nil
+}
+
+func collectionHref(userID int64) string {
+	return fmt.Sprintf("%s%d/", caldavPrefix, userID)
+}
+
+func resourceHref(userID int64, uid string) string {
+	return collectionHref(userID) + uid + ".ics"
+}
+
+// resourceICal возвращает содержимое ресурса и его ETag.
+func resourceICal(events []Event, now time.Time) ([]byte, string, error) {
+	var tagBuf bytes.Buffer
+	if err := writeICal(&tagBuf, events, etagTime); err != nil {
+		return nil, "", err
+	}
+	h := fnv.New64a()
+	h.Write(tagBuf.Bytes())
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+
+	var buf bytes.Buffer
+	if err := writeICal(&buf, events, now); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), etag, nil
+}
+
+// groupResources группирует события по ресурсам: серия вместе с её
+// изменёнными повторениями. Ключ — UID ресурса.
+func groupResources(events []Event) map[string][]Event {
+	uids := make(map[int64]string)
+	res := make(map[string][]Event)
+	for _, e := range events {
+		if e.SeriesID == 0 {
+			uids[e.ID] = e.uid()
+			res[e.uid()] = append([]Event{e}, res[e.uid()]...)
+		}
+	}
+	for _, e := range events {
+		if e.SeriesID != 0 {
+			uid, ok := uids[e.SeriesID]
+			if !ok {
+				uid = e.uid()
+			}
+			res[uid] = append(res[uid], e)
+		}
+	}
+	return res
+}
+
+// caldav обрабатывает все запросы к /caldav/.
+func (s *Server) caldav(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, calendar-access")
+	userID, resource, err := caldavPath(r.URL.Path)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	uid := strings.TrimSuffix(resource, ".ics")
+	if userID != 0 && r.Method != http.MethodOptions {
+		need := AccessRead
+		if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			need = AccessWrite
+		}
+		if err := s.authorize(r.Context(), userID, need); err != nil {
+			caldavError(w, err)
+			return
+		}
+	}
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, PUT, DELETE")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "PROPFIND" && userID == 0:
+		s.caldavPropfindRoot(w, r)
+	case r.Method == "PROPFIND" && resource == "":
+		s.caldavPropfindCollection(w, r, userID)
+	case r.Method == "PROPFIND":
+		s.caldavPropfindResource(w, userID, uid)
+	case r.Method == "REPORT" && userID != 0 && resource == "":
+		s.caldavReport(w, r, userID)
+	case r.Method == http.MethodGet && resource != "":
+		s.caldavGet(w, userID, uid)
+	case r.Method == http.MethodPut && resource != "":
+		s.caldavPut(w, r, userID, uid)
+	case r.Method == http.MethodDelete && resource != "":
+		s.caldavDelete(w, r, userID, uid)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func caldavError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if errors.Is(err, ErrEventNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []davResponse) {
+	ms := davMultistatus{XmlnsD: nsDAV, XmlnsC: nsCalDAV, Responses: responses}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(ms); err != nil {
+		log.Printf("write multistatus: %v", err)
+	}
+}
+
+func okPropstat(p davProp) *davPropstat {
+	return &davPropstat{Prop: p, Status: "HTTP/1.1 200 OK"}
+}
+
+// caldavPropfindRoot отвечает на обнаружение сервиса клиентом.
+func (s *Server) caldavPropfindRoot(w http.ResponseWriter, r *http.Request) {
+	prop := davProp{ResourceType: &davResourceType{Collection: &struct{}{}}}
+	if p := principalFrom(r.Context()); p != nil {
+		prop.CurrentUser = &davHref{Href: collectionHref(p.UserID)}
+		prop.CalendarHome = &davHref{Href: collectionHref(p.UserID)}
+	} else if userID, err := parseInt(r.URL.Query(), "user_id"); err == nil {
+		prop.CurrentUser = &davHref{Href: collectionHref(userID)}
+		prop.CalendarHome = &davHref{Href: collectionHref(userID)}
+	}
+	writeMultistatus(w, []davResponse{{Href: caldavPrefix, Propstat: okPropstat(prop)}})
+}
+
+func collectionProp(userID int64) davProp {
+	return davProp{
+		ResourceType: &davResourceType{Collection: &struct{}{}, Calendar: &struct{}{}},
+		DisplayName:  fmt.Sprintf("Calendar of user %d", userID),
+		ComponentSet: &davCompSet{Comp: []davComp{{Name: "VEVENT"}}},
+	}
+}
+
+func (s *Server) caldavPropfindCollection(w http.ResponseWriter, r *http.Request, userID int64) {
+	responses := []davResponse{{Href: collectionHref(userID), Propstat: okPropstat(collectionProp(userID))}}
+	if r.Header.Get("Depth") == "1" {
+		events, err := s.cal.UserEvents(userID)
+		if err != nil {
+			caldavError(w, err)
+			return
+		}
+		resources := groupResources(events)
+		for _, uid := range sortedKeys(resources) {
+			_, etag, err := resourceICal(resources[uid], time.Now())
+			if err != nil {
+				caldavError(w, err)
+				return
+			}
+			responses = append(responses, davResponse{
+				Href:     resourceHref(userID, uid),
+				Propstat: okPropstat(davProp{ContentType: "text/calendar; component=vevent", ETag: etag}),
+			})
+		}
+	}
+	writeMultistatus(w, responses)
+}
+
+func (s *Server) caldavPropfindResource(w http.ResponseWriter, userID int64, uid string) {
+	events, err := s.cal.EventsByUID(userID, uid)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	_, etag, err := resourceICal(events, time.Now())
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	writeMultistatus(w, []davResponse{{
+		Href:     resourceHref(userID, uid),
+		Propstat: okPropstat(davProp{ContentType: "text/calendar; component=vevent", ETag: etag}),
+	}})
+}
+
+// caldavReport выполняет calendar-query или calendar-multiget.
+func (s *Server) caldavReport(w http.ResponseWriter, r *http.Request, userID int64) {
+	var rep davReport
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&rep); err != nil {
+		caldavError(w, &inputError{param: "body", msg: err.Error()})
+		return
+	}
+	events, err := s.cal.UserEvents(userID)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	resources := groupResources(events)
+
+	var uids []string
+	switch rep.XMLName.Local {
+	case "calendar-query":
+		uids, err = s.queryResources(userID, resources, rep.Filter.CompFilter.timeRange())
+		if err != nil {
+			caldavError(w, err)
+			return
+		}
+	case "calendar-multiget":
+		for _, href := range rep.Hrefs {
+			uids = append(uids, strings.TrimSuffix(href[strings.LastIndexByte(href, '/')+1:], ".ics"))
+		}
+	default:
+		caldavError(w, &inputError{param: "body", msg: "unsupported report " + rep.XMLName.Local})
+		return
+	}
+
+	now := time.Now()
+	responses := make([]davResponse, 0, len(uids))
+	for _, uid := range uids {
+		res, ok := resources[uid]
+		if !ok {
+			responses = append(responses, davResponse{Href: resourceHref(userID, uid), Status: "HTTP/1.1 404 Not Found"})
+			continue
+		}
+		data, etag, err := resourceICal(res, now)
+		if err != nil {
+			caldavError(w, err)
+			return
+		}
+		responses = append(responses, davResponse{
+			Href:     resourceHref(userID, uid),
+			Propstat: okPropstat(davProp{ETag: etag, CalendarData: string(data)}),
+		})
+	}
+	writeMultistatus(w, responses)
+}
+
+// queryResources возвращает UID ресурсов, у которых есть повторения в tr.
+// Без time-range возвращаются все ресурсы.
+func (s *Server) queryResources(userID int64, resources map[string][]Event, tr *davTimeRange) ([]string, error) {
+	if tr == nil {
+		return sortedKeys(resources), nil
+	}
+	from, err := parseDAVTime(tr.Start, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseDAVTime(tr.End, from.AddDate(1, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	matched, err := s.cal.EventsBetween(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]string)
+	for uid, res := range resources {
+		for _, e := range res {
+			byID[e.ID] = uid
+		}
+	}
+	seen := make(map[string]bool)
+	var uids []string
+	for _, e := range matched {
+		// приглашения из чужих календарей в коллекцию не входят
+		if uid, ok := byID[e.ID]; ok && !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	return uids, nil
+}
+
+func parseDAVTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	t, err := time.Parse(icalDateTimeLayout, v)
+	if err != nil {
+		return time.Time{}, &inputError{param: "time-range", msg: err.Error()}
+	}
+	return t, nil
+}
+
+func (s *Server) caldavGet(w http.ResponseWriter, userID int64, uid string) {
+	events, err := s.cal.EventsByUID(userID, uid)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	data, etag, err := resourceICal(events, time.Now())
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", etag)
+	w.Write(data)
+}
+
+// checkPrecondition проверяет If-Match и If-None-Match относительно
+// текущего ETag ресурса (пустой, если ресурса нет).
+func checkPrecondition(r *http.Request, etag string) bool {
+	if m := r.Header.Get("If-Match"); m != "" && (etag == "" || (m != "*" && m != etag)) {
+		return false
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && etag != "" && (m == "*" || m == etag) {
+		return false
+	}
+	return true
+}
+
+// currentETag возвращает ETag ресурса или пустую строку, если его нет.
+func (s *Server) currentETag(userID int64, uid string) (string, error) {
+	_, etag, err := s.currentResource(userID, uid)
+	return etag, err
+}
+
+// currentResource возвращает события ресурса и его ETag; если ресурса нет —
+// nil и пустую строку.
+func (s *Server) currentResource(userID int64, uid string) ([]Event, string, error) {
+	events, err := s.cal.EventsByUID(userID, uid)
+	if errors.Is(err, ErrEventNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	_, etag, err := resourceICal(events, etagTime)
+	return events, etag, err
+}
+
+// caldavPut сохраняет ресурс. Условия If-Match и If-None-Match проверяются
+// по прочитанному ресурсу, а его версии передаются в импорт: если ресурс
+// успел измениться, PUT получает 412, а не затирает чужое изменение.
+func (s *Server) caldavPut(w http.ResponseWriter, r *http.Request, userID int64, uid string) {
+	current, etag, err := s.currentResource(userID, uid)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	if !checkPrecondition(r, etag) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	loc, err := s.cal.UserLocation(userID)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	events, err := readICal(http.MaxBytesReader(w, r.Body, maxImportSize), loc)
+	if err != nil {
+		caldavError(w, &inputError{param: "body", msg: err.Error()})
+		return
+	}
+	if len(events) == 0 {
+		caldavError(w, &inputError{param: "body", msg: "no VEVENT in calendar"})
+		return
+	}
+	for _, e := range events {
+		if e.UID != uid {
+			caldavError(w, &inputError{param: "body", msg: "UID must match resource name"})
+			return
+		}
+	}
+	res, err := s.cal.As(actor(r.Context(), userID)).ImportResource(userID, uid, current, events)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	if etag, err := s.currentETag(userID, uid); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	if res.Created > 0 && etag == "" {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) caldavDelete(w http.ResponseWriter, r *http.Request, userID int64, uid string) {
+	etag, err := s.currentETag(userID, uid)
+	if err != nil {
+		caldavError(w, err)
+		return
+	}
+	if etag == "" {
+		http.Error(w, ErrEventNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !checkPrecondition(r, etag) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if err := s.cal.As(actor(r.Context(), userID)).DeleteByUID(userID, uid); err != nil {
+		caldavError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sortedKeys(m map[string][]Event) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
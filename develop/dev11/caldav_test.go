@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCalDAVPreconditions(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	ics := http.Header{"Content-Type": {calendarType}}
+	const path = "/caldav/1/planning@test.ics"
+	etag := env.do(http.MethodPut, path, nil, ics, icsEvent).expect(t, http.StatusCreated).header.Get("ETag")
+
+	// If-None-Match: * защищает от перезаписи существующего ресурса
+	create := http.Header{"Content-Type": {calendarType}, "If-None-Match": {"*"}}
+	env.do(http.MethodPut, path, nil, create, icsEvent).expect(t, http.StatusPreconditionFailed)
+
+	renamed := strings.Replace(icsEvent, "SUMMARY:Planning", "SUMMARY:Review", 1)
+	stale := http.Header{"Content-Type": {calendarType}, "If-Match": {`"stale"`}}
+	env.do(http.MethodPut, path, nil, stale, renamed).expect(t, http.StatusPreconditionFailed)
+	match := http.Header{"Content-Type": {calendarType}, "If-Match": {etag}}
+	updated := env.do(http.MethodPut, path, nil, match, renamed).expect(t, http.StatusNoContent).header.Get("ETag")
+	if updated == "" || updated == etag {
+		t.Errorf("ETag after update %q, before %q", updated, etag)
+	}
+	env.do(http.MethodDelete, path, nil, http.Header{"If-Match": {etag}}, "").expect(t, http.StatusPreconditionFailed)
+	env.do(http.MethodDelete, path, nil, http.Header{"If-Match": {updated}}, "").expect(t, http.StatusNoContent)
+
+	mismatch := strings.Replace(icsEvent, "UID:planning@test", "UID:other@test", 1)
+	env.do(http.MethodPut, path, nil, ics, mismatch).expect(t, http.StatusBadRequest)
+}
+
+func TestCalDAVCalendarQuery(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	ics := http.Header{"Content-Type": {calendarType}}
+	env.do(http.MethodPut, "/caldav/1/planning@test.ics", nil, ics, icsEvent).expect(t, http.StatusCreated)
+	query := func(start, end string) string {
+		body := `<?xml version="1.0"?><C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+			`<D:prop><D:getetag/><C:calendar-data/></D:prop><C:filter><C:comp-filter name="VCALENDAR">` +
+			`<C:comp-filter name="VEVENT"><C:time-range start="` + start + `" end="` + end + `"/>` +
+			`</C:comp-filter></C:comp-filter></C:filter></C:calendar-query>`
+		r := env.do("REPORT", "/caldav/1/", nil, http.Header{"Content-Type": {"application/xml"}, "Depth": {"1"}}, body)
+		return string(r.expect(t, http.StatusMultiStatus).body)
+	}
+	if body := query("20261020T000000Z", "20261021T000000Z"); !strings.Contains(body, "/caldav/1/planning@test.ics") ||
+		!strings.Contains(body, "SUMMARY:Planning") {
+		t.Errorf("query on the event day: %s", body)
+	}
+	if body := query("20261021T000000Z", "20261022T000000Z"); strings.Contains(body, "planning@test") {
+		t.Errorf("query on the next day: %s", body)
+	}
+
+	multiget := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+		`<D:prop><D:getetag/></D:prop><D:href>/caldav/1/planning@test.ics</D:href><D:href>/caldav/1/missing.ics</D:href>` +
+		`</C:calendar-multiget>`
+	body := string(env.do("REPORT", "/caldav/1/", nil, nil, multiget).expect(t, http.StatusMultiStatus).body)
+	if !strings.Contains(body, "SUMMARY:Planning") || !strings.Contains(body, "404 Not Found") {
+		t.Errorf("multiget: %s", body)
+	}
+}
+
+// TestCalDAVConcurrentCreate проверяет, что из одновременных PUT с
+// If-None-Match: * ресурс создаёт только один.
+func TestCalDAVConcurrentCreate(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	const n = 8
+	statuses := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPut, env.url+"/caldav/1/planning@test.ics", strings.NewReader(icsEvent))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Content-Type", calendarType)
+			req.Header.Set("If-None-Match", "*")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	count := make(map[int]int)
+	for status := range statuses {
+		count[status]++
+	}
+	if count[http.StatusCreated] != 1 || count[http.StatusPreconditionFailed] != n-1 {
+		t.Errorf("statuses %v, want one 201 and %d 412", count, n-1)
+	}
+	events, err := env.cal.store.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("%d events stored, want 1", len(events))
+	}
+}
+
+// TestImportResourceStale проверяет, что импорт ресурса, изменённого после
+// проверки условий, получает ErrVersionConflict.
+func TestImportResourceStale(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	start := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	put := func(title string) []Event {
+		return []Event{{UID: "planning@test", Title: title, Start: start, End: start.Add(time.Hour)}}
+	}
+	if _, err := cal.ImportResource(1, "planning@test", nil, put("Planning")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cal.ImportResource(1, "planning@test", nil, put("Again")); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("create over an existing resource: %v, want %v", err, ErrVersionConflict)
+	}
+	current, err := cal.EventsByUID(1, "planning@test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	title := "Moved"
+	if _, _, err := cal.UpdateEvent(1, current[0].ID, EventUpdate{Title: &title}, AllowConflicts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cal.ImportResource(1, "planning@test", current, put("Review")); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("import over a changed resource: %v, want %v", err, ErrVersionConflict)
+	}
+	if err := cal.DeleteByUID(1, "planning@test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cal.ImportResource(1, "planning@test", current, put("Review")); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("import over a deleted resource: %v, want %v", err, ErrVersionConflict)
+	}
+}
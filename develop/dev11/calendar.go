@@ -0,0 +1,651 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Calendar — бизнес-логика календаря. Не зависит от HTTP сервера,
+// все данные хранятся в EventStore.
+type Calendar struct {
+	store EventStore
+	// changes — журнал изменений событий для подписчиков.
+	changes *ChangeLog
+	// index — поисковый индекс событий.
+	index *searchIndex
+	// txMu выполняет транзакции по одной.
+	txMu *sync.Mutex
+	// undo — журнал отката, если календарь выполняет транзакцию.
+	undo *undoLog
+	// actor — пользователь, от имени которого изменения пишутся в аудит,
+	// 0 — сам сервер.
+	actor int64
+}
+
+// NewCalendar создаёт календарь поверх хранилища store.
+func NewCalendar(store EventStore) *Calendar {
+	return &Calendar{store: store, changes: NewChangeLog(), index: newSearchIndex(), txMu: new(sync.Mutex)}
+}
+
+// EventUpdate — изменяемые поля события, nil означает «не менять».
+// Date переносит событие на другую календарную дату с тем же временем суток,
+// Start и End задают новое время явно; новый Start без End сохраняет
+// длительность события. RemoveRule превращает серию
+// в одиночное событие. Непустой Reminders заменяет напоминания,
+// RemoveReminders убирает их; так же Tags и RemoveTags для меток
+// и Attendees и RemoveAttendees для участников. Version — ожидаемая версия
+// события (для повторения — серии), 0 — любая.
+type EventUpdate struct {
+	Version int64
+
+	Title       *string
+	Description *string
+	Date        *time.Time
+	Start       *time.Time
+	End         *time.Time
+	AllDay      *bool
+	TZ          *string
+	Rule        *Rule
+	RemoveRule  bool
+	Exceptions  []time.Time
+
+	Reminders       []time.Duration
+	RemoveReminders bool
+
+	Tags       []string
+	RemoveTags bool
+
+	Attendees       []int64
+	RemoveAttendees bool
+}
+
+func (upd EventUpdate) apply(e *Event) {
+	if upd.Title != nil {
+		e.Title = *upd.Title
+	}
+	if upd.Description != nil {
+		e.Description = *upd.Description
+	}
+	if upd.TZ != nil {
+		e.TZ = *upd.TZ
+	}
+	if upd.Date != nil {
+		e.moveTo(*upd.Date)
+	}
+	if upd.Start != nil {
+		// без нового конца событие сохраняет длительность
+		e.End = upd.Start.Add(e.End.Sub(e.Start))
+		e.Start = *upd.Start
+	}
+	if upd.End != nil {
+		e.End = *upd.End
+	}
+	if upd.AllDay != nil {
+		e.AllDay = *upd.AllDay
+	}
+	if upd.Rule != nil {
+		e.Rule = upd.Rule
+	}
+	if upd.RemoveRule {
+		e.Rule = nil
+		e.Exceptions = nil
+	}
+	for _, ex := range upd.Exceptions {
+		e.addException(ex)
+	}
+	if upd.Reminders != nil {
+		e.Reminders = upd.Reminders
+	}
+	if upd.RemoveReminders {
+		e.Reminders = nil
+	}
+	if upd.Tags != nil {
+		e.Tags = normalizeTags(upd.Tags)
+	}
+	if upd.RemoveTags {
+		e.Tags = nil
+	}
+	if upd.Attendees != nil {
+		e.setAttendees(upd.Attendees)
+	}
+	if upd.RemoveAttendees {
+		e.Attendees = nil
+	}
+}
+
+// CreateEvent создаёт событие пользователя. Пересечения с другими
+// событиями обрабатываются согласно policy и возвращаются вместе с событием.
+func (c *Calendar) CreateEvent(e Event, policy ConflictPolicy) (Event, []Conflict, error) {
+	if e.Exceptions != nil {
+		// срез принадлежит вызывающему
+		exceptions := make([]time.Time, len(e.Exceptions))
+		for i, ex := range e.Exceptions {
+			exceptions[i] = truncateDay(ex)
+		}
+		e.Exceptions = exceptions
+	}
+	e.Tags = normalizeTags(e.Tags)
+	if err := e.validate(); err != nil {
+		return Event{}, nil, err
+	}
+	conflicts, err := c.checkConflicts(e, policy)
+	if err != nil {
+		return Event{}, conflicts, err
+	}
+	e, err = c.create(e)
+	return e, conflicts, err
+}
+
+// UpdateEvent меняет событие id, принадлежащее пользователю userID.
+// Для серии изменения применяются ко всем повторениям.
+func (c *Calendar) UpdateEvent(userID, id int64, upd EventUpdate, policy ConflictPolicy) (Event, []Conflict, error) {
+	var (
+		e         Event
+		conflicts []Conflict
+	)
+	err := retryStale(upd.Version, func() error {
+		var err error
+		if e, err = c.userEvent(userID, id); err != nil {
+			return err
+		}
+		if err := checkVersion(e, upd.Version); err != nil {
+			return err
+		}
+		upd.apply(&e)
+		if err := e.validate(); err != nil {
+			return err
+		}
+		if conflicts, err = c.checkConflicts(e, policy); err != nil {
+			return err
+		}
+		return c.update(&e)
+	})
+	if err != nil {
+		return Event{}, conflicts, err
+	}
+	return e, conflicts, nil
+}
+
+// UpdateOccurrence меняет одно повторение серии id, приходящееся на day.
+// Повторение исключается из серии и сохраняется как отдельное событие.
+func (c *Calendar) UpdateOccurrence(userID, id int64, day time.Time, upd EventUpdate, policy ConflictPolicy) (Event, []Conflict, error) {
+	var (
+		occ       Event
+		conflicts []Conflict
+	)
+	err := retryStale(upd.Version, func() error {
+		var err error
+		occ, conflicts, err = c.updateOccurrence(userID, id, day, upd, policy)
+		return err
+	})
+	if err != nil {
+		return Event{}, conflicts, err
+	}
+	return occ, conflicts, nil
+}
+
+func (c *Calendar) updateOccurrence(userID, id int64, day time.Time, upd EventUpdate, policy ConflictPolicy) (Event, []Conflict, error) {
+	series, err := c.userOccurrence(userID, id, day)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if err := checkVersion(series, upd.Version); err != nil {
+		return Event{}, nil, err
+	}
+	occ := series.instance(day)
+	occ.ID = 0
+	occ.Rule = nil
+	occ.Exceptions = nil
+	occ.SeriesID = series.ID
+	recurrenceID := truncateDay(day)
+	occ.RecurrenceID = &recurrenceID
+	occ.UID = ""
+	upd.Rule, upd.RemoveRule, upd.Exceptions = nil, false, nil
+	upd.apply(&occ)
+	if err := occ.validate(); err != nil {
+		return Event{}, nil, err
+	}
+	conflicts, err := c.checkConflicts(occ, policy)
+	if err != nil {
+		return Event{}, conflicts, err
+	}
+	// повторение сохраняется до исключения из серии: при ошибке на любом
+	// шаге оно остаётся в серии, а не пропадает
+	if occ, err = c.create(occ); err != nil {
+		return Event{}, conflicts, err
+	}
+	series.addException(day)
+	if err := c.update(&series); err != nil {
+		if rerr := c.remove(occ); rerr != nil {
+			return Event{}, conflicts, fmt.Errorf("%w (remove detached occurrence %d: %v)", err, occ.ID, rerr)
+		}
+		return Event{}, conflicts, err
+	}
+	return occ, conflicts, nil
+}
+
+// DeleteEvent удаляет событие id, принадлежащее пользователю userID,
+// если его версия равна version (0 — любая).
+// Удаление серии удаляет и её отдельно изменённые повторения.
+func (c *Calendar) DeleteEvent(userID, id, version int64) error {
+	var e Event
+	err := retryStale(version, func() error {
+		var err error
+		if e, err = c.userEvent(userID, id); err != nil {
+			return err
+		}
+		if err := checkVersion(e, version); err != nil {
+			return err
+		}
+		return c.remove(e)
+	})
+	if err != nil || e.Rule == nil {
+		return err
+	}
+	events, err := c.store.List(userID)
+	if err != nil {
+		return err
+	}
+	for _, detached := range events {
+		if detached.SeriesID == id {
+			if err := c.remove(detached); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteOccurrence удаляет одно повторение серии id, приходящееся на day,
+// если версия серии равна version (0 — любая).
+func (c *Calendar) DeleteOccurrence(userID, id int64, day time.Time, version int64) error {
+	return retryStale(version, func() error {
+		series, err := c.userOccurrence(userID, id, day)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(series, version); err != nil {
+			return err
+		}
+		series.addException(day)
+		return c.update(&series)
+	})
+}
+
+// staleRetries — сколько раз изменение повторяется, если событие успел
+// изменить конкурентный запрос.
+const staleRetries = 3
+
+// retryStale выполняет op, повторяя его при ErrVersionConflict, если
+// вызывающий не требовал конкретной версии (version равен 0): тогда
+// изменение применяется к свежему состоянию события, а не теряется.
+func retryStale(version int64, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if version != 0 || attempt == staleRetries || !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+	}
+}
+
+// checkVersion возвращает ErrVersionConflict, если версия e не равна
+// ожидаемой version (0 — любая).
+func checkVersion(e Event, version int64) error {
+	if version != 0 && e.Version != version {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Границы дня, недели и месяца считаются по часам зоны date.Location(),
+// то есть в часовом поясе того, кто запрашивает события. В дни перевода
+// часов сутки длятся 23 или 25 часов.
+
+// EventsForDay возвращает события пользователя за день date.
+func (c *Calendar) EventsForDay(userID int64, date time.Time) ([]Event, error) {
+	loc := date.Location()
+	return c.EventsBetween(userID,
+		time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc),
+		time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, loc))
+}
+
+// EventsForWeek возвращает события пользователя за неделю (с понедельника),
+// в которую входит date.
+func (c *Calendar) EventsForWeek(userID int64, date time.Time) ([]Event, error) {
+	loc := date.Location()
+	monday := date.Day() - (int(date.Weekday())+6)%7
+	return c.EventsBetween(userID,
+		time.Date(date.Year(), date.Month(), monday, 0, 0, 0, 0, loc),
+		time.Date(date.Year(), date.Month(), monday+7, 0, 0, 0, 0, loc))
+}
+
+// EventsForMonth возвращает события пользователя за месяц, в который входит date.
+func (c *Calendar) EventsForMonth(userID int64, date time.Time) ([]Event, error) {
+	loc := date.Location()
+	return c.EventsBetween(userID,
+		time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, loc),
+		time.Date(date.Year(), date.Month()+1, 1, 0, 0, 0, 0, loc))
+}
+
+// SetUserZone задаёт часовой пояс пользователя по умолчанию.
+func (c *Calendar) SetUserZone(userID int64, zone string) error {
+	if _, err := LoadZone(zone); err != nil {
+		return err
+	}
+	return c.store.SetUserZone(userID, zone)
+}
+
+// UserLocation возвращает часовой пояс пользователя, UTC если он не задан.
+func (c *Calendar) UserLocation(userID int64) (*time.Location, error) {
+	zone, err := c.store.UserZone(userID)
+	if err != nil {
+		return nil, err
+	}
+	return LoadZone(zone)
+}
+
+// Event возвращает событие id пользователя userID.
+func (c *Calendar) Event(userID, id int64) (Event, error) {
+	return c.userEvent(userID, id)
+}
+
+// UserEvents возвращает все события пользователя без разворачивания серий.
+func (c *Calendar) UserEvents(userID int64) ([]Event, error) {
+	return c.store.List(userID)
+}
+
+// EventsByUID возвращает событие (серию) с данным UID вместе с его
+// отдельно изменёнными повторениями.
+func (c *Calendar) EventsByUID(userID int64, uid string) ([]Event, error) {
+	events, err := c.store.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	var master *Event
+	for i := range events {
+		if events[i].SeriesID == 0 && events[i].uid() == uid {
+			master = &events[i]
+			break
+		}
+	}
+	if master == nil {
+		return nil, ErrEventNotFound
+	}
+	res := []Event{*master}
+	for _, e := range events {
+		if e.SeriesID == master.ID {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// DeleteByUID удаляет событие (серию) с данным UID.
+func (c *Calendar) DeleteByUID(userID int64, uid string) error {
+	events, err := c.EventsByUID(userID, uid)
+	if err != nil {
+		return err
+	}
+	return c.DeleteEvent(userID, events[0].ID, 0)
+}
+
+// ImportResult — итог импорта событий.
+type ImportResult struct {
+	Created int
+	Updated int
+}
+
+// ImportEvents сохраняет события из внешнего календаря. События сопоставляются
+// с существующими по UID, поэтому повторный импорт обновляет их, а не дублирует.
+// Изменённые повторения (с RecurrenceID) привязываются к серии с тем же UID.
+// Импорт выполняется в транзакции: при ошибке уже сохранённые события
+// откатываются.
+func (c *Calendar) ImportEvents(userID int64, events []Event) (ImportResult, error) {
+	return c.importAll(userID, events, nil)
+}
+
+// ImportResource сохраняет события ресурса CalDAV uid так же, как
+// ImportEvents, если ресурс не изменился с тех пор, как вызывающий прочитал
+// его как current; пустой current — ресурса не было. Обновления передают
+// хранилищу версии из current, поэтому изменённый за это время ресурс
+// даёт ErrVersionConflict, а не затирается.
+func (c *Calendar) ImportResource(userID int64, uid string, current, events []Event) (ImportResult, error) {
+	return c.importAll(userID, events, func(idx *importIndex) error {
+		return idx.expect(uid, current)
+	})
+}
+
+// importAll сохраняет события в транзакции, проверив перед этим
+// календарь функцией check, если она задана.
+func (c *Calendar) importAll(userID int64, events []Event, check func(idx *importIndex) error) (ImportResult, error) {
+	for i := range events {
+		e := &events[i]
+		e.UserID = userID
+		e.Tags = normalizeTags(e.Tags)
+		if err := e.validate(); err != nil {
+			return ImportResult{}, fmt.Errorf("event %q: %w", e.UID, err)
+		}
+	}
+	// сначала серии и одиночные события, затем изменённые повторения
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].RecurrenceID == nil && events[j].RecurrenceID != nil
+	})
+	var res ImportResult
+	err := c.Transaction(func(tx *Calendar) error {
+		existing, err := tx.store.List(userID)
+		if err != nil {
+			return err
+		}
+		idx := newImportIndex(existing)
+		if check != nil {
+			if err := check(idx); err != nil {
+				return err
+			}
+		}
+		res, err = tx.importEvents(idx, events)
+		return err
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return res, nil
+}
+
+// importIndex — события пользователя, с которыми сопоставляется импорт.
+// Сохранённые импортом события сразу заменяют в нём прежние.
+type importIndex struct {
+	// masters — серии и одиночные события по UID
+	masters map[string]Event
+	// overrides — изменённые повторения по серии и RecurrenceID
+	overrides map[overrideKey]Event
+}
+
+type overrideKey struct {
+	series int64
+	// recurrence — RecurrenceID в наносекундах Unix
+	recurrence int64
+}
+
+func newImportIndex(events []Event) *importIndex {
+	idx := &importIndex{masters: make(map[string]Event), overrides: make(map[overrideKey]Event)}
+	for _, e := range events {
+		if e.SeriesID == 0 {
+			if _, ok := idx.masters[e.uid()]; !ok {
+				idx.masters[e.uid()] = e
+			}
+		} else if e.RecurrenceID != nil {
+			idx.overrides[overrideKey{e.SeriesID, e.RecurrenceID.UnixNano()}] = e
+		}
+	}
+	return idx
+}
+
+// expect проверяет, что ресурс uid в календаре — те же события тех же
+// версий, что в current.
+func (idx *importIndex) expect(uid string, current []Event) error {
+	want := make(map[int64]int64, len(current))
+	for _, e := range current {
+		want[e.ID] = e.Version
+	}
+	n := 0
+	if master, ok := idx.masters[uid]; ok {
+		events := []Event{master}
+		for key, e := range idx.overrides {
+			if key.series == master.ID {
+				events = append(events, e)
+			}
+		}
+		for _, e := range events {
+			if version, ok := want[e.ID]; !ok || version != e.Version {
+				return ErrVersionConflict
+			}
+		}
+		n = len(events)
+	}
+	if n != len(want) {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// importEvents сохраняет проверенные и упорядоченные события.
+func (c *Calendar) importEvents(idx *importIndex, events []Event) (ImportResult, error) {
+	var res ImportResult
+	for _, e := range events {
+		var (
+			created bool
+			err     error
+		)
+		if e.RecurrenceID == nil {
+			created, err = c.importMaster(idx, e)
+		} else {
+			created, err = c.importOverride(idx, e)
+		}
+		if err != nil {
+			return res, fmt.Errorf("event %q: %w", e.UID, err)
+		}
+		if created {
+			res.Created++
+		} else {
+			res.Updated++
+		}
+	}
+	return res, nil
+}
+
+func (c *Calendar) importMaster(idx *importIndex, e Event) (bool, error) {
+	uid := e.UID
+	if old, ok := idx.masters[uid]; ok {
+		e.ID, e.Version = old.ID, old.Version
+		if old.UID == "" {
+			// событие было создано через API и выгружено с UID по умолчанию
+			e.UID = ""
+		}
+		if err := c.update(&e); err != nil {
+			return false, err
+		}
+		idx.masters[uid] = e
+		return false, nil
+	}
+	e, err := c.create(e)
+	if err != nil {
+		return true, err
+	}
+	idx.masters[e.uid()] = e
+	return true, nil
+}
+
+func (c *Calendar) importOverride(idx *importIndex, e Event) (bool, error) {
+	series, ok := idx.masters[e.UID]
+	if !ok || series.Rule == nil {
+		return false, fmt.Errorf("%w: no series for RECURRENCE-ID", ErrInvalidEvent)
+	}
+	e.UID = ""
+	e.Rule = nil
+	e.Exceptions = nil
+	e.SeriesID = series.ID
+	key := overrideKey{series.ID, e.RecurrenceID.UnixNano()}
+	if old, ok := idx.overrides[key]; ok {
+		e.ID, e.Version = old.ID, old.Version
+		if err := c.update(&e); err != nil {
+			return false, err
+		}
+		idx.overrides[key] = e
+		return false, nil
+	}
+	if !series.isException(*e.RecurrenceID) {
+		uid := series.uid()
+		series.addException(*e.RecurrenceID)
+		if err := c.update(&series); err != nil {
+			return false, err
+		}
+		idx.masters[uid] = series
+	}
+	e, err := c.create(e)
+	if err != nil {
+		return true, err
+	}
+	idx.overrides[key] = e
+	return true, nil
+}
+
+// Close закрывает хранилище.
+func (c *Calendar) Close() error {
+	return c.store.Close()
+}
+
+// userEvent возвращает событие, если оно принадлежит пользователю,
+// и ErrNotOwner, если оно из чужого календаря.
+func (c *Calendar) userEvent(userID, id int64) (Event, error) {
+	e, err := c.store.Get(id)
+	if err != nil {
+		return Event{}, err
+	}
+	if e.UserID != userID {
+		return Event{}, ErrNotOwner
+	}
+	return e, nil
+}
+
+// userOccurrence возвращает серию пользователя, если на day приходится её повторение.
+func (c *Calendar) userOccurrence(userID, id int64, day time.Time) (Event, error) {
+	e, err := c.userEvent(userID, id)
+	if err != nil {
+		return Event{}, err
+	}
+	if e.Rule == nil || !e.occursOn(truncateDay(day)) {
+		return Event{}, ErrNoOccurrence
+	}
+	return e, nil
+}
+
+// EventsBetween возвращает события пользователя, пересекающиеся с
+// полуинтервалом [from, to), разворачивая серии в отдельные повторения.
+// Кроме своих событий в результат входят те, на которые пользователь
+// приглашён и от которых не отказался.
+func (c *Calendar) EventsBetween(userID int64, from, to time.Time) ([]Event, error) {
+	events, err := c.store.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Event, 0, len(events))
+	for _, e := range events {
+		res = append(res, e.expand(from, to)...)
+	}
+	invited, err := c.invitedBetween(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, invited...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// occurrenceFailingStore — хранилище, которое не может сохранить отдельно
+// изменённое повторение (detached) или изменённую серию.
+type occurrenceFailingStore struct {
+	*MemoryStore
+	detached, series bool
+}
+
+func (s occurrenceFailingStore) Create(e Event) (Event, error) {
+	if s.detached && e.SeriesID != 0 {
+		return Event{}, errDiskFailure
+	}
+	return s.MemoryStore.Create(e)
+}
+
+func (s occurrenceFailingStore) Update(e Event) (Event, error) {
+	if s.series && e.Rule != nil {
+		return Event{}, errDiskFailure
+	}
+	return s.MemoryStore.Update(e)
+}
+
+// TestUpdateOccurrenceFailure проверяет, что повторение, которое не удалось
+// сохранить отдельно, остаётся в серии и не дублируется.
+func TestUpdateOccurrenceFailure(t *testing.T) {
+	for _, store := range []occurrenceFailingStore{
+		{MemoryStore: NewMemoryStore(), detached: true},
+		{MemoryStore: NewMemoryStore(), series: true},
+	} {
+		cal := NewCalendar(store)
+		start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+		series, _, err := cal.CreateEvent(Event{UserID: 1, Title: "Standup", Start: start, End: start.Add(15 * time.Minute),
+			Rule: &Rule{Freq: Daily, Interval: 1, Count: 5}}, AllowConflicts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day := time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)
+		title := "Retro"
+		_, _, err = cal.UpdateOccurrence(1, series.ID, day, EventUpdate{Title: &title}, AllowConflicts)
+		if !errors.Is(err, errDiskFailure) {
+			t.Fatalf("update occurrence: %v, want %v", err, errDiskFailure)
+		}
+		events, err := cal.EventsForDay(1, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].ID != series.ID || events[0].Title != "Standup" {
+			t.Errorf("detached %v, series %v: events on %s %+v, want the series occurrence only",
+				store.detached, store.series, day.Format(dateLayout), events)
+		}
+	}
+}
+
+// TestCreateEventKeepsExceptions проверяет, что CreateEvent не меняет
+// исключения, переданные вызывающим.
+func TestCreateEventKeepsExceptions(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	skip := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	exceptions := []time.Time{skip}
+	e, _, err := cal.CreateEvent(Event{UserID: 1, Title: "Standup", Start: start, End: start.Add(15 * time.Minute),
+		Rule: &Rule{Freq: Daily, Interval: 1, Count: 5}, Exceptions: exceptions}, AllowConflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exceptions[0].Equal(skip) {
+		t.Errorf("caller's exception changed to %s", exceptions[0])
+	}
+	if len(e.Exceptions) != 1 || !e.Exceptions[0].Equal(truncateDay(skip)) {
+		t.Errorf("stored exceptions %v, want %s", e.Exceptions, truncateDay(skip))
+	}
+}
+
+// listCountingStore считает чтения всех событий пользователя.
+type listCountingStore struct {
+	*MemoryStore
+	lists *int
+}
+
+func (s listCountingStore) List(userID int64) ([]Event, error) {
+	*s.lists++
+	return s.MemoryStore.List(userID)
+}
+
+// TestImportEvents проверяет сопоставление по UID за одно чтение
+// календаря и откат импорта, прерванного ошибкой.
+func TestImportEvents(t *testing.T) {
+	var lists int
+	cal := NewCalendar(listCountingStore{MemoryStore: NewMemoryStore(), lists: &lists})
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	recurrenceID := date("2026-10-20")
+	feed := func(suffix string) []Event {
+		return []Event{
+			{UID: "standup@test", Title: "Retro" + suffix, Start: start.Add(26 * time.Hour), End: start.Add(27 * time.Hour),
+				RecurrenceID: &recurrenceID},
+			{UID: "standup@test", Title: "Standup" + suffix, Start: start, End: start.Add(15 * time.Minute),
+				Rule: &Rule{Freq: Daily, Interval: 1, Count: 5}},
+			{UID: "call@test", Title: "Call" + suffix, Start: start.Add(4 * time.Hour), End: start.Add(5 * time.Hour)},
+		}
+	}
+
+	res, err := cal.ImportEvents(1, feed(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (ImportResult{Created: 3}) || lists != 1 {
+		t.Errorf("first import %+v with %d lists, want 3 created with 1 list", res, lists)
+	}
+	lists = 0
+	res, err = cal.ImportEvents(1, feed(" v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (ImportResult{Updated: 3}) || lists != 1 {
+		t.Errorf("second import %+v with %d lists, want 3 updated with 1 list", res, lists)
+	}
+	events, err := cal.EventsByUID(1, "standup@test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Title != "Standup v2" || events[1].Title != "Retro v2" {
+		t.Errorf("series after re-import %+v", events)
+	}
+
+	broken := []Event{
+		{UID: "new@test", Title: "New", Start: start, End: start.Add(time.Hour)},
+		{UID: "missing@test", Title: "Orphan", Start: start, End: start.Add(time.Hour), RecurrenceID: &recurrenceID},
+	}
+	if _, err := cal.ImportEvents(1, broken); !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("import with an orphan override: %v, want %v", err, ErrInvalidEvent)
+	}
+	if _, err := cal.EventsByUID(1, "new@test"); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("event from a failed import kept: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChangeKind — вид изменения события.
+type ChangeKind string
+
+// Виды изменений.
+const (
+	ChangeCreated ChangeKind = "created"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeDeleted ChangeKind = "deleted"
+	// ChangeRestored — удалённое событие восстановлено; встречается только
+	// в аудите, подписчики получают created.
+	ChangeRestored ChangeKind = "restored"
+)
+
+// Change — изменение события в календаре пользователя.
+type Change struct {
+	// Seq — номер изменения, возрастает на единицу.
+	Seq    uint64
+	Kind   ChangeKind
+	UserID int64
+	// Event — событие после изменения, для удалённого — последнее состояние.
+	Event Event
+	At    time.Time
+}
+
+// changeLogSize — сколько последних изменений хранится для возобновления
+// подписки.
+const changeLogSize = 1024
+
+// ChangeLog — ограниченный журнал последних изменений в памяти и
+// уведомление подписчиков о новых. Номера изменений начинаются заново при
+// каждом запуске, поэтому вместе с ними передаётся Epoch журнала.
+type ChangeLog struct {
+	// Epoch отличает журналы разных запусков сервера.
+	Epoch int64
+
+	mu      sync.Mutex
+	seq     uint64
+	entries []Change // последние изменения по возрастанию Seq
+	subs    map[chan struct{}]struct{}
+}
+
+// NewChangeLog создаёт пустой журнал.
+func NewChangeLog() *ChangeLog {
+	return &ChangeLog{Epoch: time.Now().UnixNano(), subs: make(map[chan struct{}]struct{})}
+}
+
+// publish добавляет изменение в журнал и будит подписчиков.
+func (l *ChangeLog) publish(kind ChangeKind, e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.entries = append(l.entries, Change{Seq: l.seq, Kind: kind, UserID: e.UserID, Event: e, At: time.Now()})
+	if len(l.entries) > changeLogSize {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-changeLogSize:]...)
+	}
+	for ch := range l.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// подписчик ещё не забрал прошлое уведомление
+		}
+	}
+}
+
+// Last возвращает номер последнего изменения.
+func (l *ChangeLog) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Since возвращает изменения событий, где userID организатор или участник,
+// после номера after и номер последнего просмотренного изменения — с него
+// продолжается следующий вызов.
+// ok равно false, если часть изменений после after уже вытеснена из
+// журнала или after из будущего: клиенту нужно перечитать календарь.
+func (l *ChangeLog) Since(userID int64, after uint64) (changes []Change, last uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if after > l.seq || (len(l.entries) > 0 && after+1 < l.entries[0].Seq) {
+		return nil, l.seq, false
+	}
+	for _, c := range l.entries {
+		if _, invited := c.Event.attendee(userID); c.Seq > after && (c.UserID == userID || invited) {
+			changes = append(changes, c)
+		}
+	}
+	return changes, l.seq, true
+}
+
+// Subscribe возвращает канал, в который приходит сигнал после новых
+// изменений, и функцию отмены подписки.
+func (l *ChangeLog) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.subs, ch)
+		l.mu.Unlock()
+	}
+}
+
+// Changes возвращает журнал изменений календаря.
+func (c *Calendar) Changes() *ChangeLog {
+	return c.changes
+}
+
+// publish записывает изменение в журнал для подписчиков. В транзакции
+// изменение откладывается до её завершения.
+func (c *Calendar) publish(kind ChangeKind, e Event) {
+	if c.undo != nil {
+		c.undo.changes = append(c.undo.changes, Change{Kind: kind, Event: e})
+		return
+	}
+	c.changes.publish(kind, e)
+}
+
+// create, update, remove и restore сохраняют событие в хранилище,
+// обновляют поисковый индекс, записывают изменение в журнал для
+// подписчиков и в аудит.
+
+func (c *Calendar) create(e Event) (Event, error) {
+	e, err := c.store.Create(e)
+	if err != nil {
+		return e, err
+	}
+	c.index.put(e)
+	c.publish(ChangeCreated, e)
+	c.undo.add(ChangeCreated, Event{}, e, c.actor)
+	return e, c.audit(ChangeCreated, nil, &e)
+}
+
+// update записывает в e сохранённое событие с новой версией.
+func (c *Calendar) update(e *Event) error {
+	before, err := c.store.Get(e.ID)
+	if err != nil {
+		return err
+	}
+	saved, err := c.store.Update(*e)
+	if err != nil {
+		return err
+	}
+	*e = saved
+	c.index.put(saved)
+	c.publish(ChangeUpdated, saved)
+	c.undo.add(ChangeUpdated, before, saved, c.actor)
+	return c.audit(ChangeUpdated, &before, &saved)
+}
+
+func (c *Calendar) remove(e Event) error {
+	if err := c.store.Delete(e.ID); err != nil {
+		return err
+	}
+	c.index.remove(e.ID)
+	c.publish(ChangeDeleted, e)
+	c.undo.add(ChangeDeleted, e, Event{}, c.actor)
+	return c.audit(ChangeDeleted, &e, nil)
+}
+
+// restore снова сохраняет удалённое событие под прежним ID.
+func (c *Calendar) restore(e Event) (Event, error) {
+	e, err := c.store.Restore(e)
+	if err != nil {
+		return e, err
+	}
+	c.index.put(e)
+	c.publish(ChangeCreated, e)
+	c.undo.add(ChangeCreated, Event{}, e, c.actor)
+	return e, c.audit(ChangeRestored, nil, &e)
+}
+
+// undoStep — изменение транзакции: событие до и после него и кто его внёс.
+type undoStep struct {
+	kind          ChangeKind
+	before, after Event
+	actor         int64
+}
+
+// undoLog — изменения транзакции в порядке выполнения.
+type undoLog struct {
+	steps []undoStep
+	// changes — изменения для подписчиков, отложенные до конца транзакции
+	changes []Change
+}
+
+// flush передаёт отложенные изменения в журнал changes.
+func (l *undoLog) flush(changes *ChangeLog) {
+	for _, ch := range l.changes {
+		changes.publish(ch.Kind, ch.Event)
+	}
+	l.changes = nil
+}
+
+// add записывает изменение; у календаря вне транзакции журнала нет.
+func (l *undoLog) add(kind ChangeKind, before, after Event, actor int64) {
+	if l != nil {
+		l.steps = append(l.steps, undoStep{kind: kind, before: before, after: after, actor: actor})
+	}
+}
+
+// Transaction выполняет fn над календарём tx и, если fn вернула ошибку,
+// отменяет уже сделанные через tx изменения в обратном порядке.
+// Транзакции выполняются по одной, но остальные запросы их не ждут: если
+// другой запрос успел изменить событие транзакции, его изменение не
+// затирается, а откат этого события не удаётся и описывается в ошибке.
+// Подписчики узнают об изменениях транзакции, только когда она успешно
+// завершилась, а об отменённой — только если откат не удался.
+func (c *Calendar) Transaction(fn func(tx *Calendar) error) error {
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	tx := &Calendar{store: c.store, changes: c.changes, index: c.index, txMu: c.txMu, undo: &undoLog{}, actor: c.actor}
+	err := fn(tx)
+	if err == nil {
+		tx.undo.flush(c.changes)
+		return nil
+	}
+	// откат тоже откладывает изменения: если он удался, подписчикам
+	// сообщать нечего
+	rb := &Calendar{store: c.store, changes: c.changes, index: c.index, txMu: c.txMu, undo: &undoLog{}, actor: c.actor}
+	if rerr := rb.rollback(tx.undo); rerr != nil {
+		tx.undo.flush(c.changes)
+		rb.undo.flush(c.changes)
+		return fmt.Errorf("%w (rollback incomplete: %v)", err, rerr)
+	}
+	return err
+}
+
+// rollback отменяет изменения журнала log, начиная с последнего, от имени
+// тех же пользователей. Событие возвращается к прежнему состоянию, только
+// если после транзакции его никто не менял. Возвращает первую ошибку,
+// но отменяет всё, что может.
+func (c *Calendar) rollback(log *undoLog) error {
+	var first error
+	// версии событий после уже отменённых шагов
+	versions := make(map[int64]int64)
+	for i := len(log.steps) - 1; i >= 0; i-- {
+		step := log.steps[i]
+		id := step.after.ID
+		if step.kind == ChangeDeleted {
+			id = step.before.ID
+		}
+		version, ok := versions[id]
+		if !ok {
+			version = step.after.Version
+		}
+		c := c.As(step.actor)
+		var err error
+		switch step.kind {
+		case ChangeCreated:
+			var cur Event
+			if cur, err = c.store.Get(id); err == nil {
+				if cur.Version != version {
+					err = ErrVersionConflict
+				} else {
+					err = c.remove(cur)
+				}
+			}
+		case ChangeUpdated:
+			e := step.before
+			e.Version = version
+			if err = c.update(&e); err == nil {
+				versions[id] = e.Version
+			}
+		case ChangeDeleted:
+			var e Event
+			if e, err = c.restore(step.before); err == nil {
+				versions[id] = e.Version
+			}
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("event %d: %w", id, err)
+		}
+	}
+	return first
+}
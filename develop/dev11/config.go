@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix — префикс переменных окружения с настройками: DEV11_PORT и т.п.
+const envPrefix = "DEV11_"
+
+// Config — настройки сервера. Значения берутся по возрастанию приоритета:
+// умолчания, файл конфигурации (JSON или YAML), переменные окружения
+// DEV11_<КЛЮЧ>, флаги командной строки.
+//
+// Port, таймауты соединений, хранилище и сокет JSON-RPC применяются только
+// при запуске; остальное перечитывается по SIGHUP.
+type Config struct {
+	Port            int
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	Storage         string
+	DataDir         string
+	CompactEvery    int
+	Conflict        string
+	Notify          string
+	RemindLate      time.Duration
+	LogLevel        string
+	APIKeys         string
+	TokenSecret     string
+	RateLimits      string
+	RPCSocket       string
+}
+
+// defaultConfig возвращает настройки по умолчанию.
+func defaultConfig() Config {
+	return Config{
+		Port:            8080,
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		Storage:         "memory",
+		DataDir:         "data",
+		CompactEvery:    1000,
+		Conflict:        "allow",
+		Notify:          "log",
+		RemindLate:      time.Hour,
+		LogLevel:        "info",
+	}
+}
+
+// configField — настройка: ключ в файле и окружении, имя флага и значение.
+type configField struct {
+	key   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+// fields перечисляет настройки c; значения указывают на поля c.
+func (c *Config) fields() []configField {
+	return []configField{
+		{"port", "port", "порт HTTP сервера", (*intValue)(&c.Port)},
+		{"read_timeout", "read-timeout", "таймаут чтения запроса", (*durationValue)(&c.ReadTimeout)},
+		{"write_timeout", "write-timeout", "таймаут записи ответа", (*durationValue)(&c.WriteTimeout)},
+		{"idle_timeout", "idle-timeout", "таймаут простаивающего соединения", (*durationValue)(&c.IdleTimeout)},
+		{"shutdown_timeout", "shutdown-timeout", "сколько ждать завершения запросов при остановке", (*durationValue)(&c.ShutdownTimeout)},
+		{"storage", "storage", "хранилище событий: memory или file", (*stringValue)(&c.Storage)},
+		{"data_dir", "data", "каталог данных для -storage=file", (*stringValue)(&c.DataDir)},
+		{"compact_every", "compact", "число записей журнала до сохранения снимка", (*intValue)(&c.CompactEvery)},
+		{"conflict", "conflict", "политика пересечений событий: allow, warn или reject", (*stringValue)(&c.Conflict)},
+		{"notify", "notify", "доставка напоминаний через запятую: log, webhook=URL, mail=DIR", (*stringValue)(&c.Notify)},
+		{"remind_late", "remind-late", "насколько опоздавшие напоминания ещё рассылаются после перезапуска", (*durationValue)(&c.RemindLate)},
+		{"log_level", "log-level", "уровень лога: debug, info, warn или error", (*stringValue)(&c.LogLevel)},
+		{"api_keys", "api-keys", "API ключи через запятую: key=user_id; включают аутентификацию", (*stringValue)(&c.APIKeys)},
+		{"token_secret", "token-secret", "секрет подписи токенов; включает аутентификацию", (*stringValue)(&c.TokenSecret)},
+		{"rate_limits", "rate-limits", "лимиты запросов по маршрутам: *=20/s:40,/events_for_month=30/m", (*stringValue)(&c.RateLimits)},
+		{"rpc_socket", "rpc-socket", "Unix сокет для JSON-RPC; пусто — выключен", (*stringValue)(&c.RPCSocket)},
+	}
+}
+
+// validate проверяет согласованность настроек.
+func (c Config) validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("port %d out of range", c.Port)
+	}
+	if c.CompactEvery <= 0 {
+		return errors.New("compact_every must be positive")
+	}
+	switch c.Storage {
+	case "memory", "file":
+	default:
+		return fmt.Errorf("unknown storage %q", c.Storage)
+	}
+	if _, err := ParseConflictPolicy(c.Conflict); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if _, err := parseAPIKeys(c.APIKeys); err != nil {
+		return err
+	}
+	if _, err := parseRateLimits(c.RateLimits); err != nil {
+		return err
+	}
+	for _, d := range []time.Duration{c.ReadTimeout, c.WriteTimeout, c.IdleTimeout, c.ShutdownTimeout, c.RemindLate} {
+		if d < 0 {
+			return errors.New("durations must not be negative")
+		}
+	}
+	return nil
+}
+
+// parseLogLevel разбирает уровень лога.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// configLoader собирает Config из файла, окружения и флагов. Флаги
+// разбираются один раз, а файл и окружение перечитываются при каждом Load.
+type configLoader struct {
+	path   string
+	flags  map[string]string
+	getenv func(string) string
+}
+
+// newConfigLoader разбирает аргументы командной строки args.
+// Путь к файлу конфигурации задаётся флагом -config или DEV11_CONFIG.
+func newConfigLoader(name string, args []string, getenv func(string) string) (*configLoader, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "файл конфигурации JSON или YAML")
+	defaults := defaultConfig()
+	for _, f := range defaults.fields() {
+		fs.Var(f.value, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	l := &configLoader{path: *path, flags: make(map[string]string), getenv: getenv}
+	fs.Visit(func(f *flag.Flag) {
+		l.flags[f.Name] = f.Value.String()
+	})
+	return l, nil
+}
+
+// Load собирает и проверяет настройки.
+func (l *configLoader) Load() (Config, error) {
+	c := defaultConfig()
+	fields := c.fields()
+	if l.path != "" {
+		values, err := readConfigFile(l.path)
+		if err != nil {
+			return Config{}, err
+		}
+		known := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			known[f.key] = true
+		}
+		for key := range values {
+			if !known[key] {
+				return Config{}, fmt.Errorf("%s: unknown setting %q", l.path, key)
+			}
+		}
+		for _, f := range fields {
+			if v, ok := values[f.key]; ok {
+				if err := f.value.Set(v); err != nil {
+					return Config{}, fmt.Errorf("%s: %s: %w", l.path, f.key, err)
+				}
+			}
+		}
+	}
+	for _, f := range fields {
+		name := envPrefix + strings.ToUpper(f.key)
+		if v := l.getenv(name); v != "" {
+			if err := f.value.Set(v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if v, ok := l.flags[f.flag]; ok {
+			if err := f.value.Set(v); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", f.flag, err)
+			}
+		}
+	}
+	return c, c.validate()
+}
+
+// readConfigFile читает плоский файл настроек. Формат выбирается по
+// расширению: .yaml и .yml — YAML, остальное — JSON.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(string(data))
+	default:
+		values, err = parseJSONConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseJSONConfig читает объект JSON со скалярными значениями.
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, msg := range raw {
+		var s string
+		if err := json.Unmarshal(msg, &s); err == nil {
+			values[key] = s
+			continue
+		}
+		var n json.Number
+		if err := json.Unmarshal(msg, &n); err != nil {
+			return nil, fmt.Errorf("%s: must be a string or a number", key)
+		}
+		values[key] = n.String()
+	}
+	return values, nil
+}
+
+// parseYAMLConfig читает подмножество YAML: строки «ключ: значение»
+// верхнего уровня со скалярными значениями, возможно в кавычках,
+// и комментарии после #. Вложенные структуры и списки не поддерживаются.
+func parseYAMLConfig(text string) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line != trimmed {
+			return nil, fmt.Errorf("line %d: nested values are not supported", i+1)
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key := strings.TrimSpace(kv[0])
+		v, err := yamlScalar(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+// yamlScalar разбирает значение в кавычках или без, отбрасывая комментарий.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.Index(s[1:], `"`)
+		if end < 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		return strconv.Unquote(s[:end+2])
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		return s[1 : end+1], nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// intValue, stringValue и durationValue привязывают flag.Value к полям Config.
+type (
+	intValue      int
+	stringValue   string
+	durationValue time.Duration
+)
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, use units like 30s or 5m", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+// keepStartup возвращает next с настройками, которые применяются только
+// при запуске, взятыми из cur, и ключи тех из них, что изменились.
+func keepStartup(cur, next Config) (Config, []string) {
+	var changed []string
+	curFields, nextFields := cur.fields(), next.fields()
+	for i, f := range curFields {
+		switch f.key {
+		case "port", "read_timeout", "write_timeout", "idle_timeout", "storage", "data_dir", "compact_every", "rpc_socket":
+			if v := f.value.String(); nextFields[i].value.String() != v {
+				changed = append(changed, f.key)
+				nextFields[i].value.Set(v)
+			}
+		}
+	}
+	return next, changed
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigPrecedence проверяет порядок: умолчания, файл, окружение, флаги.
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dev11.yaml")
+	file := "# dev11\nport: 9000\nconflict: warn\nlog_level: 'debug'\nremind_late: \"30m\" # half an hour\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"DEV11_CONFIG": path, "DEV11_PORT": "9100", "DEV11_CONFLICT": "reject"}
+	l, err := newConfigLoader("dev11", []string{"-port", "9200"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.Port = 9200
+	want.Conflict = "reject"
+	want.LogLevel = "debug"
+	want.RemindLate = 30 * time.Minute
+	if c != want {
+		t.Errorf("config %+v, want %+v", c, want)
+	}
+
+	// файл и окружение перечитываются при каждом Load, флаги — нет
+	env["DEV11_CONFLICT"] = ""
+	if err := os.WriteFile(path, []byte("port: 9000\nconflict: warn\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9200 || c.Conflict != "warn" || c.LogLevel != "info" {
+		t.Errorf("reloaded config %+v", c)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown.json":  `{"port": 9000, "colour": "blue"}`,
+		"nested.json":   `{"port": {"value": 9000}}`,
+		"invalid.yaml":  "port: many\n",
+		"policy.yml":    "conflict: maybe\n",
+		"range.yaml":    "port: 70000\n",
+		"duration.yaml": "read_timeout: 10\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		l, err := newConfigLoader("dev11", []string{"-config", path}, func(string) string { return "" })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, err := l.Load(); err == nil {
+			t.Errorf("%s loaded as %+v", name, c)
+		}
+	}
+}
+
+func TestParseYAMLConfig(t *testing.T) {
+	values, err := parseYAMLConfig("---\r\nport: 8081\r\n\r\n# comment\r\nnotify: log,webhook=http://example.test/hook#x # deliveries\r\n" +
+		"api_keys: \"alice=1,bob=2\"\r\ntoken_secret: 's3cret # not a comment'\r\nrpc_socket:\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"port":         "8081",
+		"notify":       "log,webhook=http://example.test/hook#x",
+		"api_keys":     "alice=1,bob=2",
+		"token_secret": "s3cret # not a comment",
+		"rpc_socket":   "",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values %q, want %q", values, want)
+	}
+	for _, text := range []string{
+		"limits:\n  events: 10\n",
+		"port 8081\n",
+		"token_secret: \"open\n",
+	} {
+		if _, err := parseYAMLConfig(text); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+}
+
+func TestKeepStartup(t *testing.T) {
+	cur := defaultConfig()
+	next := cur
+	next.Port = 9000
+	next.DataDir = "other"
+	next.Conflict = "reject"
+	got, changed := keepStartup(cur, next)
+	if got.Port != cur.Port || got.DataDir != cur.DataDir || got.Conflict != "reject" {
+		t.Errorf("applied %+v", got)
+	}
+	if strings.Join(changed, ",") != "port,data_dir" {
+		t.Errorf("changed %v", changed)
+	}
+}
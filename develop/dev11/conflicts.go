@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ConflictPolicy определяет реакцию на пересечение события с другими
+// событиями того же пользователя.
+type ConflictPolicy int
+
+// Политики обработки пересечений.
+const (
+	// AllowConflicts сохраняет событие без проверки.
+	AllowConflicts ConflictPolicy = iota
+	// WarnConflicts сохраняет событие и сообщает о пересечениях.
+	WarnConflicts
+	// RejectConflicts отказывает в сохранении при пересечении.
+	RejectConflicts
+)
+
+// ErrConflict — событие пересекается с другими при RejectConflicts.
+const ErrConflict = domainError("event overlaps with existing events")
+
+// conflictHorizon — насколько далеко вперёд серии проверяются на пересечения.
+const conflictHorizon = 366 * 24 * time.Hour
+
+// maxConflicts ограничивает число пересечений в ответе.
+const maxConflicts = 50
+
+// ParseConflictPolicy разбирает название политики: allow, warn или reject.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case "allow":
+		return AllowConflicts, nil
+	case "warn":
+		return WarnConflicts, nil
+	case "reject":
+		return RejectConflicts, nil
+	default:
+		return 0, fmt.Errorf("unknown conflict policy %q", s)
+	}
+}
+
+// Interval — полуинтервал времени [Start, End).
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// overlaps сообщает, пересекаются ли интервалы. Интервал нулевой длины
+// считается точкой и пересекается с интервалом, который её содержит.
+func (i Interval) overlaps(o Interval) bool {
+	if i.Start.Equal(i.End) {
+		return o.contains(i.Start)
+	}
+	if o.Start.Equal(o.End) {
+		return i.contains(o.Start)
+	}
+	return i.Start.Before(o.End) && o.Start.Before(i.End)
+}
+
+func (i Interval) contains(t time.Time) bool {
+	if i.Start.Equal(i.End) {
+		return t.Equal(i.Start)
+	}
+	return !t.Before(i.Start) && t.Before(i.End)
+}
+
+// Conflict — повторение другого события, с которым пересекается проверяемое.
+type Conflict struct {
+	EventID int64
+	Start   time.Time
+}
+
+// span возвращает интервал, который занимает экземпляр события.
+func (e Event) span() Interval {
+	return Interval{Start: e.Start, End: e.End}
+}
+
+// findConflicts ищет пересечения события e с остальными событиями пользователя.
+// Серии проверяются на conflictHorizon вперёд от начала.
+func (c *Calendar) findConflicts(e Event) ([]Conflict, error) {
+	from, to := e.Start, e.End
+	if e.Rule != nil {
+		to = from.Add(conflictHorizon)
+	}
+	if !to.After(from) {
+		to = from.Add(time.Nanosecond)
+	}
+	mine := e.expand(from, to)
+	if len(mine) == 0 {
+		return nil, nil
+	}
+	others, err := c.EventsBetween(e.UserID, mine[0].span().Start, mine[len(mine)-1].span().End)
+	if err != nil {
+		return nil, err
+	}
+	var res []Conflict
+	for _, o := range others {
+		// само событие и его изменённые повторения не конфликтуют с ним
+		if e.ID != 0 && (o.ID == e.ID || o.SeriesID == e.ID) {
+			continue
+		}
+		if e.SeriesID != 0 && o.ID == e.SeriesID {
+			continue
+		}
+		for _, m := range mine {
+			if m.span().overlaps(o.span()) {
+				res = append(res, Conflict{EventID: o.ID, Start: o.Start})
+				break
+			}
+		}
+		if len(res) >= maxConflicts {
+			break
+		}
+	}
+	return res, nil
+}
+
+// checkConflicts применяет политику policy к событию перед сохранением.
+func (c *Calendar) checkConflicts(e Event, policy ConflictPolicy) ([]Conflict, error) {
+	if policy == AllowConflicts {
+		return nil, nil
+	}
+	conflicts, err := c.findConflicts(e)
+	if err != nil {
+		return nil, err
+	}
+	if policy == RejectConflicts && len(conflicts) > 0 {
+		return conflicts, fmt.Errorf("%w: event %d at %s", ErrConflict,
+			conflicts[0].EventID, conflicts[0].Start.Format(time.RFC3339))
+	}
+	return conflicts, nil
+}
+
+// FreeBusy — занятость одного или нескольких пользователей в диапазоне.
+type FreeBusy struct {
+	Busy []Interval
+	Free []Interval
+}
+
+// FreeBusy возвращает объединённые занятые интервалы пользователей userIDs
+// в [from, to) и свободные промежутки длиной не меньше minFree.
+func (c *Calendar) FreeBusy(userIDs []int64, from, to time.Time, minFree time.Duration) (FreeBusy, error) {
+	if !from.Before(to) {
+		return FreeBusy{}, fmt.Errorf("%w: empty range", ErrInvalidEvent)
+	}
+	var busy []Interval
+	for _, userID := range userIDs {
+		events, err := c.EventsBetween(userID, from, to)
+		if err != nil {
+			return FreeBusy{}, err
+		}
+		for _, e := range events {
+			span := e.span()
+			if span.Start.Before(from) {
+				span.Start = from
+			}
+			if span.End.After(to) {
+				span.End = to
+			}
+			if span.Start.Before(span.End) {
+				busy = append(busy, span)
+			}
+		}
+	}
+	busy = mergeIntervals(busy)
+
+	var fb FreeBusy
+	fb.Busy = busy
+	cursor := from
+	for _, b := range append(busy, Interval{Start: to, End: to}) {
+		if b.Start.Sub(cursor) >= minFree && b.Start.After(cursor) {
+			fb.Free = append(fb.Free, Interval{Start: cursor, End: b.Start})
+		}
+		if b.End.After(cursor) {
+			cursor = b.End
+		}
+	}
+	return fb, nil
+}
+
+// mergeIntervals объединяет пересекающиеся и смежные интервалы.
+func mergeIntervals(in []Interval) []Interval {
+	if len(in) == 0 {
+		return nil
+	}
+	sort.Slice(in, func(i, j int) bool {
+		return in[i].Start.Before(in[j].Start)
+	})
+	res := []Interval{in[0]}
+	for _, iv := range in[1:] {
+		last := &res[len(res)-1]
+		if !iv.Start.After(last.End) {
+			if iv.End.After(last.End) {
+				last.End = iv.End
+			}
+			continue
+		}
+		res = append(res, iv)
+	}
+	return res
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConflicts(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC)
+	}
+	event := func(title string, start time.Time, d time.Duration) Event {
+		return Event{UserID: 1, Title: title, Start: start, End: start.Add(d)}
+	}
+	standup := event("Standup", at(19, 9, 0), 30*time.Minute)
+	standup.Rule = &Rule{Freq: Daily, Interval: 1, Count: 5}
+	series, _, err := cal.CreateEvent(standup, RejectConflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lunch, _, err := cal.CreateEvent(event("Lunch", at(21, 13, 0), time.Hour), RejectConflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		e    Event
+		want []int64
+	}{
+		// повторение серии в середине недели
+		{event("Review", at(22, 9, 15), time.Hour), []int64{series.ID}},
+		// встреча впритык к повторению не пересекается с ним
+		{event("Sync", at(22, 9, 30), 30*time.Minute), nil},
+		// после последнего повторения по COUNT
+		{event("Demo", at(24, 9, 0), time.Hour), nil},
+		// событие нулевой длины внутри другого
+		{event("Deadline", at(21, 13, 30), 0), []int64{lunch.ID}},
+		// каждое задетое повторение — отдельное пересечение
+		{Event{UserID: 1, Title: "Focus", Start: at(20, 8, 0), End: at(20, 14, 0),
+			Rule: &Rule{Freq: Daily, Interval: 1, Count: 2}}, []int64{series.ID, series.ID, lunch.ID}},
+		// события другого пользователя не мешают
+		{Event{UserID: 2, Title: "Review", Start: at(22, 9, 0), End: at(22, 10, 0)}, nil},
+	} {
+		_, conflicts, err := cal.CreateEvent(tc.e, RejectConflicts)
+		var ids []int64
+		for _, c := range conflicts {
+			ids = append(ids, c.EventID)
+		}
+		if len(tc.want) == 0 && err != nil || len(tc.want) > 0 && !errors.Is(err, ErrConflict) {
+			t.Errorf("%s: %v", tc.e.Title, err)
+		}
+		if !reflect.DeepEqual(ids, tc.want) {
+			t.Errorf("%s conflicts with %v, want %v", tc.e.Title, ids, tc.want)
+		}
+	}
+
+	// WarnConflicts сохраняет событие и сообщает о пересечении
+	review, conflicts, err := cal.CreateEvent(event("Review", at(19, 9, 0), time.Hour), WarnConflicts)
+	if err != nil || review.ID == 0 || len(conflicts) != 1 || !conflicts[0].Start.Equal(at(19, 9, 0)) {
+		t.Errorf("warn: %+v, %+v, %v", review, conflicts, err)
+	}
+	// перенос события не конфликтует с его прежним положением
+	start := at(21, 13, 30)
+	if _, _, err := cal.UpdateEvent(1, lunch.ID, EventUpdate{Start: &start}, RejectConflicts); err != nil {
+		t.Errorf("move lunch: %v", err)
+	}
+}
+
+func TestFreeBusy(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 10, 19, hour, min, 0, 0, time.UTC)
+	}
+	for _, e := range []Event{
+		{UserID: 1, Title: "Standup", Start: at(9, 0), End: at(9, 30)},
+		{UserID: 2, Title: "Review", Start: at(9, 15), End: at(10, 0)},
+		{UserID: 2, Title: "Lunch", Start: at(10, 10), End: at(11, 0)},
+		{UserID: 1, Title: "Late", Start: at(17, 0), End: at(19, 0)},
+	} {
+		if _, _, err := cal.CreateEvent(e, AllowConflicts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fb, err := cal.FreeBusy([]int64{1, 2}, at(8, 0), at(18, 0), 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FreeBusy{
+		Busy: []Interval{{at(9, 0), at(10, 0)}, {at(10, 10), at(11, 0)}, {at(17, 0), at(18, 0)}},
+		// десять минут между обзором и обедом короче minFree
+		Free: []Interval{{at(8, 0), at(9, 0)}, {at(11, 0), at(17, 0)}},
+	}
+	if !equalIntervals(fb.Busy, want.Busy) || !equalIntervals(fb.Free, want.Free) {
+		t.Errorf("free/busy %+v, want %+v", fb, want)
+	}
+	if _, err := cal.FreeBusy([]int64{1}, at(10, 0), at(10, 0), 0); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("empty range: %v", err)
+	}
+}
+
+func equalIntervals(a, b []Interval) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Start.Equal(b[i].Start) || !a[i].End.Equal(b[i].End) {
+			return false
+		}
+	}
+	return true
+}
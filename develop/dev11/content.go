@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Типы содержимого запросов и ответов.
+const (
+	jsonType     = "application/json"
+	formType     = "application/x-www-form-urlencoded"
+	calendarType = "text/calendar"
+)
+
+// maxJSONBody ограничивает размер тела запроса в JSON.
+const maxJSONBody = 1 << 20
+
+// statusError — ошибка протокола HTTP с заданным кодом ответа:
+// неподдерживаемый тип тела или недопустимый Accept.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// requestParams возвращает параметры запроса: для GET из query string,
+// для POST из тела www-url-form-encoded или объекта JSON. Значения JSON
+// приводятся к тем же строкам, что и в форме, поэтому проверяются одинаково.
+func requestParams(r *http.Request) (url.Values, error) {
+	if r.Method == http.MethodGet {
+		return r.URL.Query(), nil
+	}
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil && r.Header.Get("Content-Type") != "" {
+		return nil, &statusError{status: http.StatusUnsupportedMediaType, msg: "malformed Content-Type"}
+	}
+	switch ct {
+	case jsonType:
+		params, err := jsonParams(http.MaxBytesReader(nil, r.Body, maxJSONBody))
+		if err != nil {
+			return nil, err
+		}
+		// так параметры видны middleware, как после ParseForm
+		r.PostForm = params
+		r.Form = make(url.Values)
+		for k, v := range r.URL.Query() {
+			r.Form[k] = v
+		}
+		for k, v := range params {
+			r.Form[k] = v
+		}
+		return params, nil
+	case formType, "":
+		if err := r.ParseForm(); err != nil {
+			return nil, &inputError{param: "body", msg: err.Error()}
+		}
+		return r.PostForm, nil
+	default:
+		return nil, &statusError{
+			status: http.StatusUnsupportedMediaType,
+			msg:    fmt.Sprintf("unsupported Content-Type %q, use %s or %s", ct, formType, jsonType),
+		}
+	}
+}
+
+// jsonParams читает объект JSON и превращает его в параметры формы:
+// строки, числа и логические значения — в строку, массивы — в значения
+// через запятую, null — в отсутствующий параметр.
+func jsonParams(body io.Reader) (url.Values, error) {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &inputError{param: "body", msg: fmt.Sprintf("larger than %d bytes", tooLarge.Limit)}
+		}
+		return nil, &inputError{param: "body", msg: "must be a JSON object: " + err.Error()}
+	}
+	if dec.More() {
+		return nil, &inputError{param: "body", msg: "unexpected data after JSON object"}
+	}
+	params := make(url.Values, len(obj))
+	for name, v := range obj {
+		if v == nil {
+			continue
+		}
+		if list, ok := v.([]interface{}); ok {
+			parts := make([]string, 0, len(list))
+			for _, item := range list {
+				s, ok := jsonScalar(item)
+				if !ok {
+					return nil, &inputError{param: name, msg: "array items must be strings, numbers or booleans"}
+				}
+				parts = append(parts, s)
+			}
+			params.Set(name, strings.Join(parts, ","))
+			continue
+		}
+		s, ok := jsonScalar(v)
+		if !ok {
+			return nil, &inputError{param: name, msg: "must be a string, number, boolean or array"}
+		}
+		params.Set(name, s)
+	}
+	return params, nil
+}
+
+func jsonScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// negotiate выбирает из offers тип ответа, который клиент принимает
+// с наибольшим весом q по заголовку Accept. При равном весе побеждает
+// более ранний в offers; без Accept выбирается первый.
+func negotiate(r *http.Request, offers ...string) (string, bool) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0], true
+	}
+	type rangeQ struct {
+		typ string
+		q   float64
+	}
+	var ranges []rangeQ
+	for _, part := range strings.Split(strings.Join(accept, ","), ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, rangeQ{typ: typ, q: q})
+	}
+	if len(ranges) == 0 {
+		return offers[0], true
+	}
+	// точные типы важнее type/* и */*
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return strings.Count(ranges[i].typ, "*") < strings.Count(ranges[j].typ, "*")
+	})
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		for _, rng := range ranges {
+			if !mediaMatch(rng.typ, offer) {
+				continue
+			}
+			if rng.q > bestQ {
+				best, bestQ = offer, rng.q
+			}
+			break
+		}
+	}
+	return best, best != ""
+}
+
+// mediaMatch сообщает, подходит ли тип typ под диапазон Accept pattern.
+func mediaMatch(pattern, typ string) bool {
+	if pattern == "*/*" || pattern == typ {
+		return true
+	}
+	prefix := strings.TrimSuffix(pattern, "*")
+	return prefix != pattern && strings.HasSuffix(prefix, "/") && strings.HasPrefix(typ, prefix)
+}
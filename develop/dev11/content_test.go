@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONParams(t *testing.T) {
+	params, err := jsonParams(strings.NewReader(`{"user_id": 1, "title": "Offsite", "all_day": true,
+		"tags": ["team", "q4"], "attendees": [2, 3], "description": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{"user_id": {"1"}, "title": {"Offsite"}, "all_day": {"true"}, "tags": {"team,q4"}, "attendees": {"2,3"}}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params %v, want %v", params, want)
+	}
+	for _, body := range []string{
+		`[1, 2]`,
+		`{"title": "x"} {"title": "y"}`,
+		`{"title": {"text": "x"}}`,
+		`{"tags": [["nested"]]}`,
+		`{"title": "x"`,
+	} {
+		if params, err := jsonParams(strings.NewReader(body)); err == nil {
+			t.Errorf("%s parsed as %v", body, params)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		want   string
+	}{
+		{"", jsonType},
+		{"text/calendar", calendarType},
+		{"text/*", calendarType},
+		{"*/*", jsonType},
+		{"text/calendar;q=0.5, application/json;q=0.4", calendarType},
+		{"application/json;q=0.1, */*", calendarType},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/events_for_day", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		got, ok := negotiate(r, jsonType, calendarType)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("Accept %q: %q, %v, want %q", tc.accept, got, ok, tc.want)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			RequestBody *struct {
+				Content map[string]json.RawMessage `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+	}
+	r := env.get("/openapi.json", nil).expect(t, http.StatusOK)
+	if err := json.Unmarshal(r.body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi %q", doc.OpenAPI)
+	}
+	for _, rt := range env.server.routes() {
+		op, ok := doc.Paths[rt.pattern][strings.ToLower(rt.method)]
+		if !ok {
+			t.Errorf("%s %s is not documented", rt.method, rt.pattern)
+			continue
+		}
+		if _, ok := op.Responses["200"]; !ok {
+			t.Errorf("%s has no 200 response", rt.pattern)
+		}
+		// формы принимают все POST методы с параметрами, кроме тех, у кого своё тело
+		if rt.method == http.MethodPost && rt.request == nil && rt.body == "" {
+			if op.RequestBody == nil || op.RequestBody.Content[formType] == nil || op.RequestBody.Content[jsonType] == nil {
+				t.Errorf("%s does not accept both a form and JSON", rt.pattern)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dateLayout — формат дат в параметрах и ответах API.
+const dateLayout = "2006-01-02"
+
+// Event — событие календаря пользователя.
+// Start и End — моменты времени, TZ — часовой пояс IANA, в котором событие
+// задано: в нём считаются календарные даты и повторения серии. Событие на
+// весь день (AllDay) длится от полуночи до полуночи по часам TZ.
+// Событие с Rule — серия повторений, начинающаяся в Start; даты из Exceptions
+// из серии исключены. Отдельно изменённый экземпляр серии хранится как
+// самостоятельное событие с SeriesID серии и RecurrenceID — исходной датой
+// повторения. UID связывает событие с внешними календарями.
+// Reminders — за сколько до начала каждого повторения напомнить о событии.
+// Tags — метки для поиска и фильтрации, в нижнем регистре.
+// UserID — организатор события, Attendees — приглашённые им участники:
+// событие видно и в их календарях, пока они не отказались.
+// Version увеличивается хранилищем при каждом изменении события.
+type Event struct {
+	ID           int64
+	Version      int64 `json:",omitempty"`
+	UserID       int64
+	Title        string
+	Description  string
+	Start        time.Time
+	End          time.Time
+	TZ           string          `json:",omitempty"`
+	AllDay       bool            `json:",omitempty"`
+	Rule         *Rule           `json:",omitempty"`
+	Exceptions   []time.Time     `json:",omitempty"`
+	SeriesID     int64           `json:",omitempty"`
+	RecurrenceID *time.Time      `json:",omitempty"`
+	UID          string          `json:",omitempty"`
+	Reminders    []time.Duration `json:",omitempty"`
+	Tags         []string        `json:",omitempty"`
+	Attendees    []Attendee      `json:",omitempty"`
+}
+
+// UnmarshalJSON читает и записи, сохранённые до появления Start и End,
+// когда событие задавалось одной датой Date на весь день по UTC,
+// и записи с нулевым RecurrenceID у событий, не отделённых от серии.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	aux := struct {
+		*plain
+		Date time.Time
+	}{plain: (*plain)(e)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if e.Start.IsZero() && !aux.Date.IsZero() {
+		e.Start = aux.Date
+		e.End = aux.Date.AddDate(0, 0, 1)
+		e.AllDay = true
+	}
+	// прежние записи хранят нулевой RecurrenceID у всех событий
+	if e.RecurrenceID != nil && e.RecurrenceID.IsZero() {
+		e.RecurrenceID = nil
+	}
+	return nil
+}
+
+// domainError — ошибка бизнес-логики, HTTP слой отвечает на неё кодом 503.
+type domainError string
+
+func (e domainError) Error() string {
+	return string(e)
+}
+
+// accessError — нарушение прав доступа к календарю, HTTP слой отвечает
+// на него кодом 403.
+type accessError string
+
+func (e accessError) Error() string {
+	return string(e)
+}
+
+// versionError — событие изменено другим запросом после того, как его
+// прочитал клиент; HTTP слой отвечает кодом 412.
+type versionError string
+
+func (e versionError) Error() string {
+	return string(e)
+}
+
+// ErrVersionConflict — версия события не совпала с ожидаемой.
+const ErrVersionConflict = versionError("event was changed by another request")
+
+// Ошибки доступа к чужим календарям и событиям.
+const (
+	ErrForbidden = accessError("no access to this calendar")
+	ErrNotOwner  = accessError("event belongs to another user")
+)
+
+// Ошибки бизнес-логики календаря.
+const (
+	ErrEventNotFound = domainError("event not found")
+	ErrInvalidEvent  = domainError("invalid event")
+	ErrNoOccurrence  = domainError("event has no occurrence on this date")
+	ErrEventExists   = domainError("event with this ID already exists")
+)
+
+// uid возвращает UID события для обмена с внешними календарями.
+func (e Event) uid() string {
+	if e.UID != "" {
+		return e.UID
+	}
+	return fmt.Sprintf("event-%d@dev11", e.ID)
+}
+
+// location возвращает часовой пояс события, UTC если он не задан.
+func (e Event) location() *time.Location {
+	loc, err := LoadZone(e.TZ)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// maxTagLen ограничивает длину метки события.
+const maxTagLen = 64
+
+// normalizeTags приводит метки к нижнему регистру, убирает пробелы по краям,
+// пустые и повторяющиеся метки и упорядочивает их.
+func normalizeTags(tags []string) []string {
+	var res []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// participants возвращает организатора и всех приглашённых.
+func (e Event) participants() []int64 {
+	res := []int64{e.UserID}
+	for _, a := range e.Attendees {
+		res = append(res, a.UserID)
+	}
+	return res
+}
+
+// Day возвращает календарную дату начала события в его часовом поясе.
+func (e Event) Day() time.Time {
+	return civilDate(e.Start, e.location())
+}
+
+// moveTo переносит событие на календарную дату day, сохраняя время суток
+// начала и конца в часовом поясе события.
+func (e *Event) moveTo(day time.Time) {
+	loc := e.location()
+	days := daysBetween(civilDate(e.Start, loc), civilDate(e.End, loc))
+	day = truncateDay(day)
+	e.Start = atDate(day, e.Start, loc)
+	e.End = atDate(day.AddDate(0, 0, days), e.End, loc)
+}
+
+// validate проверяет инварианты события перед сохранением.
+func (e Event) validate() error {
+	if e.Title == "" {
+		return fmt.Errorf("%w: title is empty", ErrInvalidEvent)
+	}
+	if e.Start.IsZero() {
+		return fmt.Errorf("%w: start is not set", ErrInvalidEvent)
+	}
+	if e.End.Before(e.Start) {
+		return fmt.Errorf("%w: end is before start", ErrInvalidEvent)
+	}
+	if _, err := LoadZone(e.TZ); err != nil {
+		return err
+	}
+	if e.Rule != nil {
+		if err := e.Rule.validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+		}
+	}
+	for _, r := range e.Reminders {
+		if r < 0 || r > maxReminder {
+			return fmt.Errorf("%w: reminder must be between 0 and %s before start", ErrInvalidEvent, maxReminder)
+		}
+	}
+	if err := e.validateAttendees(); err != nil {
+		return err
+	}
+	for _, tag := range e.Tags {
+		if tag == "" || len(tag) > maxTagLen || strings.ContainsAny(tag, ",") {
+			return fmt.Errorf("%w: tags must be non-empty, without commas and at most %d bytes", ErrInvalidEvent, maxTagLen)
+		}
+	}
+	return nil
+}
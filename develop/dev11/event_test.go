@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventRecurrenceIDJSON(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	b, err := json.Marshal(Event{ID: 1, UserID: 1, Title: "Standup", Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "RecurrenceID") {
+		t.Errorf("single event encoded as %s", b)
+	}
+	var e Event
+	old := `{"ID":1,"UserID":1,"Title":"Standup","Start":"2026-10-19T09:00:00Z","End":"2026-10-19T10:00:00Z",` +
+		`"RecurrenceID":"0001-01-01T00:00:00Z"}`
+	if err := json.Unmarshal([]byte(old), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.RecurrenceID != nil {
+		t.Errorf("zero RecurrenceID decoded as %v", e.RecurrenceID)
+	}
+	detached := `{"ID":2,"SeriesID":1,"RecurrenceID":"2026-10-20T00:00:00Z"}`
+	if err := json.Unmarshal([]byte(detached), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.RecurrenceID == nil || !e.RecurrenceID.Equal(date("2026-10-20")) {
+		t.Errorf("RecurrenceID decoded as %v", e.RecurrenceID)
+	}
+}
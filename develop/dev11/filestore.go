@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Имена файлов хранилища внутри каталога данных.
+const (
+	journalFile  = "events.journal"
+	snapshotFile = "events.snapshot"
+	auditFile    = "audit.log"
+)
+
+// Операции журнала.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opZone   = "zone"
+	opMark   = "mark"
+	opShare  = "share"
+)
+
+// journalRecord — одна запись журнала изменений.
+type journalRecord struct {
+	Op     string     `json:"op"`
+	Event  *Event     `json:"event,omitempty"`
+	ID     int64      `json:"id,omitempty"`
+	UserID int64      `json:"user_id,omitempty"`
+	Zone   string     `json:"zone,omitempty"`
+	Mark   *time.Time `json:"mark,omitempty"`
+	// Grantee и Access — доступ к календарю UserID.
+	Grantee int64       `json:"grantee,omitempty"`
+	Access  AccessLevel `json:"access,omitempty"`
+}
+
+// snapshotData — содержимое файла снимка.
+type snapshotData struct {
+	LastID int64            `json:"last_id"`
+	Events []Event          `json:"events"`
+	Zones  map[int64]string `json:"zones,omitempty"`
+	// Shares — доступы к календарям: владелец → пользователь → уровень.
+	Shares map[int64]map[int64]AccessLevel `json:"shares,omitempty"`
+	// ReminderMark — момент, до которого разосланы напоминания.
+	ReminderMark time.Time `json:"reminder_mark"`
+}
+
+// FileStore — хранилище событий в каталоге на диске.
+// Каждое изменение дописывается в журнал, а после compactEvery записей
+// состояние сохраняется в снимок и журнал обнуляется. Аудит только
+// дописывается в отдельный файл и не сжимается.
+// Чтения обслуживаются из копии данных в памяти.
+type FileStore struct {
+	mu           sync.Mutex
+	mem          *MemoryStore
+	dir          string
+	journal      *os.File
+	audit        *os.File
+	records      int
+	compactEvery int
+}
+
+// NewFileStore открывает (или создаёт) хранилище в каталоге dir и
+// восстанавливает состояние из снимка и журнала.
+func NewFileStore(dir string, compactEvery int) (*FileStore, error) {
+	if compactEvery <= 0 {
+		return nil, errors.New("compactEvery must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		mem:          NewMemoryStore(),
+		dir:          dir,
+		compactEvery: compactEvery,
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	f, err := s.openJournal()
+	if err != nil {
+		return nil, err
+	}
+	audit, err := s.openAudit()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.journal, s.audit = f, audit
+	if s.records >= s.compactEvery {
+		if err := s.compact(); err != nil {
+			f.Close()
+			audit.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// openAudit читает аудит в память и открывает файл для дописывания.
+// Недописанная последняя строка отрезается, чтобы следующая запись
+// начиналась с новой строки.
+func (s *FileStore) openAudit() (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, auditFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	var size int64
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("read audit record %d: %w", n, err)
+		}
+		s.mem.AppendAudit(rec)
+		size += int64(len(line))
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshotData
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	for _, e := range snap.Events {
+		s.mem.put(e)
+	}
+	for userID, zone := range snap.Zones {
+		s.mem.SetUserZone(userID, zone)
+	}
+	for owner, grants := range snap.Shares {
+		for grantee, level := range grants {
+			s.mem.SetShare(owner, grantee, level)
+		}
+	}
+	if snap.LastID > s.mem.lastID {
+		s.mem.lastID = snap.LastID
+	}
+	s.mem.SetReminderMark(snap.ReminderMark)
+	return nil
+}
+
+// openJournal применяет записи журнала поверх снимка и открывает журнал
+// для дописывания. Недописанная последняя строка (падение во время
+// записи) отбрасывается и отрезается, иначе следующая запись продолжила
+// бы её и журнал перестал бы читаться.
+func (s *FileStore) openJournal() (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, journalFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("read journal record %d: %w", s.records+1, err)
+		}
+		s.apply(rec)
+		s.records++
+		size += int64(len(line))
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// apply применяет запись журнала к данным в памяти. Записи идемпотентны,
+// поэтому повторное применение журнала поверх нового снимка безопасно.
+func (s *FileStore) apply(rec journalRecord) {
+	switch rec.Op {
+	case opPut:
+		if rec.Event != nil {
+			s.mem.put(*rec.Event)
+		}
+	case opDelete:
+		_ = s.mem.Delete(rec.ID)
+	case opZone:
+		s.mem.SetUserZone(rec.UserID, rec.Zone)
+	case opShare:
+		s.mem.SetShare(rec.UserID, rec.Grantee, rec.Access)
+	case opMark:
+		if rec.Mark != nil {
+			s.mem.SetReminderMark(*rec.Mark)
+		}
+	}
+}
+
+// appendRecord дописывает запись в журнал и применяет её к данным в памяти.
+func (s *FileStore) appendRecord(rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.journal.Write(data); err != nil {
+		return err
+	}
+	if err := s.journal.Sync(); err != nil {
+		return err
+	}
+	s.apply(rec)
+	s.records++
+	if s.records >= s.compactEvery {
+		// изменение уже сохранено в журнале: ошибка снимка не должна
+		// выглядеть как ошибка изменения, журнал сожмётся в следующий раз
+		if err := s.compact(); err != nil {
+			log.Printf("compact store: %v", err)
+		}
+	}
+	return nil
+}
+
+// compact сохраняет текущее состояние в снимок и обнуляет журнал.
+func (s *FileStore) compact() error {
+	data, err := json.Marshal(s.mem.snapshot())
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	// переименование надёжно только после записи каталога: иначе после
+	// сбоя может остаться старый снимок при уже обнулённом журнале
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.journal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.records = 0
+	return nil
+}
+
+// syncDir сбрасывает на диск записи каталога dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Create реализует EventStore.
+func (s *FileStore) Create(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = s.mem.peekNextID()
+	e.Version = 1
+	if err := s.appendRecord(journalRecord{Op: opPut, Event: &e}); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Update реализует EventStore.
+func (s *FileStore) Update(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.mem.Get(e.ID)
+	if err != nil {
+		return Event{}, err
+	}
+	if old.Version != e.Version {
+		return Event{}, ErrVersionConflict
+	}
+	e.Version++
+	if err := s.appendRecord(journalRecord{Op: opPut, Event: &e}); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Delete реализует EventStore.
+func (s *FileStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.mem.Get(id); err != nil {
+		return err
+	}
+	return s.appendRecord(journalRecord{Op: opDelete, ID: id})
+}
+
+// Restore реализует EventStore.
+func (s *FileStore) Restore(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.ID <= 0 || e.ID >= s.mem.peekNextID() {
+		return Event{}, ErrEventNotFound
+	}
+	if _, err := s.mem.Get(e.ID); err == nil {
+		return Event{}, ErrEventExists
+	}
+	e.Version++
+	if err := s.appendRecord(journalRecord{Op: opPut, Event: &e}); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Get реализует EventStore.
+func (s *FileStore) Get(id int64) (Event, error) {
+	return s.mem.Get(id)
+}
+
+// List реализует EventStore.
+func (s *FileStore) List(userID int64) ([]Event, error) {
+	return s.mem.List(userID)
+}
+
+// SetUserZone реализует EventStore.
+func (s *FileStore) SetUserZone(userID int64, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendRecord(journalRecord{Op: opZone, UserID: userID, Zone: zone})
+}
+
+// UserZone реализует EventStore.
+func (s *FileStore) UserZone(userID int64) (string, error) {
+	return s.mem.UserZone(userID)
+}
+
+// All реализует EventStore.
+func (s *FileStore) All() ([]Event, error) {
+	return s.mem.All()
+}
+
+// SetShare реализует EventStore.
+func (s *FileStore) SetShare(owner, grantee int64, level AccessLevel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendRecord(journalRecord{Op: opShare, UserID: owner, Grantee: grantee, Access: level})
+}
+
+// Shares реализует EventStore.
+func (s *FileStore) Shares(owner int64) (map[int64]AccessLevel, error) {
+	return s.mem.Shares(owner)
+}
+
+// SetReminderMark реализует EventStore.
+func (s *FileStore) SetReminderMark(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendRecord(journalRecord{Op: opMark, Mark: &t})
+}
+
+// ReminderMark реализует EventStore.
+func (s *FileStore) ReminderMark() (time.Time, error) {
+	return s.mem.ReminderMark()
+}
+
+// AppendAudit реализует EventStore.
+func (s *FileStore) AppendAudit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.audit.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.audit.Sync(); err != nil {
+		return err
+	}
+	return s.mem.AppendAudit(rec)
+}
+
+// Audit реализует EventStore.
+func (s *FileStore) Audit(eventID int64) ([]AuditRecord, error) {
+	return s.mem.Audit(eventID)
+}
+
+// Close сохраняет снимок и закрывает журнал и аудит.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	err := s.compact()
+	if cerr := s.journal.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := s.audit.Close(); err == nil {
+		err = cerr
+	}
+	s.journal, s.audit = nil, nil
+	return err
+}
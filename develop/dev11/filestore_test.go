@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// crash закрывает файлы хранилища без снимка, как при падении процесса.
+func crash(t *testing.T, s *FileStore) {
+	t.Helper()
+	if err := s.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFileStoreTornWrite проверяет, что недописанная запись журнала
+// отбрасывается и не портит следующие записи.
+func TestFileStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	store, err := NewFileStore(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Create(Event{UserID: 1, Title: "First", Start: day, End: day.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, store)
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"put","event":{"id":2,"tit`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for i, title := range []string{"Second", "Third"} {
+		store, err = NewFileStore(dir, 100)
+		if err != nil {
+			t.Fatalf("restart %d: %v", i+1, err)
+		}
+		if _, err := store.Create(Event{UserID: 1, Title: title, Start: day, End: day.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		crash(t, store)
+	}
+	store, err = NewFileStore(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	events, err := store.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, e := range events {
+		titles = append(titles, e.Title)
+	}
+	if len(events) != 3 || events[0].ID != first.ID {
+		t.Errorf("after torn write %v, want First, Second, Third", titles)
+	}
+}
+
+// TestFileStoreCompactFailure проверяет, что ошибка снимка не выдаётся
+// за ошибку уже сохранённого изменения.
+func TestFileStoreCompactFailure(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	store, err := NewFileStore(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// каталог на месте временного снимка не даёт его записать
+	if err := os.Mkdir(filepath.Join(dir, snapshotFile+".tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e, err := store.Create(Event{UserID: 1, Title: "Kept", Start: day, End: day.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create after failed compaction: %v", err)
+	}
+	e.Title = "Renamed"
+	if _, err := store.Update(e); err != nil {
+		t.Fatalf("Update after failed compaction: %v", err)
+	}
+	crash(t, store)
+
+	if err := os.Remove(filepath.Join(dir, snapshotFile+".tmp")); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileStore(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	events, err := store.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Title != "Renamed" {
+		t.Errorf("after restart %+v, want the renamed event", events)
+	}
+}
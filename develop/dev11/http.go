@@ -0,0 +1,1065 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inputError — ошибка входных данных, HTTP слой отвечает на неё кодом 400.
+type inputError struct {
+	param string
+	msg   string
+}
+
+func (e *inputError) Error() string {
+	return fmt.Sprintf("invalid parameter %q: %s", e.param, e.msg)
+}
+
+// eventJSON — представление события в ответах API.
+type eventJSON struct {
+	ID          int64          `json:"id"`
+	Version     int64          `json:"version"`
+	UserID      int64          `json:"user_id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Date        string         `json:"date"`
+	Start       string         `json:"start"`
+	End         string         `json:"end"`
+	TZ          string         `json:"tz"`
+	AllDay      bool           `json:"all_day,omitempty"`
+	RRule       string         `json:"rrule,omitempty"`
+	ExDates     []string       `json:"exdate,omitempty"`
+	SeriesID    int64          `json:"series_id,omitempty"`
+	Reminders   []string       `json:"reminders,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Attendees   []attendeeJSON `json:"attendees,omitempty"`
+}
+
+// attendeeJSON — участник события и его ответ на приглашение.
+type attendeeJSON struct {
+	UserID int64 `json:"user_id"`
+	Status RSVP  `json:"status"`
+}
+
+func newEventJSON(e Event) eventJSON {
+	loc := e.location()
+	v := eventJSON{
+		ID:          e.ID,
+		Version:     e.Version,
+		UserID:      e.UserID,
+		Title:       e.Title,
+		Description: e.Description,
+		Date:        e.Day().Format(dateLayout),
+		Start:       e.Start.In(loc).Format(time.RFC3339),
+		End:         e.End.In(loc).Format(time.RFC3339),
+		TZ:          loc.String(),
+		AllDay:      e.AllDay,
+		SeriesID:    e.SeriesID,
+		Tags:        e.Tags,
+	}
+	if e.Rule != nil {
+		v.RRule = e.Rule.String()
+	}
+	for _, ex := range e.Exceptions {
+		v.ExDates = append(v.ExDates, ex.Format(dateLayout))
+	}
+	for _, r := range e.Reminders {
+		v.Reminders = append(v.Reminders, formatDuration(r))
+	}
+	for _, a := range e.Attendees {
+		v.Attendees = append(v.Attendees, attendeeJSON{UserID: a.UserID, Status: a.Status})
+	}
+	return v
+}
+
+// conflictJSON — пересечение с другим событием в ответе API.
+type conflictJSON struct {
+	ID    int64  `json:"id"`
+	Start string `json:"start"`
+}
+
+// savedEventJSON — ответ /create_event и /update_event: событие и,
+// при политике warn, найденные пересечения.
+type savedEventJSON struct {
+	eventJSON
+	Conflicts []conflictJSON `json:"conflicts,omitempty"`
+}
+
+func newSavedEventJSON(e Event, conflicts []Conflict) savedEventJSON {
+	v := savedEventJSON{eventJSON: newEventJSON(e)}
+	for _, c := range conflicts {
+		v.Conflicts = append(v.Conflicts, conflictJSON{ID: c.EventID, Start: c.Start.Format(time.RFC3339)})
+	}
+	return v
+}
+
+// intervalJSON — интервал времени в ответе API.
+type intervalJSON struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func newIntervalsJSON(in []Interval) []intervalJSON {
+	res := make([]intervalJSON, 0, len(in))
+	for _, iv := range in {
+		res = append(res, intervalJSON{Start: iv.Start.Format(time.RFC3339), End: iv.End.Format(time.RFC3339)})
+	}
+	return res
+}
+
+// freeBusyJSON — ответ /free_busy.
+type freeBusyJSON struct {
+	Busy []intervalJSON `json:"busy"`
+	Free []intervalJSON `json:"free"`
+}
+
+// timezoneJSON — ответ /set_timezone.
+type timezoneJSON struct {
+	UserID int64  `json:"user_id"`
+	TZ     string `json:"tz"`
+}
+
+// shareJSON — ответ /share.
+type shareJSON struct {
+	Owner   int64       `json:"owner"`
+	Grantee int64       `json:"grantee"`
+	Access  AccessLevel `json:"access"`
+}
+
+// grantJSON — доступ к календарю в ответе /shares.
+type grantJSON struct {
+	Grantee int64       `json:"grantee"`
+	Access  AccessLevel `json:"access"`
+}
+
+// importJSON — ответ /import.
+type importJSON struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+func newEventsJSON(events []Event) []eventJSON {
+	res := make([]eventJSON, 0, len(events))
+	for _, e := range events {
+		res = append(res, newEventJSON(e))
+	}
+	return res
+}
+
+// writeResult отправляет успешный ответ {"result": ...}.
+func writeResult(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": result})
+}
+
+// writeError отправляет ответ {"error": ...} с кодом, зависящим от вида ошибки.
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+// errorStatus сопоставляет ошибке HTTP код: 400 — входные данные,
+// 403 — нет доступа к календарю, 503 — бизнес-логика, 500 — всё остальное.
+func errorStatus(err error) int {
+	var inErr *inputError
+	var domErr domainError
+	var stErr *statusError
+	var accErr accessError
+	var verErr versionError
+	switch {
+	case errors.As(err, &stErr):
+		return stErr.status
+	case errors.As(err, &inErr):
+		return http.StatusBadRequest
+	case errors.As(err, &accErr):
+		return http.StatusForbidden
+	case errors.As(err, &verErr):
+		return http.StatusPreconditionFailed
+	case errors.As(err, &domErr):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// eventETag возвращает ETag версии события.
+func eventETag(version int64) string {
+	return `"v` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag отдаёт версию сохранённого события в заголовке ETag.
+func setETag(w http.ResponseWriter, e Event) {
+	w.Header().Set("ETag", eventETag(e.Version))
+}
+
+// ifMatch переносит версию события из заголовка If-Match в параметр
+// version, которым её принимают операции API: «*» снимает проверку версии,
+// а чужой ETag заведомо не совпадёт с событием.
+func ifMatch(r *http.Request, params url.Values) error {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch match {
+	case "":
+		return nil
+	case "*":
+		params.Del("version")
+		return nil
+	}
+	v := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(match, "W/"), `"v`), `"`)
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || eventETag(n) != strings.TrimPrefix(match, "W/") {
+		return ErrVersionConflict
+	}
+	params.Set("version", strconv.FormatInt(n, 10))
+	return nil
+}
+
+// paramVersion возвращает версию события, которую клиент ожидает
+// изменить, из параметра version; 0 — любую.
+func paramVersion(params url.Values) (int64, error) {
+	if params.Get("version") == "" {
+		return 0, nil
+	}
+	return parseInt(params, "version")
+}
+
+func parseInt(params url.Values, name string) (int64, error) {
+	v := params.Get(name)
+	if v == "" {
+		return 0, &inputError{param: name, msg: "required"}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, &inputError{param: name, msg: "must be a positive integer"}
+	}
+	return n, nil
+}
+
+func parseDate(params url.Values, name string) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, &inputError{param: name, msg: "required"}
+	}
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		return time.Time{}, &inputError{param: name, msg: "must be a date in YYYY-MM-DD format"}
+	}
+	return t, nil
+}
+
+// localLayouts — форматы времени по часам часового пояса события.
+var localLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// parseTime разбирает момент времени: RFC 3339 со смещением или время
+// по часам зоны loc. Отсутствующий параметр даёт нулевое время.
+func parseTime(params url.Values, name string, loc *time.Location) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &inputError{param: name, msg: "must be YYYY-MM-DDTHH:MM local time or RFC 3339"}
+}
+
+// timing — время события из параметров запроса. Нулевой end означает,
+// что конец не задан ни через end, ни через duration.
+type timing struct {
+	start, end time.Time
+	allDay     bool
+}
+
+// defaultDuration — длительность события, если не заданы end и duration.
+const defaultDuration = time.Hour
+
+// parseTiming разбирает время события в зоне loc: либо start с end или
+// duration, либо date (и days) для события на весь день. ok равно false,
+// если не задано ни то, ни другое.
+func parseTiming(params url.Values, loc *time.Location) (t timing, ok bool, err error) {
+	start, err := parseTime(params, "start", loc)
+	if err != nil {
+		return timing{}, false, err
+	}
+	if !start.IsZero() {
+		end, err := parseTime(params, "end", loc)
+		if err != nil {
+			return timing{}, false, err
+		}
+		if end.IsZero() && params.Get("duration") != "" {
+			d, err := parseDuration(params, "duration", 0)
+			if err != nil {
+				return timing{}, false, err
+			}
+			end = start.Add(d)
+		}
+		if !end.IsZero() && end.Before(start) {
+			return timing{}, false, &inputError{param: "end", msg: "must not be before start"}
+		}
+		return timing{start: start, end: end}, true, nil
+	}
+	if params.Get("date") == "" {
+		return timing{}, false, nil
+	}
+	date, err := parseDate(params, "date")
+	if err != nil {
+		return timing{}, false, err
+	}
+	days := int64(1)
+	if params.Get("days") != "" {
+		if days, err = parseInt(params, "days"); err != nil {
+			return timing{}, false, err
+		}
+	}
+	return timing{
+		start:  midnight(date, loc),
+		end:    midnight(date.AddDate(0, 0, int(days)), loc),
+		allDay: true,
+	}, true, nil
+}
+
+// parseZone разбирает необязательный параметр tz — имя часового пояса IANA.
+func parseZone(params url.Values) (*time.Location, bool, error) {
+	name := params.Get("tz")
+	if name == "" {
+		return nil, false, nil
+	}
+	loc, err := LoadZone(name)
+	if err != nil {
+		return nil, false, &inputError{param: "tz", msg: "unknown time zone"}
+	}
+	return loc, true, nil
+}
+
+// zoneName возвращает имя зоны для хранения в событии: UTC хранится пустой строкой.
+func zoneName(loc *time.Location) string {
+	if loc == time.UTC {
+		return ""
+	}
+	return loc.String()
+}
+
+// parseRule разбирает необязательное правило повторения rrule.
+func parseRule(params url.Values, name string) (*Rule, error) {
+	v := params.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	r, err := ParseRule(v)
+	if err != nil {
+		return nil, &inputError{param: name, msg: err.Error()}
+	}
+	return r, nil
+}
+
+// parseDates разбирает необязательный список дат через запятую.
+func parseDates(params url.Values, name string) ([]time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	var res []time.Time
+	for _, s := range strings.Split(v, ",") {
+		t, err := time.Parse(dateLayout, strings.TrimSpace(s))
+		if err != nil {
+			return nil, &inputError{param: name, msg: "must be a comma separated list of YYYY-MM-DD dates"}
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// parseOccurrence разбирает необязательный параметр occurrence — дату
+// повторения серии, к которому относится запрос.
+func parseOccurrence(params url.Values) (*time.Time, error) {
+	if params.Get("occurrence") == "" {
+		return nil, nil
+	}
+	day, err := parseDate(params, "occurrence")
+	if err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// parseIDs разбирает список ID: повторяющийся параметр или значения через запятую.
+func parseIDs(params url.Values, name string) ([]int64, error) {
+	var res []int64
+	for _, v := range params[name] {
+		for _, part := range strings.Split(v, ",") {
+			n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil || n <= 0 {
+				return nil, &inputError{param: name, msg: "must be a list of positive integers"}
+			}
+			res = append(res, n)
+		}
+	}
+	if len(res) == 0 {
+		return nil, &inputError{param: name, msg: "required"}
+	}
+	return res, nil
+}
+
+// parseDuration разбирает необязательную длительность в формате Go (30m, 1h30m).
+func parseDuration(params url.Values, name string, def time.Duration) (time.Duration, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, &inputError{param: name, msg: "must be a non-negative duration like 30m or 1h"}
+	}
+	return d, nil
+}
+
+// parseReminders разбирает необязательный список напоминаний через запятую:
+// за сколько до начала события напомнить (15m, 1h, 24h).
+func parseReminders(params url.Values, name string) ([]time.Duration, error) {
+	v := params.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	var res []time.Duration
+	for _, s := range strings.Split(v, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || d < 0 || d > maxReminder {
+			return nil, &inputError{param: name, msg: "must be a comma separated list of durations like 15m or 1h, at most " + formatDuration(maxReminder)}
+		}
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// parseTags разбирает необязательный список меток через запятую.
+func parseTags(params url.Values, name string) []string {
+	v := params.Get(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// requestLocation возвращает часовой пояс запроса: из параметра tz или
+// пояс пользователя по умолчанию.
+func (s *Server) requestLocation(params url.Values, userID int64) (*time.Location, error) {
+	loc, ok, err := parseZone(params)
+	if err != nil || ok {
+		return loc, err
+	}
+	return s.cal.UserLocation(userID)
+}
+
+// conflictPolicy возвращает политику пересечений из параметра conflict
+// или политику сервера по умолчанию.
+func (s *Server) conflictPolicy(params url.Values) (ConflictPolicy, error) {
+	v := params.Get("conflict")
+	if v == "" {
+		return ConflictPolicy(s.conflicts.Load()), nil
+	}
+	policy, err := ParseConflictPolicy(v)
+	if err != nil {
+		return 0, &inputError{param: "conflict", msg: "must be one of allow, warn, reject"}
+	}
+	return policy, nil
+}
+
+// optionalString возвращает указатель на значение параметра или nil, если его нет.
+func optionalString(params url.Values, name string) *string {
+	if _, ok := params[name]; !ok {
+		return nil
+	}
+	v := params.Get(name)
+	return &v
+}
+
+// parseCreateEvent разбирает и проверяет параметры /create_event.
+// Время без смещения считается по часам зоны loc.
+func parseCreateEvent(params url.Values, loc *time.Location) (Event, error) {
+	userID, err := parseInt(params, "user_id")
+	if err != nil {
+		return Event{}, err
+	}
+	t, ok, err := parseTiming(params, loc)
+	if err != nil {
+		return Event{}, err
+	}
+	if !ok {
+		return Event{}, &inputError{param: "date", msg: "date or start is required"}
+	}
+	if t.end.IsZero() {
+		t.end = t.start.Add(defaultDuration)
+	}
+	title := params.Get("title")
+	if title == "" {
+		return Event{}, &inputError{param: "title", msg: "required"}
+	}
+	rule, err := parseRule(params, "rrule")
+	if err != nil {
+		return Event{}, err
+	}
+	exdates, err := parseDates(params, "exdate")
+	if err != nil {
+		return Event{}, err
+	}
+	reminders, err := parseReminders(params, "reminders")
+	if err != nil {
+		return Event{}, err
+	}
+	var attendees []int64
+	if params.Get("attendees") != "" {
+		if attendees, err = parseIDs(params, "attendees"); err != nil {
+			return Event{}, err
+		}
+	}
+	e := Event{
+		UserID:      userID,
+		Title:       title,
+		Description: params.Get("description"),
+		Start:       t.start,
+		End:         t.end,
+		TZ:          zoneName(loc),
+		AllDay:      t.allDay,
+		Rule:        rule,
+		Exceptions:  exdates,
+		Reminders:   reminders,
+		Tags:        parseTags(params, "tags"),
+	}
+	e.setAttendees(attendees)
+	return e, nil
+}
+
+// parseEventUpdate разбирает и проверяет изменяемые поля /update_event.
+// Одна date без start переносит событие на другой день с тем же временем.
+// Время без смещения считается по часам зоны loc.
+func parseEventUpdate(params url.Values, loc *time.Location) (upd EventUpdate, err error) {
+	upd.Title = optionalString(params, "title")
+	if upd.Title != nil && *upd.Title == "" {
+		err = &inputError{param: "title", msg: "must not be empty"}
+		return
+	}
+	upd.Description = optionalString(params, "description")
+	if params.Get("tz") != "" {
+		tz := zoneName(loc)
+		upd.TZ = &tz
+	}
+	if params.Get("start") == "" && params.Get("days") == "" && params.Get("date") != "" {
+		var date time.Time
+		if date, err = parseDate(params, "date"); err != nil {
+			return
+		}
+		upd.Date = &date
+	} else {
+		var (
+			t  timing
+			ok bool
+		)
+		if t, ok, err = parseTiming(params, loc); err != nil {
+			return
+		}
+		if ok {
+			upd.Start, upd.AllDay = &t.start, &t.allDay
+			if !t.end.IsZero() {
+				upd.End = &t.end
+			}
+		}
+	}
+	// пустой rrule превращает серию в одиночное событие
+	if rrule := optionalString(params, "rrule"); rrule != nil && *rrule == "" {
+		upd.RemoveRule = true
+	} else if upd.Rule, err = parseRule(params, "rrule"); err != nil {
+		return
+	}
+	if upd.Exceptions, err = parseDates(params, "exdate"); err != nil {
+		return
+	}
+	// пустой reminders убирает напоминания
+	if reminders := optionalString(params, "reminders"); reminders != nil && *reminders == "" {
+		upd.RemoveReminders = true
+	} else if upd.Reminders, err = parseReminders(params, "reminders"); err != nil {
+		return
+	}
+	// пустой tags убирает метки
+	if tags := optionalString(params, "tags"); tags != nil && *tags == "" {
+		upd.RemoveTags = true
+	} else {
+		upd.Tags = parseTags(params, "tags")
+	}
+	// пустой attendees отменяет все приглашения
+	if attendees := optionalString(params, "attendees"); attendees != nil && *attendees == "" {
+		upd.RemoveAttendees = true
+	} else if attendees != nil {
+		upd.Attendees, err = parseIDs(params, "attendees")
+	}
+	return
+}
+
+// Server — HTTP обработчики методов API календаря.
+type Server struct {
+	cal *Calendar
+	// conflicts — политика пересечений, если запрос не задаёт conflict.
+	conflicts atomic.Int32
+	// auth — аутентификация запросов, по умолчанию выключена.
+	auth *Authenticator
+
+	// streamsDone закрывается при остановке сервера и завершает потоки SSE
+	// и соединения JSON-RPC.
+	streamsDone  chan struct{}
+	closeStreams sync.Once
+}
+
+// NewServer создаёт обработчики поверх бизнес-логики cal.
+// conflicts — политика пересечений для запросов без параметра conflict.
+func NewServer(cal *Calendar, conflicts ConflictPolicy) *Server {
+	s := &Server{cal: cal, auth: NewAuthenticator(systemClock{}), streamsDone: make(chan struct{})}
+	s.SetConflictPolicy(conflicts)
+	return s
+}
+
+// SetConflictPolicy меняет политику пересечений по умолчанию на ходу.
+func (s *Server) SetConflictPolicy(p ConflictPolicy) {
+	s.conflicts.Store(int32(p))
+}
+
+// SetCredentials меняет API ключи и секрет токенов на ходу. Без ключей
+// и секрета аутентификация выключена и user_id в запросах не проверяется.
+func (s *Server) SetCredentials(keys map[string]int64, secret []byte) {
+	s.auth.Configure(keys, secret)
+}
+
+// Authenticate возвращает middleware аутентификации; пути public
+// доступны без неё.
+func (s *Server) Authenticate(public ...string) middleware {
+	return s.auth.middleware(public...)
+}
+
+func (s *Server) createEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	e, conflicts, err := s.createOp(r.Context(), s.cal, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setETag(w, e)
+	writeResult(w, newSavedEventJSON(e, conflicts))
+}
+
+func (s *Server) updateEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err == nil {
+		err = ifMatch(r, params)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	e, conflicts, err := s.updateOp(r.Context(), s.cal, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setETag(w, e)
+	writeResult(w, newSavedEventJSON(e, conflicts))
+}
+
+func (s *Server) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err == nil {
+		err = ifMatch(r, params)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.deleteOp(r.Context(), s.cal, params); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, "event deleted")
+}
+
+// createOp, updateOp и deleteOp разбирают параметры params и выполняют
+// действие над календарём cal от имени пользователя из ctx: общий код
+// методов API, /batch и JSON-RPC.
+
+func (s *Server) createOp(ctx context.Context, cal *Calendar, params url.Values) (Event, []Conflict, error) {
+	userID, err := s.contextUser(ctx, params, AccessWrite)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	cal = cal.As(actor(ctx, userID))
+	loc, err := s.requestLocation(params, userID)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	e, err := parseCreateEvent(params, loc)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	policy, err := s.conflictPolicy(params)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	return cal.CreateEvent(e, policy)
+}
+
+func (s *Server) updateOp(ctx context.Context, cal *Calendar, params url.Values) (Event, []Conflict, error) {
+	userID, err := s.contextUser(ctx, params, AccessWrite)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	cal = cal.As(actor(ctx, userID))
+	id, err := parseInt(params, "id")
+	if err != nil {
+		return Event{}, nil, err
+	}
+	// время без смещения считается по часам tz или зоны самого события
+	loc, ok, err := parseZone(params)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if !ok {
+		e, err := cal.Event(userID, id)
+		if err != nil {
+			return Event{}, nil, err
+		}
+		loc = e.location()
+	}
+	upd, err := parseEventUpdate(params, loc)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if upd.Version, err = paramVersion(params); err != nil {
+		return Event{}, nil, err
+	}
+	occurrence, err := parseOccurrence(params)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	policy, err := s.conflictPolicy(params)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if occurrence != nil {
+		return cal.UpdateOccurrence(userID, id, *occurrence, upd, policy)
+	}
+	return cal.UpdateEvent(userID, id, upd, policy)
+}
+
+func (s *Server) deleteOp(ctx context.Context, cal *Calendar, params url.Values) error {
+	userID, err := s.contextUser(ctx, params, AccessWrite)
+	if err != nil {
+		return err
+	}
+	cal = cal.As(actor(ctx, userID))
+	id, err := parseInt(params, "id")
+	if err != nil {
+		return err
+	}
+	occurrence, err := parseOccurrence(params)
+	if err != nil {
+		return err
+	}
+	version, err := paramVersion(params)
+	if err != nil {
+		return err
+	}
+	if occurrence != nil {
+		return cal.DeleteOccurrence(userID, id, *occurrence, version)
+	}
+	return cal.DeleteEvent(userID, id, version)
+}
+
+// eventsFor строит обработчик запроса событий за период.
+func (s *Server) eventsFor(query func(userID int64, date time.Time) ([]Event, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := requestParams(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		events, err := s.eventsOp(r.Context(), params, query)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if typ, _ := negotiate(r, jsonType, calendarType); typ == calendarType {
+			writeInstancesICal(w, events)
+			return
+		}
+		writeResult(w, newEventsJSON(events))
+	}
+}
+
+// eventsOp разбирает параметры params выборки событий за период и
+// выполняет query для пользователя из ctx.
+func (s *Server) eventsOp(ctx context.Context, params url.Values, query func(userID int64, date time.Time) ([]Event, error)) ([]Event, error) {
+	userID, err := s.contextUser(ctx, params, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseDate(params, "date")
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.requestLocation(params, userID)
+	if err != nil {
+		return nil, err
+	}
+	return query(userID, midnight(date, loc))
+}
+
+// writeInstancesICal выгружает повторения событий как отдельные VEVENT:
+// повторение серии получает UID серии и RECURRENCE-ID своей даты.
+func writeInstancesICal(w http.ResponseWriter, events []Event) {
+	instances := make([]Event, 0, len(events))
+	for _, e := range events {
+		if e.Rule != nil {
+			e.SeriesID = e.ID
+			day := e.Day()
+			e.RecurrenceID = &day
+			e.Rule, e.Exceptions = nil, nil
+		}
+		instances = append(instances, e)
+	}
+	w.Header().Set("Content-Type", calendarType+"; charset=utf-8")
+	if err := writeICal(w, instances, time.Now()); err != nil {
+		log.Printf("write calendar: %v", err)
+	}
+}
+
+// setTimezone задаёт часовой пояс пользователя по умолчанию.
+// Пустой tz возвращает пользователя к UTC.
+func (s *Server) setTimezone(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := s.requestUser(r, params, AccessWrite)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	loc, ok, err := parseZone(params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ok {
+		loc = time.UTC
+	}
+	if err := s.cal.SetUserZone(userID, zoneName(loc)); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, timezoneJSON{UserID: userID, TZ: loc.String()})
+}
+
+// respondEvent записывает ответ участника user_id на приглашение.
+func (s *Server) respondEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := s.requestUser(r, params, AccessWrite)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := parseInt(params, "id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status, err := ParseRSVP(params.Get("status"))
+	if err != nil {
+		writeError(w, &inputError{param: "status", msg: "must be one of accepted, declined, tentative, pending"})
+		return
+	}
+	e, err := s.cal.As(actor(r.Context(), userID)).RespondEvent(userID, id, status)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setETag(w, e)
+	writeResult(w, newEventJSON(e))
+}
+
+// share открывает или закрывает доступ другого пользователя к календарю.
+// Доступом распоряжается только владелец.
+func (s *Server) share(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	owner, err := s.requestUser(r, params, AccessOwner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	grantee, err := parseInt(params, "grantee")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	level, err := ParseAccessLevel(params.Get("access"))
+	if err != nil {
+		writeError(w, &inputError{param: "access", msg: "must be none, read or write"})
+		return
+	}
+	if err := s.cal.ShareCalendar(owner, grantee, level); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, shareJSON{Owner: owner, Grantee: grantee, Access: level})
+}
+
+// shares перечисляет пользователей, которым открыт календарь.
+func (s *Server) shares(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	owner, err := s.requestUser(r, params, AccessOwner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	shares, err := s.cal.Shares(owner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]grantJSON, 0, len(shares))
+	for _, sh := range shares {
+		res = append(res, grantJSON{Grantee: sh.Grantee, Access: sh.Level})
+	}
+	writeResult(w, res)
+}
+
+// freeBusy возвращает занятость пользователей из user_id (несколько через
+// запятую) с from по to включительно и свободные промежутки не короче min.
+// Границы дат считаются в зоне tz или первого пользователя.
+func (s *Server) freeBusy(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	if p := principalFrom(r.Context()); p != nil && params.Get("user_id") == "" {
+		params.Set("user_id", strconv.FormatInt(p.UserID, 10))
+	}
+	userIDs, err := parseIDs(params, "user_id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, userID := range userIDs {
+		if err := s.authorize(r.Context(), userID, AccessRead); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	from, err := parseDate(params, "from")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	to, err := parseDate(params, "to")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if to.Before(from) {
+		writeError(w, &inputError{param: "to", msg: "must not be before from"})
+		return
+	}
+	minFree, err := parseDuration(params, "min", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	loc, err := s.requestLocation(params, userIDs[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fb, err := s.cal.FreeBusy(userIDs, midnight(from, loc), midnight(to.AddDate(0, 0, 1), loc), minFree)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, freeBusyJSON{Busy: newIntervalsJSON(fb.Busy), Free: newIntervalsJSON(fb.Free)})
+}
+
+// maxImportSize ограничивает размер загружаемого .ics файла.
+const maxImportSize = 10 << 20
+
+// exportICal выгружает все события пользователя в формате iCalendar.
+func (s *Server) exportICal(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.requestUser(r, r.URL.Query(), AccessRead)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	events, err := s.cal.UserEvents(userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="calendar.ics"`)
+	if err := writeICal(w, events, time.Now()); err != nil {
+		log.Printf("write calendar: %v", err)
+	}
+}
+
+// importICal загружает события из тела запроса в формате iCalendar.
+// user_id передаётся в query string, так как тело занято календарём.
+// Даты и время без зоны считаются в зоне tz или пользователя.
+func (s *Server) importICal(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, err := s.requestUser(r, query, AccessWrite)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	loc, err := s.requestLocation(query, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	events, err := readICal(http.MaxBytesReader(w, r.Body, maxImportSize), loc)
+	if err != nil {
+		writeError(w, &inputError{param: "body", msg: err.Error()})
+		return
+	}
+	res, err := s.cal.As(actor(r.Context(), userID)).ImportEvents(userID, events)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, importJSON{Created: res.Created, Updated: res.Updated})
+}
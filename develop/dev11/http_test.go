@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEventUpdateErrors(t *testing.T) {
+	for _, params := range []map[string]string{
+		{"title": ""},
+		{"date": "19.10.2026"},
+		{"rrule": "FREQ=YEARLY"},
+		{"exdate": "tomorrow"},
+		// ошибка в напоминаниях не теряется из-за верных участников
+		{"reminders": "soon", "attendees": "2,3"},
+		{"attendees": "two"},
+	} {
+		var kv []string
+		for k, v := range params {
+			kv = append(kv, k, v)
+		}
+		if upd, err := parseEventUpdate(form(kv...), time.UTC); err == nil {
+			t.Errorf("%v parsed as %+v", params, upd)
+		}
+	}
+	upd, err := parseEventUpdate(form("reminders", "", "attendees", "2,3"), time.UTC)
+	if err != nil || !upd.RemoveReminders || len(upd.Attendees) != 2 {
+		t.Errorf("update %+v, %v", upd, err)
+	}
+}
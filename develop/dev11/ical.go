@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Форматы дат iCalendar (RFC 5545).
+const (
+	icalDateLayout     = "20060102"
+	icalDateTimeLayout = "20060102T150405Z"
+	icalLocalLayout    = "20060102T150405"
+)
+
+// icalProdID — идентификатор продукта в выгрузке.
+const icalProdID = "-//wb-l2//dev11 calendar//EN"
+
+// icalLineLimit — максимальная длина строки в октетах до переноса.
+const icalLineLimit = 75
+
+// writeICal записывает события в формате VCALENDAR. Время событий с часовым
+// поясом выводится с параметром TZID по имени IANA; компоненты VTIMEZONE
+// не формируются, клиенты берут правила из своей базы часовых поясов.
+func writeICal(w io.Writer, events []Event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	byID := make(map[int64]Event, len(events))
+	for _, e := range events {
+		byID[e.ID] = e
+	}
+	line := func(name, value string) {
+		writeICalLine(bw, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", icalProdID)
+	for _, e := range events {
+		line("BEGIN", "VEVENT")
+		uid := e.uid()
+		series, hasSeries := byID[e.SeriesID]
+		if e.SeriesID != 0 && hasSeries {
+			uid = series.uid()
+		}
+		line("UID", uid)
+		line("DTSTAMP", now.UTC().Format(icalDateTimeLayout))
+		line(icalTime("DTSTART", e, e.Start))
+		line(icalTime("DTEND", e, e.End))
+		line("SUMMARY", escapeICalText(e.Title))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeICalText(e.Description))
+		}
+		if len(e.Tags) > 0 {
+			tags := make([]string, len(e.Tags))
+			for i, tag := range e.Tags {
+				tags[i] = escapeICalText(tag)
+			}
+			line("CATEGORIES", strings.Join(tags, ","))
+		}
+		if e.Rule != nil {
+			line("RRULE", e.rrule())
+		}
+		for _, ex := range e.Exceptions {
+			line(icalTime("EXDATE", e, e.instance(ex).Start))
+		}
+		if e.SeriesID != 0 && e.RecurrenceID != nil {
+			// исходное время повторения берётся из серии, если она выгружается вместе с ним
+			orig := e
+			if hasSeries {
+				orig = series
+			}
+			line(icalTime("RECURRENCE-ID", orig, orig.instance(*e.RecurrenceID).Start))
+		}
+		for _, before := range e.Reminders {
+			line("BEGIN", "VALARM")
+			line("ACTION", "DISPLAY")
+			line("DESCRIPTION", escapeICalText(e.Title))
+			line("TRIGGER", formatICalDuration(-before))
+			line("END", "VALARM")
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// icalTime возвращает имя свойства с параметрами и значение момента t
+// в представлении, соответствующем событию e: дата для событий на весь день,
+// UTC для событий без часового пояса, иначе местное время с TZID.
+func icalTime(name string, e Event, t time.Time) (string, string) {
+	loc := e.location()
+	switch {
+	case e.AllDay:
+		return name + ";VALUE=DATE", civilDate(t, loc).Format(icalDateLayout)
+	case e.TZ == "":
+		return name, t.UTC().Format(icalDateTimeLayout)
+	default:
+		return name + ";TZID=" + e.TZ, t.In(loc).Format(icalLocalLayout)
+	}
+}
+
+// writeICalLine записывает строку содержимого, перенося её по 75 октетов
+// без разрыва многобайтовых символов.
+func writeICalLine(w *bufio.Writer, s string) {
+	for len(s) > icalLineLimit {
+		cut := icalLineLimit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+var icalUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escapeICalText(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+// splitICalText разбирает список текстовых значений через запятую,
+// не разделяя по экранированным запятым.
+func splitICalText(v string) []string {
+	var res []string
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case ',':
+			res = append(res, icalUnescaper.Replace(v[start:i]))
+			start = i + 1
+		}
+	}
+	return append(res, icalUnescaper.Replace(v[start:]))
+}
+
+// icalProperty — строка содержимого: имя, параметры и значение.
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// readICalLines читает строки содержимого, склеивая перенесённые.
+func readICalLines(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if l == "" {
+			continue
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, sc.Err()
+}
+
+func parseICalProperty(line string) (icalProperty, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return icalProperty{}, fmt.Errorf("malformed line %q", line)
+	}
+	head := strings.Split(line[:colon], ";")
+	p := icalProperty{
+		name:   strings.ToUpper(head[0]),
+		params: make(map[string]string),
+		value:  line[colon+1:],
+	}
+	for _, param := range head[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return p, nil
+}
+
+// icalTimeValue — разобранное значение DATE или DATE-TIME.
+type icalTimeValue struct {
+	t      time.Time
+	isDate bool
+	zone   string
+}
+
+// parseICalTime разбирает значение DATE или DATE-TIME. Время с суффиксом Z
+// считается в UTC, с параметром TZID — в указанной зоне, «плавающее» время
+// и даты — в зоне def.
+func parseICalTime(value string, params map[string]string, def *time.Location) (icalTimeValue, error) {
+	if strings.EqualFold(params["VALUE"], "DATE") || len(value) == len(icalDateLayout) {
+		t, err := time.ParseInLocation(icalDateLayout, value, def)
+		if err != nil {
+			return icalTimeValue{}, fmt.Errorf("invalid date %q", value)
+		}
+		return icalTimeValue{t: t, isDate: true, zone: zoneName(def)}, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalDateTimeLayout, value)
+		if err != nil {
+			return icalTimeValue{}, fmt.Errorf("invalid date-time %q", value)
+		}
+		return icalTimeValue{t: t}, nil
+	}
+	loc := def
+	if tzid := params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = LoadZone(tzid); err != nil {
+			return icalTimeValue{}, err
+		}
+	}
+	t, err := time.ParseInLocation(icalLocalLayout, value, loc)
+	if err != nil {
+		return icalTimeValue{}, fmt.Errorf("invalid date-time %q", value)
+	}
+	return icalTimeValue{t: t, zone: zoneName(loc)}, nil
+}
+
+// Единицы длительности до и после T.
+var (
+	icalDateUnits = map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	icalTimeUnits = map[rune]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+)
+
+// parseICalDuration разбирает длительность вида [+-]P[nW][nD][T[nH][nM][nS]].
+func parseICalDuration(value string) (time.Duration, error) {
+	s := value
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var (
+		d      time.Duration
+		n      int
+		digits bool
+		inTime bool
+	)
+	for _, c := range s[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T' && !inTime && !digits:
+			inTime = true
+			continue
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		units := icalDateUnits
+		if inTime {
+			units = icalTimeUnits
+		}
+		u, ok := units[c]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d += time.Duration(n) * u
+		n, digits = 0, false
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return sign * d, nil
+}
+
+// formatICalDuration записывает длительность в виде [-]P[nD][T[nH][nM][nS]].
+func formatICalDuration(d time.Duration) string {
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteByte('P')
+	if days := d / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d > 0 || b.Len() <= 2 {
+		b.WriteByte('T')
+		h, m, sec := d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second
+		if h > 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if m > 0 {
+			fmt.Fprintf(&b, "%dM", m)
+		}
+		if sec > 0 || h == 0 && m == 0 {
+			fmt.Fprintf(&b, "%dS", sec)
+		}
+	}
+	return b.String()
+}
+
+// rrule возвращает правило серии для RRULE. UNTIL по RFC 5545 того же
+// типа, что DTSTART: у события со временем это начало последнего
+// допустимого повторения по UTC.
+func (e Event) rrule() string {
+	if e.AllDay || e.Rule.Until.IsZero() {
+		return e.Rule.String()
+	}
+	return e.Rule.format(e.instance(e.Rule.Until).Start.UTC().Format(icalDateTimeLayout))
+}
+
+// icalEvent — VEVENT в процессе разбора: DTEND, DURATION и исключения
+// можно привести к событию только после того, как известен DTSTART.
+type icalEvent struct {
+	Event
+	end        *icalTimeValue
+	duration   *time.Duration
+	exceptions []time.Time
+	// rrule — RRULE: UNTIL в нём переводится в дату по зоне DTSTART
+	rrule string
+	// inAlarm — разбирается вложенный VALARM
+	inAlarm bool
+}
+
+// finish вычисляет конец события и календарные даты исключений.
+func (ie *icalEvent) finish() {
+	e := &ie.Event
+	loc := e.location()
+	switch {
+	case ie.end != nil:
+		e.End = ie.end.t
+	case ie.duration != nil:
+		e.End = e.Start.Add(*ie.duration)
+		if e.AllDay && *ie.duration%(24*time.Hour) == 0 {
+			e.End = midnight(civilDate(e.Start, loc).AddDate(0, 0, int(*ie.duration/(24*time.Hour))), loc)
+		}
+	case e.AllDay:
+		e.End = midnight(civilDate(e.Start, loc).AddDate(0, 0, 1), loc)
+	default:
+		e.End = e.Start
+	}
+	if ie.rrule != "" {
+		// правило уже проверено при разборе свойства
+		e.Rule, _ = parseRuleIn(ie.rrule, loc)
+	}
+	for _, ex := range ie.exceptions {
+		e.Exceptions = append(e.Exceptions, civilDate(ex, loc))
+	}
+	if e.RecurrenceID != nil {
+		day := civilDate(*e.RecurrenceID, loc)
+		e.RecurrenceID = &day
+	}
+}
+
+// readICal разбирает VCALENDAR и возвращает события из VEVENT.
+// У событий заполнены UID и, для изменённых повторений, RecurrenceID.
+// Даты и «плавающее» время считаются в зоне def.
+func readICal(r io.Reader, def *time.Location) ([]Event, error) {
+	lines, err := readICalLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		events  []Event
+		current *icalEvent
+	)
+	for i, line := range lines {
+		p, err := parseICalProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			if current != nil {
+				return nil, fmt.Errorf("line %d: nested VEVENT", i+1)
+			}
+			current = &icalEvent{}
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN", i+1)
+			}
+			if current.UID == "" {
+				return nil, fmt.Errorf("line %d: VEVENT without UID", i+1)
+			}
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: VEVENT without DTSTART", i+1)
+			}
+			current.finish()
+			events = append(events, current.Event)
+			current = nil
+		case current != nil && p.name == "BEGIN" && strings.EqualFold(p.value, "VALARM"):
+			current.inAlarm = true
+		case current != nil && p.name == "END" && strings.EqualFold(p.value, "VALARM"):
+			current.inAlarm = false
+		case current != nil:
+			if err := current.set(p, def); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("unterminated VEVENT")
+	}
+	return events, nil
+}
+
+func (ie *icalEvent) set(p icalProperty, def *time.Location) error {
+	e := &ie.Event
+	if ie.inAlarm {
+		return ie.setAlarm(p)
+	}
+	switch p.name {
+	case "UID":
+		e.UID = p.value
+	case "SUMMARY":
+		e.Title = icalUnescaper.Replace(p.value)
+	case "DESCRIPTION":
+		e.Description = icalUnescaper.Replace(p.value)
+	case "CATEGORIES":
+		for _, tag := range splitICalText(p.value) {
+			// в метках запятые недопустимы
+			e.Tags = append(e.Tags, strings.ReplaceAll(tag, ",", " "))
+		}
+	case "DTSTART":
+		v, err := parseICalTime(p.value, p.params, def)
+		if err != nil {
+			return fmt.Errorf("DTSTART: %w", err)
+		}
+		e.Start, e.AllDay, e.TZ = v.t, v.isDate, v.zone
+	case "DTEND":
+		v, err := parseICalTime(p.value, p.params, def)
+		if err != nil {
+			return fmt.Errorf("DTEND: %w", err)
+		}
+		ie.end = &v
+	case "DURATION":
+		d, err := parseICalDuration(p.value)
+		if err != nil {
+			return fmt.Errorf("DURATION: %w", err)
+		}
+		ie.duration = &d
+	case "RRULE":
+		if _, err := ParseRule(p.value); err != nil {
+			return fmt.Errorf("RRULE: %w", err)
+		}
+		ie.rrule = p.value
+	case "EXDATE":
+		for _, v := range strings.Split(p.value, ",") {
+			t, err := parseICalTime(v, p.params, def)
+			if err != nil {
+				return fmt.Errorf("EXDATE: %w", err)
+			}
+			ie.exceptions = append(ie.exceptions, t.t)
+		}
+	case "RECURRENCE-ID":
+		t, err := parseICalTime(p.value, p.params, def)
+		if err != nil {
+			return fmt.Errorf("RECURRENCE-ID: %w", err)
+		}
+		e.RecurrenceID = &t.t
+	}
+	return nil
+}
+
+// setAlarm разбирает свойство VALARM. Напоминанием события становится
+// только TRIGGER относительно начала, срабатывающий до него;
+// остальные напоминания пропускаются.
+func (ie *icalEvent) setAlarm(p icalProperty) error {
+	if p.name != "TRIGGER" || strings.EqualFold(p.params["VALUE"], "DATE-TIME") ||
+		strings.EqualFold(p.params["RELATED"], "END") {
+		return nil
+	}
+	d, err := parseICalDuration(p.value)
+	if err != nil {
+		return fmt.Errorf("TRIGGER: %w", err)
+	}
+	if d <= 0 && -d <= maxReminder {
+		ie.Reminders = append(ie.Reminders, -d)
+	}
+	return nil
+}
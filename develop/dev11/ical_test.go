@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICalRoundTrip(t *testing.T) {
+	msk, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip(err)
+	}
+	standup := time.Date(2026, 10, 19, 9, 0, 0, 0, msk)
+	recurrenceID := date("2026-10-20")
+	retro := time.Date(2026, 10, 20, 11, 0, 0, 0, msk)
+	events := []Event{
+		{ID: 1, Title: "Ежедневная встреча команды разработки календаря и всех смежных сервисов",
+			Description: "Agenda: status, blockers; notes\nsecond line",
+			Start:       standup, End: standup.Add(15 * time.Minute), TZ: "Europe/Moscow",
+			Rule:       &Rule{Freq: Daily, Interval: 1, Until: date("2026-10-30")},
+			Exceptions: []time.Time{date("2026-10-23")},
+			Reminders:  []time.Duration{15 * time.Minute, 24 * time.Hour},
+			Tags:       []string{"team", "daily"}},
+		{ID: 2, Title: "Retro", Start: retro, End: retro.Add(time.Hour), TZ: "Europe/Moscow",
+			SeriesID: 1, RecurrenceID: &recurrenceID},
+		{ID: 3, UID: "holiday@test", Title: "Holiday", Start: date("2026-11-04"), End: date("2026-11-05"), AllDay: true},
+		{ID: 4, UID: "call@test", Title: "Call", Start: time.Date(2026, 10, 21, 12, 30, 0, 0, time.UTC),
+			End: time.Date(2026, 10, 21, 13, 0, 0, 0, time.UTC), Rule: &Rule{Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Wednesday}, Count: 4}},
+	}
+	var buf bytes.Buffer
+	if err := writeICal(&buf, events, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > icalLineLimit {
+			t.Errorf("line longer than %d octets: %q", icalLineLimit, l)
+		}
+	}
+	got, err := readICal(&buf, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("read %d events, want %d", len(got), len(events))
+	}
+	for i, want := range events {
+		g := got[i]
+		want.UID = want.uid()
+		if want.SeriesID != 0 {
+			want.UID = events[0].uid()
+		}
+		if g.UID != want.UID || g.Title != want.Title || g.Description != want.Description ||
+			!g.Start.Equal(want.Start) || !g.End.Equal(want.End) || g.AllDay != want.AllDay || g.TZ != want.TZ {
+			t.Errorf("event %d read as %+v", want.ID, g)
+		}
+		if (g.Rule == nil) != (want.Rule == nil) || g.Rule != nil && g.Rule.String() != want.Rule.String() {
+			t.Errorf("event %d rule %v, want %v", want.ID, g.Rule, want.Rule)
+		}
+		if !reflect.DeepEqual(g.Exceptions, want.Exceptions) || !reflect.DeepEqual(g.Reminders, want.Reminders) ||
+			!reflect.DeepEqual(g.Tags, want.Tags) {
+			t.Errorf("event %d exceptions %v, reminders %v, tags %v", want.ID, g.Exceptions, g.Reminders, g.Tags)
+		}
+		if (g.RecurrenceID == nil) != (want.RecurrenceID == nil) || g.RecurrenceID != nil && !g.RecurrenceID.Equal(*want.RecurrenceID) {
+			t.Errorf("event %d RECURRENCE-ID %v, want %v", want.ID, g.RecurrenceID, want.RecurrenceID)
+		}
+	}
+}
+
+func TestReadICalErrors(t *testing.T) {
+	for _, ics := range []string{
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20261020T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:20261020T090000Z\r\nRRULE:FREQ=YEARLY\r\nEND:VEVENT\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:tomorrow\r\nEND:VEVENT\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:20261020T090000Z\r\n",
+	} {
+		if events, err := readICal(strings.NewReader(ics), time.UTC); err == nil {
+			t.Errorf("%q read as %+v", ics, events)
+		}
+	}
+}
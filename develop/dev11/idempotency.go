@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader — заголовок с ключом повторяемого запроса.
+const idempotencyHeader = "Idempotency-Key"
+
+// Ограничения хранилища ответов на запросы с Idempotency-Key.
+const (
+	idempotencyTTL     = 24 * time.Hour
+	maxIdempotencyKeys = 10000
+	maxIdempotencyKey  = 255
+	// ответ длиннее не запоминается, повтор выполнит запрос заново
+	maxIdempotentBody = 1 << 20
+)
+
+// Ошибки повторного использования ключа.
+var (
+	errKeyInFlight = &statusError{status: http.StatusConflict, msg: "a request with this Idempotency-Key is still in progress"}
+	errKeyReused   = &statusError{status: http.StatusUnprocessableEntity, msg: "Idempotency-Key was already used with a different request"}
+)
+
+// idempotentResponse — запомненный ответ на запрос с Idempotency-Key.
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	etag        string
+	body        []byte
+	expires     time.Time
+}
+
+// Idempotency запоминает ответы на POST запросы с заголовком
+// Idempotency-Key и отдаёт их при повторе того же запроса, не выполняя его
+// второй раз: клиент может безопасно повторить запрос после обрыва
+// соединения. Ключи хранятся в памяти отдельно для каждого пользователя
+// (без аутентификации — для адреса клиента) сутки.
+type Idempotency struct {
+	clock Clock
+
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	order     []string // ключи в порядке появления для вытеснения
+}
+
+// NewIdempotency создаёт пустое хранилище ответов.
+func NewIdempotency(clock Clock) *Idempotency {
+	return &Idempotency{clock: clock, responses: make(map[string]*idempotentResponse)}
+}
+
+// begin ищет ответ по ключу. Если его нет, ключ занимается за новым
+// запросом и begin возвращает nil.
+func (c *Idempotency) begin(key string, fingerprint [sha256.Size]byte) (*idempotentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if resp, ok := c.responses[key]; ok && now.Before(resp.expires) {
+		switch {
+		case resp.fingerprint != fingerprint:
+			return nil, errKeyReused
+		case !resp.done:
+			return nil, errKeyInFlight
+		}
+		return resp, nil
+	}
+	c.evictLocked(now)
+	c.responses[key] = &idempotentResponse{fingerprint: fingerprint, expires: now.Add(idempotencyTTL)}
+	c.order = append(c.order, key)
+	return nil, nil
+}
+
+// evictLocked удаляет устаревшие ключи и самые старые, пока хранилище
+// переполнено.
+func (c *Idempotency) evictLocked(now time.Time) {
+	n := 0
+	for n < len(c.order) {
+		key := c.order[n]
+		resp, ok := c.responses[key]
+		if ok && now.Before(resp.expires) && len(c.order)-n < maxIdempotencyKeys {
+			break
+		}
+		if ok {
+			delete(c.responses, key)
+		}
+		n++
+	}
+	c.order = append(c.order[:0], c.order[n:]...)
+}
+
+// finish запоминает ответ на запрос с ключом key. Без ответа (resp == nil)
+// ключ освобождается, и повтор выполнит запрос заново.
+func (c *Idempotency) finish(key string, resp *idempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur, ok := c.responses[key]
+	if !ok {
+		return
+	}
+	if resp == nil {
+		delete(c.responses, key)
+		return
+	}
+	resp.fingerprint, resp.expires, resp.done = cur.fingerprint, cur.expires, true
+	c.responses[key] = resp
+}
+
+// idempotencyRecorder пишет ответ клиенту и копит его для повторов.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxIdempotentBody {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController.
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// middleware обрабатывает POST запросы с Idempotency-Key. Повтор с тем же
+// ключом и тем же запросом получает первый ответ с заголовком
+// Idempotent-Replayed: true; тот же ключ с другим запросом — 422, пока
+// первый запрос выполняется — 409. Ответы 5xx и 429 не запоминаются:
+// такой запрос можно повторить с тем же ключом.
+func (c *Idempotency) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, &inputError{param: idempotencyHeader, msg: "must be at most " + strconv.Itoa(maxIdempotencyKey) + " characters"})
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			writeError(w, &statusError{status: http.StatusRequestEntityTooLarge, msg: "request body is too large"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := "ip:" + clientIP(r)
+		if p := principalFrom(r.Context()); p != nil {
+			scope = "user:" + strconv.FormatInt(p.UserID, 10)
+		}
+		h := sha256.New()
+		io.WriteString(h, r.URL.RequestURI()+"\n"+r.Header.Get("Content-Type")+"\n")
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		id := scope + " " + key
+		resp, err := c.begin(id, fingerprint)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if resp != nil {
+			if resp.contentType != "" {
+				w.Header().Set("Content-Type", resp.contentType)
+			}
+			if resp.etag != "" {
+				w.Header().Set("ETag", resp.etag)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(resp.status)
+			w.Write(resp.body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError ||
+				rec.status == http.StatusTooManyRequests || rec.overflow {
+				c.finish(id, nil)
+				return
+			}
+			c.finish(id, &idempotentResponse{
+				status:      rec.status,
+				contentType: w.Header().Get("Content-Type"),
+				etag:        w.Header().Get("ETag"),
+				body:        rec.body.Bytes(),
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// idempotentPost выполняет POST через h с ключом key с адреса ip.
+func idempotentPost(h http.Handler, ip, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/create_event", strings.NewReader(body))
+	r.RemoteAddr = ip + ":40000"
+	r.Header.Set("Content-Type", formType)
+	r.Header.Set(idempotencyHeader, key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)}
+	var calls atomic.Int32
+	// status — код ответа очередного вызова обработчика
+	status := http.StatusOK
+	h := NewIdempotency(clock).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("ETag", `"v`+strconv.Itoa(int(n))+`"`)
+		w.WriteHeader(status)
+		w.Write([]byte("call " + strconv.Itoa(int(n))))
+	}))
+
+	first := idempotentPost(h, "192.0.2.1", "k1", "title=Once")
+	again := idempotentPost(h, "192.0.2.1", "k1", "title=Once")
+	if calls.Load() != 1 || again.Body.String() != "call 1" || again.Header().Get("ETag") != `"v1"` ||
+		again.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("replay: %d calls, %q %v", calls.Load(), again.Body, again.Header())
+	}
+	if w := idempotentPost(h, "192.0.2.1", "k1", "title=Other"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key, other body: %d", w.Code)
+	}
+	// ключи разных клиентов не пересекаются
+	if w := idempotentPost(h, "192.0.2.2", "k1", "title=Once"); w.Body.String() != "call 2" {
+		t.Errorf("other client: %q", w.Body)
+	}
+	// ответ 5xx не запоминается
+	status = http.StatusInternalServerError
+	idempotentPost(h, "192.0.2.1", "k2", "title=Retry")
+	status = http.StatusOK
+	if w := idempotentPost(h, "192.0.2.1", "k2", "title=Retry"); w.Code != http.StatusOK || w.Body.String() != "call 4" {
+		t.Errorf("retry after 500: %d %q", w.Code, w.Body)
+	}
+	// через сутки ключ забывается
+	clock.set(clock.Now().Add(idempotencyTTL))
+	if w := idempotentPost(h, "192.0.2.1", "k1", "title=Once"); w.Body.String() != "call 5" {
+		t.Errorf("after TTL: %q", w.Body)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := NewIdempotency(systemClock{}).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentPost(h, "192.0.2.1", "slow", "title=Slow") }()
+	<-started
+	if w := idempotentPost(h, "192.0.2.1", "slow", "title=Slow"); w.Code != http.StatusConflict {
+		t.Errorf("concurrent retry: %d", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Body.String() != "done" {
+		t.Errorf("first request: %q", w.Body)
+	}
+	if w := idempotentPost(h, "192.0.2.1", "slow", "title=Slow"); w.Body.String() != "done" {
+		t.Errorf("retry after completion: %q", w.Body)
+	}
+}
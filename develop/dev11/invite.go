@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RSVP — ответ участника на приглашение.
+type RSVP string
+
+// Состояния приглашения. Новый участник получает RSVPPending.
+const (
+	RSVPPending   RSVP = "pending"
+	RSVPAccepted  RSVP = "accepted"
+	RSVPDeclined  RSVP = "declined"
+	RSVPTentative RSVP = "tentative"
+)
+
+// ParseRSVP разбирает состояние приглашения.
+func ParseRSVP(s string) (RSVP, error) {
+	switch r := RSVP(s); r {
+	case RSVPPending, RSVPAccepted, RSVPDeclined, RSVPTentative:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown invitation status %q", s)
+	}
+}
+
+// Attendee — приглашённый участник события и его ответ.
+type Attendee struct {
+	UserID int64
+	Status RSVP
+}
+
+// ErrNotInvited — пользователь не приглашён на событие.
+const ErrNotInvited = accessError("user is not invited to this event")
+
+// maxAttendees ограничивает число участников события.
+const maxAttendees = 500
+
+// attendee возвращает приглашение пользователя userID.
+func (e Event) attendee(userID int64) (*Attendee, bool) {
+	for i := range e.Attendees {
+		if e.Attendees[i].UserID == userID {
+			return &e.Attendees[i], true
+		}
+	}
+	return nil, false
+}
+
+// attends сообщает, видит ли userID событие в своём календаре: организатор
+// видит всегда, участник — если не отказался.
+func (e Event) attends(userID int64) bool {
+	if e.UserID == userID {
+		return true
+	}
+	a, ok := e.attendee(userID)
+	return ok && a.Status != RSVPDeclined
+}
+
+// setAttendees заменяет список участников на userIDs, сохраняя ответы
+// тех, кто уже был приглашён.
+func (e *Event) setAttendees(userIDs []int64) {
+	old := e.Attendees
+	e.Attendees = nil
+	seen := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		a := Attendee{UserID: id, Status: RSVPPending}
+		for _, o := range old {
+			if o.UserID == id {
+				a = o
+			}
+		}
+		e.Attendees = append(e.Attendees, a)
+	}
+	sort.Slice(e.Attendees, func(i, j int) bool {
+		return e.Attendees[i].UserID < e.Attendees[j].UserID
+	})
+}
+
+// validateAttendees проверяет список участников события.
+func (e Event) validateAttendees() error {
+	if len(e.Attendees) > maxAttendees {
+		return fmt.Errorf("%w: at most %d attendees", ErrInvalidEvent, maxAttendees)
+	}
+	for _, a := range e.Attendees {
+		if a.UserID <= 0 {
+			return fmt.Errorf("%w: attendee must be a positive user ID", ErrInvalidEvent)
+		}
+		if a.UserID == e.UserID {
+			return fmt.Errorf("%w: organizer cannot be an attendee", ErrInvalidEvent)
+		}
+		if _, err := ParseRSVP(string(a.Status)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+		}
+	}
+	return nil
+}
+
+// RespondEvent записывает ответ участника userID на приглашение на
+// событие id. Ответ относится ко всей серии.
+func (c *Calendar) RespondEvent(userID, id int64, status RSVP) (Event, error) {
+	var e Event
+	err := retryStale(0, func() error {
+		var err error
+		if e, err = c.store.Get(id); err != nil {
+			return err
+		}
+		a, ok := e.attendee(userID)
+		if !ok {
+			return ErrNotInvited
+		}
+		a.Status = status
+		return c.update(&e)
+	})
+	if err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// invitedBetween возвращает повторения событий других пользователей,
+// на которые приглашён userID и от которых он не отказался,
+// пересекающиеся с [from, to).
+func (c *Calendar) invitedBetween(userID int64, from, to time.Time) ([]Event, error) {
+	ids, err := c.index.participating(userID, c.store.All)
+	if err != nil {
+		return nil, err
+	}
+	var res []Event
+	for _, id := range ids {
+		e, err := c.store.Get(id)
+		if err == ErrEventNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.UserID != userID && e.attends(userID) {
+			res = append(res, e.expand(from, to)...)
+		}
+	}
+	return res, nil
+}
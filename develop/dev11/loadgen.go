@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultLoadMix — смесь запросов нагрузки по умолчанию: метод и его вес.
+const defaultLoadMix = "create_event=2,events_for_day=4,events_for_week=2,events_for_month=1,search=1"
+
+// loadOps — методы, которые умеет вызывать генератор нагрузки.
+var loadOps = []string{"create_event", "events_for_day", "events_for_week", "events_for_month", "search"}
+
+// loadWeight — метод API и его доля в смеси запросов.
+type loadWeight struct {
+	op     string
+	weight int
+}
+
+// parseLoadMix разбирает смесь запросов вида create_event=2,search=1.
+func parseLoadMix(spec string) ([]loadWeight, error) {
+	var mix []loadWeight
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op, w, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(w)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("mix: %q is not op=weight", part)
+		}
+		known := false
+		for _, o := range loadOps {
+			known = known || o == op
+		}
+		if !known {
+			return nil, fmt.Errorf("mix: unknown method %q, use %s", op, strings.Join(loadOps, ", "))
+		}
+		if n > 0 {
+			mix = append(mix, loadWeight{op: op, weight: n})
+		}
+	}
+	if len(mix) == 0 {
+		return nil, errors.New("mix: no methods with positive weight")
+	}
+	return mix, nil
+}
+
+// loadGenerator отправляет запросы одного клиента нагрузки.
+type loadGenerator struct {
+	base   *url.URL
+	client *http.Client
+	// auth добавляет в запрос учётные данные, nil без аутентификации.
+	auth   func(*http.Request)
+	users  int
+	mix    []loadWeight
+	total  int // сумма весов mix
+	origin time.Time
+}
+
+// pick выбирает метод по весам смеси.
+func (g *loadGenerator) pick(rnd *rand.Rand) string {
+	n := rnd.Intn(g.total)
+	for _, w := range g.mix {
+		if n < w.weight {
+			return w.op
+		}
+		n -= w.weight
+	}
+	return g.mix[len(g.mix)-1].op
+}
+
+// request строит случайный запрос метода op: события создаются и ищутся
+// в пределах двух месяцев от origin.
+func (g *loadGenerator) request(ctx context.Context, rnd *rand.Rand, op string) (*http.Request, error) {
+	params := url.Values{}
+	if g.auth == nil {
+		// с учётными данными user_id — аутентифицированный пользователь
+		params.Set("user_id", strconv.Itoa(1+rnd.Intn(g.users)))
+	}
+	day := g.origin.AddDate(0, 0, rnd.Intn(60))
+	u := *g.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + op
+	var (
+		req *http.Request
+		err error
+	)
+	switch op {
+	case "create_event":
+		start := day.Add(time.Duration(8+rnd.Intn(10)) * time.Hour)
+		params.Set("title", "load "+strconv.Itoa(rnd.Intn(1000)))
+		params.Set("start", start.Format(time.RFC3339))
+		params.Set("duration", []string{"30m", "1h", "1h30m"}[rnd.Intn(3)])
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", formType)
+		}
+	case "search":
+		params.Set("q", "load")
+		params.Set("from", day.Format(dateLayout))
+		params.Set("to", day.AddDate(0, 0, 7).Format(dateLayout))
+		params.Set("limit", "20")
+		u.RawQuery = params.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	default:
+		params.Set("date", day.Format(dateLayout))
+		u.RawQuery = params.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g.auth != nil {
+		g.auth(req)
+	}
+	return req, nil
+}
+
+// loadStats — результаты запросов одного метода.
+type loadStats struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	// failed — запросы без ответа: ошибки соединения и таймауты.
+	failed int
+}
+
+func (s *loadStats) merge(o *loadStats) {
+	s.latencies = append(s.latencies, o.latencies...)
+	for code, n := range o.statuses {
+		s.statuses[code] += n
+	}
+	s.failed += o.failed
+}
+
+// errorCount возвращает число запросов без ответа или с кодом не 2xx.
+func (s *loadStats) errorCount() int {
+	n := s.failed
+	for code, c := range s.statuses {
+		if code < 200 || code > 299 {
+			n += c
+		}
+	}
+	return n
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{statuses: make(map[int]int)}
+}
+
+// percentile возвращает перцентиль p (0–1) отсортированных задержек.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// run отправляет запросы до отмены ctx и собирает статистику по методам.
+func (g *loadGenerator) run(ctx context.Context, rnd *rand.Rand) map[string]*loadStats {
+	stats := make(map[string]*loadStats)
+	for ctx.Err() == nil {
+		op := g.pick(rnd)
+		st, ok := stats[op]
+		if !ok {
+			st = newLoadStats()
+			stats[op] = st
+		}
+		req, err := g.request(ctx, rnd, op)
+		if err != nil {
+			st.failed++
+			continue
+		}
+		start := time.Now()
+		resp, err := g.client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				st.failed++
+			}
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		st.latencies = append(st.latencies, time.Since(start))
+		st.statuses[resp.StatusCode]++
+	}
+	return stats
+}
+
+// loadCommand — подкоманда load: нагружает запущенный сервер смесью
+// запросов и выводит в out пропускную способность, задержки по методам
+// и число ответов каждого кода. Прерывание по Ctrl-C выводит то, что
+// успели собрать.
+func loadCommand(args []string, out io.Writer, getenv func(string) string) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	target := fs.String("url", "http://localhost:8080", "адрес сервера")
+	duration := fs.Duration("duration", 10*time.Second, "длительность нагрузки")
+	workers := fs.Int("c", 8, "число одновременных клиентов")
+	users := fs.Int("users", 100, "число пользователей без аутентификации")
+	mixSpec := fs.String("mix", defaultLoadMix, "методы и их веса: "+strings.Join(loadOps, ", "))
+	key := fs.String("key", getenv(envPrefix+"LOAD_KEY"), "API ключ, запросы идут от его пользователя")
+	token := fs.String("token", getenv(envPrefix+"LOAD_TOKEN"), "подписанный токен вместо API ключа")
+	timeout := fs.Duration("timeout", 10*time.Second, "таймаут одного запроса")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "начальное значение генератора случайных чисел")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *duration <= 0 || *workers <= 0 || *users <= 0 || *timeout <= 0 {
+		return errors.New("load: -duration, -c, -users and -timeout must be positive")
+	}
+	base, err := url.Parse(*target)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return fmt.Errorf("load: invalid -url %q", *target)
+	}
+	mix, err := parseLoadMix(*mixSpec)
+	if err != nil {
+		return err
+	}
+	g := &loadGenerator{
+		base: base,
+		client: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *workers},
+		},
+		users:  *users,
+		mix:    mix,
+		origin: midnight(time.Now(), time.UTC),
+	}
+	for _, w := range mix {
+		g.total += w.weight
+	}
+	switch {
+	case *token != "":
+		g.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+*token) }
+	case *key != "":
+		g.auth = func(r *http.Request) { r.Header.Set("X-API-Key", *key) }
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	results := make([]map[string]*loadStats, *workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = g.run(ctx, rand.New(rand.NewSource(*seed+int64(i))))
+		}(i)
+	}
+	wg.Wait()
+	writeLoadReport(out, results, time.Since(start))
+	return nil
+}
+
+// writeLoadReport выводит сводку нагрузки за время elapsed.
+func writeLoadReport(out io.Writer, results []map[string]*loadStats, elapsed time.Duration) {
+	byOp := make(map[string]*loadStats)
+	total := newLoadStats()
+	for _, stats := range results {
+		for op, st := range stats {
+			if byOp[op] == nil {
+				byOp[op] = newLoadStats()
+			}
+			byOp[op].merge(st)
+			total.merge(st)
+		}
+	}
+	ops := make([]string, 0, len(byOp))
+	for op := range byOp {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	secs := elapsed.Seconds()
+	requests := len(total.latencies) + total.failed
+	fmt.Fprintf(out, "%d requests in %s, %.1f req/s, %d errors\n\n",
+		requests, elapsed.Round(time.Millisecond), float64(requests)/secs, total.errorCount())
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "method\trequests\treq/s\terrors\tp50\tp90\tp99\tmax\t")
+	row := func(name string, st *loadStats) {
+		sort.Slice(st.latencies, func(i, j int) bool { return st.latencies[i] < st.latencies[j] })
+		n := len(st.latencies) + st.failed
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%s\t%s\t%s\t%s\t\n", name, n, float64(n)/secs, st.errorCount(),
+			roundLatency(percentile(st.latencies, 0.5)), roundLatency(percentile(st.latencies, 0.9)),
+			roundLatency(percentile(st.latencies, 0.99)), roundLatency(percentile(st.latencies, 1)))
+	}
+	for _, op := range ops {
+		row(op, byOp[op])
+	}
+	row("total", total)
+	tw.Flush()
+
+	codes := make([]int, 0, len(total.statuses))
+	for code := range total.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprint(out, "\nstatus codes:")
+	for _, code := range codes {
+		fmt.Fprintf(out, " %d=%d", code, total.statuses[code])
+	}
+	if total.failed > 0 {
+		fmt.Fprintf(out, " failed=%d", total.failed)
+	}
+	fmt.Fprintln(out)
+}
+
+// roundLatency округляет задержку для вывода.
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
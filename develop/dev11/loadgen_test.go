@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLoadMix(t *testing.T) {
+	mix, err := parseLoadMix(" create_event=2, search=0,,events_for_day=1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mix) != 2 || mix[0] != (loadWeight{"create_event", 2}) || mix[1] != (loadWeight{"events_for_day", 1}) {
+		t.Errorf("mix %v, want create_event=2, events_for_day=1 without zero weights", mix)
+	}
+	for _, spec := range []string{
+		"create_event",
+		"create_event=x",
+		"create_event=-1",
+		"delete_event=1",
+		"create_event=0,search=0",
+		"",
+	} {
+		if _, err := parseLoadMix(spec); err == nil {
+			t.Errorf("parseLoadMix(%q) accepted", spec)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	ms := func(ns ...int) []time.Duration {
+		var res []time.Duration
+		for _, n := range ns {
+			res = append(res, time.Duration(n)*time.Millisecond)
+		}
+		return res
+	}
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{nil, 0.5, 0},
+		{ms(7), 0.99, 7 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0, 1 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0.5, 2 * time.Millisecond},
+		// ранг округляется вверх: 0.51 * 4 = 2.04 — третий элемент
+		{ms(1, 2, 3, 4), 0.51, 3 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0.9, 4 * time.Millisecond},
+		{ms(1, 2, 3, 4), 1, 4 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %s, want %s", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestLoadGeneratorPick(t *testing.T) {
+	g := &loadGenerator{mix: []loadWeight{{"create_event", 1}, {"events_for_day", 3}}, total: 4}
+	rnd := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[g.pick(rnd)]++
+	}
+	if len(counts) != 2 || counts["create_event"] < 800 || counts["create_event"] > 1200 {
+		t.Errorf("picked %v, want about 1000 create_event and 3000 events_for_day", counts)
+	}
+}
+
+func TestWriteLoadReport(t *testing.T) {
+	stats := func(statuses map[int]int, failed int, latencies ...time.Duration) *loadStats {
+		return &loadStats{latencies: latencies, statuses: statuses, failed: failed}
+	}
+	results := []map[string]*loadStats{
+		{"search": stats(map[int]int{200: 2}, 0, 2*time.Millisecond, 4*time.Millisecond)},
+		{
+			"search":       stats(map[int]int{503: 1}, 1, 6*time.Millisecond),
+			"create_event": stats(map[int]int{200: 1}, 0, 1500*time.Microsecond),
+		},
+	}
+	var out strings.Builder
+	writeLoadReport(&out, results, 2*time.Second)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"5 requests in 2s, 2.5 req/s, 2 errors",
+		"",
+		"        method  requests  req/s  errors    p50    p90    p99    max",
+		"  create_event         1    0.5       0  1.5ms  1.5ms  1.5ms  1.5ms",
+		"        search         4    2.0       2    4ms    6ms    6ms    6ms",
+		"         total         5    2.5       2    2ms    6ms    6ms    6ms",
+		"",
+		"status codes: 200=3 503=1 failed=1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("report\n%s\nwant\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadCommand(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	var out strings.Builder
+	args := []string{"-url", env.url, "-duration", "200ms", "-c", "2", "-users", "3", "-seed", "1",
+		"-mix", "create_event=1,events_for_day=1,search=1"}
+	if err := loadCommand(args, &out, func(string) string { return "" }); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, re := range []string{
+		`^[1-9]\d* requests in \d+ms, [\d.]+ req/s, 0 errors\n`,
+		`\n\s*method\s+requests\s+req/s\s+errors\s+p50\s+p90\s+p99\s+max\n`,
+		`\n\s*create_event\s+[1-9]\d*\s`,
+		`\n\s*events_for_day\s+[1-9]\d*\s`,
+		`\n\s*search\s+[1-9]\d*\s`,
+		`\n\s*total\s+[1-9]\d*\s`,
+		`\nstatus codes: 200=[1-9]\d*\n$`,
+	} {
+		if !regexp.MustCompile(re).MatchString(report) {
+			t.Errorf("report does not match %s:\n%s", re, report)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-url", "ftp://localhost"},
+		{"-duration", "0s"},
+		{"-mix", "delete_event=1"},
+	} {
+		if err := loadCommand(args, &out, func(string) string { return "" }); err == nil {
+			t.Errorf("loadCommand(%v) accepted", args)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry — набор метрик, который отдаётся в текстовом формате Prometheus.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric — семейство метрик с общим именем.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry создаёт пустой набор метрик.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP отдаёт все метрики в формате Prometheus text 0.0.4.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// vec — значения семейства метрик по наборам меток.
+type vec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{name: name, help: help, kind: kind, labels: labels, values: make(map[string][]string)}
+}
+
+// key возвращает ключ набора значений меток и запоминает его.
+// Вызывается под mu.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: want %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := v.values[k]; !ok {
+		v.values[k] = append([]string(nil), values...)
+	}
+	return k
+}
+
+// sortedKeys возвращает ключи в стабильном порядке. Вызывается под mu.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+// labelString форматирует метки {a="x",b="y"} с дополнительной парой extra.
+func (v *vec) labelString(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	pair := func(name, value string) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(value))
+		b.WriteByte('"')
+	}
+	for i, name := range v.labels {
+		pair(name, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pair(extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec — монотонно растущие счётчики с метками.
+type CounterVec struct {
+	vec
+	counts map[string]float64
+}
+
+// NewCounter регистрирует счётчик name с метками labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels), counts: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc увеличивает счётчик с данными значениями меток на единицу.
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(values)]++
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.values[k]), formatFloat(c.counts[k]))
+	}
+}
+
+// GaugeVec — произвольно меняющиеся значения с метками.
+type GaugeVec struct {
+	vec
+	gauges map[string]float64
+}
+
+// NewGauge регистрирует датчик name с метками labels.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labels), gauges: make(map[string]float64)}
+	r.register(g)
+	return g
+}
+
+// Set устанавливает значение датчика.
+func (g *GaugeVec) Set(f float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(values)] = f
+}
+
+// Add изменяет значение датчика на delta.
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(values)] += delta
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, k := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(g.values[k]), formatFloat(g.gauges[k]))
+	}
+}
+
+// HistogramVec — распределения наблюдений по корзинам с метками.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // по корзинам, не накопительно
+	count  uint64
+	sum    float64
+}
+
+// defaultBuckets — границы корзин времени обработки запроса в секундах.
+var defaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram регистрирует гистограмму name с верхними границами
+// корзин buckets (по возрастанию) и метками labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets, series: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe добавляет наблюдение f.
+func (h *HistogramVec) Observe(f float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(values)
+	s := h.series[k]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += f
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		values, s := h.values[k], h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), s.count)
+	}
+}
+
+// httpMetrics — метрики обработки HTTP запросов.
+type httpMetrics struct {
+	requests *CounterVec
+	errors   *CounterVec
+	duration *HistogramVec
+	inFlight *GaugeVec
+}
+
+func newHTTPMetrics(reg *Registry) *httpMetrics {
+	return &httpMetrics{
+		requests: reg.NewCounter("http_requests_total", "Processed HTTP requests.", "route", "method", "code"),
+		errors: reg.NewCounter("http_request_errors_total",
+			"Failed HTTP requests by class: input (400), business (503), internal (500), other.", "route", "class"),
+		duration: reg.NewHistogram("http_request_duration_seconds", "HTTP request latency.", defaultBuckets, "route"),
+		inFlight: reg.NewGauge("http_requests_in_flight", "HTTP requests being processed."),
+	}
+}
+
+// errorClass относит код ответа к классу ошибок из задания.
+func errorClass(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return "input"
+	case status == http.StatusServiceUnavailable:
+		return "business"
+	case status >= http.StatusInternalServerError:
+		return "internal"
+	default:
+		return "other"
+	}
+}
+
+// methodLabel возвращает метод запроса для метки. Нестандартные методы
+// сводятся к other, чтобы клиент не мог создавать новые серии метрик.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+		"PROPFIND", "REPORT":
+		return method
+	}
+	return "other"
+}
+
+// instrument считает запросы, ошибки и время обработки по маршрутам —
+// шаблонам routes, под которые попадает запрос.
+func (m *httpMetrics) instrument(routes *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m.inFlight.Add(1)
+			defer m.inFlight.Add(-1)
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			_, route := routes.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			m.requests.Inc(route, methodLabel(r.Method), strconv.Itoa(rec.status))
+			if rec.status >= http.StatusBadRequest {
+				m.errors.Inc(route, errorClass(rec.status))
+			}
+			m.duration.Observe(time.Since(start).Seconds(), route)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// observedHandler возвращает обработчик сервера над store с метриками reg
+// и JSON логом запросов в log.
+func observedHandler(t *testing.T, store EventStore) (h http.Handler, reg *Registry, log *bytes.Buffer) {
+	t.Helper()
+	server := NewServer(NewCalendar(store), AllowConflicts)
+	t.Cleanup(server.CloseStreams)
+	reg = NewRegistry()
+	log = new(bytes.Buffer)
+	logger := slog.New(slog.NewJSONHandler(log, nil))
+	return newHandler(server, reg, NewRateLimiter(systemClock{}, NewRegistry()), logger), reg, log
+}
+
+// scrape возвращает метрики reg в текстовом формате.
+func scrape(reg *Registry) string {
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetricsMethodLabel(t *testing.T) {
+	h, reg, _ := observedHandler(t, NewMemoryStore())
+	for _, method := range []string{"BREW", "X-RANDOM-1", "X-RANDOM-2", "PROPFIND", http.MethodDelete} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/events_for_day?user_id=1&date=2026-10-19", nil))
+	}
+	metrics := scrape(reg)
+	if strings.Contains(metrics, "BREW") || strings.Contains(metrics, "X-RANDOM") {
+		t.Errorf("arbitrary methods in labels:\n%s", metrics)
+	}
+	for _, want := range []string{`method="other",code="405"} 3`, `method="PROPFIND"`, `method="DELETE"`} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("no %s in\n%s", want, metrics)
+		}
+	}
+}
+
+// request выполняет запрос к h: params GET метода уходят в query string,
+// остальных — в тело формы; id — заголовок X-Request-ID, если задан.
+func request(h http.Handler, method, path string, params url.Values, id string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if method == http.MethodGet {
+		r = httptest.NewRequest(method, path+"?"+params.Encode(), nil)
+	} else {
+		r = httptest.NewRequest(method, path, strings.NewReader(params.Encode()))
+		r.Header.Set("Content-Type", formType)
+	}
+	if id != "" {
+		r.Header.Set(requestIDHeader, id)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// listFailingStore не может прочитать события пользователя.
+type listFailingStore struct {
+	*MemoryStore
+}
+
+func (listFailingStore) List(int64) ([]Event, error) { return nil, errDiskFailure }
+
+func TestErrorClass(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:          "input",
+		http.StatusServiceUnavailable:  "business",
+		http.StatusInternalServerError: "internal",
+		http.StatusBadGateway:          "internal",
+		http.StatusNotFound:            "other",
+		http.StatusPreconditionFailed:  "other",
+	} {
+		if got := errorClass(status); got != want {
+			t.Errorf("errorClass(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogram("latency_seconds", "Latency.", []float64{.1, 1}, "route")
+	for _, f := range []float64{.05, .1, .5, 1, 3} {
+		h.Observe(f, "/a")
+	}
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 4
+latency_seconds_bucket{route="/a",le="+Inf"} 5
+latency_seconds_sum{route="/a"} 4.65
+latency_seconds_count{route="/a"} 5
+`
+	if got := scrape(reg); got != want {
+		t.Errorf("scraped\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestRequestMetrics проверяет счётчики запросов и ошибок по маршрутам
+// и гистограмму времени обработки.
+func TestRequestMetrics(t *testing.T) {
+	h, reg, _ := observedHandler(t, listFailingStore{NewMemoryStore()})
+	requests := []struct {
+		method, path string
+		params       url.Values
+		want         int
+	}{
+		{http.MethodPost, "/create_event", form("user_id", "1", "title", "x", "date", "2026-10-19"), http.StatusOK},
+		{http.MethodPost, "/create_event", form("user_id", "1", "date", "2026-10-19"), http.StatusBadRequest},
+		{http.MethodGet, "/events_for_day", form("user_id", "1", "date", "19.10.2026"), http.StatusBadRequest},
+		{http.MethodGet, "/events_for_day", form("user_id", "1", "date", "2026-10-19"), http.StatusInternalServerError},
+		{http.MethodPost, "/delete_event", form("user_id", "1", "id", "42"), http.StatusServiceUnavailable},
+		{http.MethodGet, "/nope", nil, http.StatusNotFound},
+	}
+	for _, rq := range requests {
+		if w := request(h, rq.method, rq.path, rq.params, ""); w.Code != rq.want {
+			t.Fatalf("%s %s: status %d, want %d: %s", rq.method, rq.path, w.Code, rq.want, w.Body)
+		}
+	}
+	metrics := scrape(reg)
+	for _, want := range []string{
+		`http_requests_total{route="/create_event",method="POST",code="200"} 1`,
+		`http_requests_total{route="/create_event",method="POST",code="400"} 1`,
+		`http_requests_total{route="/events_for_day",method="GET",code="400"} 1`,
+		`http_requests_total{route="/events_for_day",method="GET",code="500"} 1`,
+		`http_requests_total{route="/delete_event",method="POST",code="503"} 1`,
+		`http_request_errors_total{route="/create_event",class="input"} 1`,
+		`http_request_errors_total{route="/events_for_day",class="input"} 1`,
+		`http_request_errors_total{route="/events_for_day",class="internal"} 1`,
+		`http_request_errors_total{route="/delete_event",class="business"} 1`,
+		`http_request_duration_seconds_bucket{route="/create_event",le="+Inf"} 2`,
+		`http_request_duration_seconds_bucket{route="/create_event",le="10"} 2`,
+		`http_request_duration_seconds_count{route="/events_for_day"} 2`,
+		`http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("no %s in\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `route="/create_event",class="business"`) {
+		t.Errorf("successful request counted as an error:\n%s", metrics)
+	}
+	// ошибки каждого маршрута — только своих классов
+	if n := strings.Count(metrics, "http_request_errors_total{"); n != 5 {
+		t.Errorf("%d error series, want 5:\n%s", n, metrics)
+	}
+}
+
+// TestRequestLog проверяет поля JSON лога запросов и уровень по коду ответа.
+func TestRequestLog(t *testing.T) {
+	h, _, log := observedHandler(t, listFailingStore{NewMemoryStore()})
+	request(h, http.MethodPost, "/create_event", form("user_id", "7", "title", "x", "date", "2026-10-19"), "req-1")
+	request(h, http.MethodGet, "/events_for_day", form("user_id", "7", "date", "bad"), "req-2")
+	request(h, http.MethodGet, "/events_for_day", form("user_id", "7", "date", "2026-10-19"), "")
+	request(h, http.MethodPost, "/delete_event", form("user_id", "7", "id", "42"), "req-4")
+
+	var lines []map[string]interface{}
+	sc := bufio.NewScanner(log)
+	for sc.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q: %v", sc.Text(), err)
+		}
+		if line["msg"] == "request" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 4 {
+		t.Fatalf("%d request log lines, want 4:\n%s", len(lines), log)
+	}
+	want := []struct {
+		level, method, path, id string
+		status                  float64
+	}{
+		{"INFO", "POST", "/create_event", "req-1", 200},
+		{"WARN", "GET", "/events_for_day", "req-2", 400},
+		{"ERROR", "GET", "/events_for_day", "", 500},
+		{"WARN", "POST", "/delete_event", "req-4", 503},
+	}
+	for i, w := range want {
+		line := lines[i]
+		if line["level"] != w.level || line["method"] != w.method || line["path"] != w.path || line["status"] != w.status {
+			t.Errorf("line %d = %v, want %s %s %s %v", i+1, line, w.level, w.method, w.path, w.status)
+		}
+		if line["user_id"] != float64(7) {
+			t.Errorf("line %d user_id = %v, want 7", i+1, line["user_id"])
+		}
+		if latency, ok := line["latency_ms"].(float64); !ok || latency < 0 {
+			t.Errorf("line %d latency_ms = %v", i+1, line["latency_ms"])
+		}
+		if id, _ := line["request_id"].(string); w.id != "" && id != w.id || id == "" {
+			t.Errorf("line %d request_id = %q, want %q", i+1, id, w.id)
+		}
+	}
+}
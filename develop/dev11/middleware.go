@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// middleware — обёртка над обработчиком.
+type middleware func(http.Handler) http.Handler
+
+// chain оборачивает h в middlewares; первая из них обрабатывает запрос первой.
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// statusRecorder запоминает код ответа обработчика.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestIDHeader — заголовок с идентификатором запроса.
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo — сведения о запросе, которые middleware передают дальше
+// по цепочке. Хранится по указателю, чтобы внутренние middleware могли
+// дополнить его для внешних, например указать пользователя для лога.
+type requestInfo struct {
+	id        string
+	principal *Principal
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom возвращает сведения о запросе из контекста.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID присваивает запросу идентификатор: из заголовка X-Request-ID
+// клиента или случайный. Идентификатор возвращается в том же заголовке.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})))
+	})
+}
+
+// requestIDFrom возвращает идентификатор запроса из контекста.
+func requestIDFrom(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// logRequests выводит в лог logger каждый обработанный запрос в виде JSON:
+// метод, путь, код ответа, время обработки, пользователя, аутентифицированного
+// пользователя и идентификатор запроса.
+func logRequests(logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			level := slog.LevelInfo
+			switch {
+			case rec.status >= http.StatusInternalServerError && rec.status != http.StatusServiceUnavailable:
+				level = slog.LevelError
+			case rec.status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("request_id", requestIDFrom(r.Context())),
+			}
+			if userID, err := strconv.ParseInt(requestUserID(r), 10, 64); err == nil {
+				attrs = append(attrs, slog.Int64("user_id", userID))
+			}
+			if p := principalFrom(r.Context()); p != nil {
+				attrs = append(attrs, slog.Int64("principal", p.UserID), slog.String("auth", p.Method))
+			}
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// requestUserID возвращает параметр user_id запроса. Тело запроса здесь
+// не читается: параметры формы видны, только если их уже разобрал обработчик.
+func requestUserID(r *http.Request) string {
+	if r.Form != nil {
+		return r.Form.Get("user_id")
+	}
+	return r.URL.Query().Get("user_id")
+}
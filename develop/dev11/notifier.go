@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Notifier доставляет напоминание пользователю.
+type Notifier interface {
+	Notify(r Reminder) error
+}
+
+// reminderJSON — представление напоминания для внешних получателей.
+type reminderJSON struct {
+	EventID int64  `json:"event_id"`
+	UserID  int64  `json:"user_id"`
+	Title   string `json:"title"`
+	Start   string `json:"start"`
+	Before  string `json:"before"`
+	At      string `json:"at"`
+}
+
+func newReminderJSON(r Reminder) reminderJSON {
+	loc, err := LoadZone(r.TZ)
+	if err != nil {
+		loc = time.UTC
+	}
+	return reminderJSON{
+		EventID: r.EventID,
+		UserID:  r.UserID,
+		Title:   r.Title,
+		Start:   r.Start.In(loc).Format(time.RFC3339),
+		Before:  formatDuration(r.Before),
+		At:      r.At.In(loc).Format(time.RFC3339),
+	}
+}
+
+// formatDuration записывает длительность без нулевых хвостов: 15m, 1h, 1h30m.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// LogNotifier выводит напоминания в лог сервера.
+type LogNotifier struct{}
+
+// Notify реализует Notifier.
+func (LogNotifier) Notify(r Reminder) error {
+	v := newReminderJSON(r)
+	log.Printf("reminder: user %d event %d %q starts at %s", v.UserID, v.EventID, v.Title, v.Start)
+	return nil
+}
+
+// WebhookNotifier отправляет напоминания POST запросом с JSON телом на URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// webhookTimeout ограничивает время доставки одного напоминания.
+const webhookTimeout = 10 * time.Second
+
+// NewWebhookNotifier создаёт доставку напоминаний на url.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Notify реализует Notifier.
+func (n *WebhookNotifier) Notify(r Reminder) error {
+	body, err := json.Marshal(newReminderJSON(r))
+	if err != nil {
+		return err
+	}
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// MailNotifier — замена SMTP для локального запуска: письма в формате
+// RFC 5322 складываются файлами в каталог Dir вместо отправки.
+// Адрес получателя строится как user<ID>@Domain.
+type MailNotifier struct {
+	Dir    string
+	From   string
+	Domain string
+}
+
+// NewMailNotifier создаёт почтовую доставку в каталог dir.
+func NewMailNotifier(dir string) (*MailNotifier, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &MailNotifier{Dir: dir, From: "calendar@localhost", Domain: "localhost"}, nil
+}
+
+// Notify реализует Notifier. Имя файла определяется напоминанием и
+// получателем, поэтому повторная доставка перезаписывает письмо, а не
+// дублирует его, а письма участникам одного события не затирают друг друга.
+func (n *MailNotifier) Notify(r Reminder) error {
+	v := newReminderJSON(r)
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: user%d@%s\r\n", r.UserID, n.Domain)
+	fmt.Fprintf(&b, "Subject: Reminder: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(r.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", r.At.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s starts at %s (in %s).\r\n", r.Title, v.Start, v.Before)
+	name := fmt.Sprintf("%d-%d-%d-%d.eml", r.At.Unix(), r.EventID, int64(r.Before/time.Second), r.UserID)
+	tmp := filepath.Join(n.Dir, name+".tmp")
+	if err := writeFileSync(tmp, []byte(b.String())); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(n.Dir, name))
+}
+
+// parseNotifiers разбирает список способов доставки через запятую:
+// log, webhook=URL, mail=DIR.
+func parseNotifiers(spec string) ([]Notifier, error) {
+	var res []Notifier
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, arg := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			kind, arg = part[:i], part[i+1:]
+		}
+		switch {
+		case kind == "log" && arg == "":
+			res = append(res, LogNotifier{})
+		case kind == "webhook" && arg != "":
+			res = append(res, NewWebhookNotifier(arg))
+		case kind == "mail" && arg != "":
+			n, err := NewMailNotifier(arg)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, n)
+		default:
+			return nil, fmt.Errorf("unknown notifier %q", part)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailNotifierAttendees(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewMailNotifier(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	r := Reminder{EventID: 1, Title: "Planning", Start: start, Before: 15 * time.Minute, At: start.Add(-15 * time.Minute)}
+	for _, userID := range []int64{1, 2, 2} {
+		r.UserID = userID
+		if err := n.Notify(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// повторная доставка участнику 2 перезаписывает его письмо
+	if len(files) != 2 {
+		t.Fatalf("mail files %v, want one per attendee", files)
+	}
+	sort.Strings(files)
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "To: user" + string(rune('1'+i)) + "@localhost\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("%s:\n%s\nwant %q", filepath.Base(file), data, want)
+		}
+	}
+}
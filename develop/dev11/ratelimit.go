@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRoute — ключ лимита для маршрутов без собственного.
+const defaultRoute = "*"
+
+// rateLimit — параметры ведра: пополнение в токенах в секунду и ёмкость.
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// parseRateLimits разбирает лимиты по маршрутам через запятую:
+// маршрут=N/s или N/m, после двоеточия ёмкость ведра (по умолчанию N).
+// Маршрут * задаёт лимит остальных маршрутов, например
+// "*=20/s:40,/events_for_month=30/m:5".
+func parseRateLimits(spec string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		route, v, ok := strings.Cut(part, "=")
+		if !ok || route == "" || (route != defaultRoute && !strings.HasPrefix(route, "/")) {
+			return nil, fmt.Errorf("rate limit %q: want route=N/s[:burst]", part)
+		}
+		v, burst, hasBurst := strings.Cut(v, ":")
+		n, per, ok := strings.Cut(v, "/")
+		count, err := strconv.ParseFloat(n, 64)
+		if !ok || err != nil || count <= 0 {
+			return nil, fmt.Errorf("rate limit %q: want route=N/s[:burst]", part)
+		}
+		var l rateLimit
+		switch per {
+		case "s":
+			l.rate = count
+		case "m":
+			l.rate = count / 60
+		case "h":
+			l.rate = count / 3600
+		default:
+			return nil, fmt.Errorf("rate limit %q: period must be s, m or h", part)
+		}
+		l.burst = math.Max(count, 1)
+		if hasBurst {
+			b, err := strconv.Atoi(burst)
+			if err != nil || b <= 0 {
+				return nil, fmt.Errorf("rate limit %q: burst must be a positive integer", part)
+			}
+			l.burst = float64(b)
+		}
+		limits[route] = l
+	}
+	return limits, nil
+}
+
+// bucketKey — ведро маршрута для клиента: по адресу (ip) или пользователю (user).
+type bucketKey struct {
+	route, kind, id string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// level возвращает число токенов в ведре к моменту now.
+func (b *bucket) level(l rateLimit, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// bucketTTL — через сколько без запросов полное ведро забывается.
+const bucketTTL = 10 * time.Minute
+
+// RateLimiter ограничивает частоту запросов алгоритмом token bucket.
+// У каждого маршрута свои вёдра для адреса клиента и для пользователя:
+// запрос проходит, только если токен есть в обоих. Ведро адреса
+// проверяется до аутентификации и ограничивает подбор ключей, ведро
+// пользователя — после неё.
+type RateLimiter struct {
+	clock Clock
+
+	mu        sync.Mutex
+	limits    map[string]rateLimit
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+
+	limited *CounterVec
+}
+
+// NewRateLimiter создаёт выключенный ограничитель; лимиты задаёт Configure.
+// Состояние вёдер и отказы публикуются в reg.
+func NewRateLimiter(clock Clock, reg *Registry) *RateLimiter {
+	l := &RateLimiter{
+		clock:   clock,
+		buckets: make(map[bucketKey]*bucket),
+		limited: reg.NewCounter("http_rate_limited_total", "Requests rejected with 429 by route and bucket kind.", "route", "kind"),
+	}
+	reg.register(l)
+	return l
+}
+
+// Configure меняет лимиты на ходу. Накопленные токены сохраняются,
+// но не превышают новой ёмкости.
+func (l *RateLimiter) Configure(limits map[string]rateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+}
+
+// limit возвращает лимит маршрута. Вызывается под mu.
+func (l *RateLimiter) limit(route string) (rateLimit, bool) {
+	if lim, ok := l.limits[route]; ok {
+		return lim, true
+	}
+	lim, ok := l.limits[defaultRoute]
+	return lim, ok
+}
+
+// Allow списывает токен из ведра маршрута route для клиента id вида kind:
+// ip — адрес, user — пользователь. Если токена нет, возвращает false
+// и время, через которое он появится.
+func (l *RateLimiter) Allow(route, kind, id string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.limit(route)
+	if !ok {
+		return true, 0
+	}
+	now := l.clock.Now()
+	l.sweep(now)
+	k := bucketKey{route, kind, id}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: lim.burst, last: now}
+		l.buckets[k] = b
+	}
+	b.tokens, b.last = b.level(lim, now), now
+	if b.tokens < 1 {
+		l.limited.Inc(route, kind)
+		return false, time.Duration((1 - b.tokens) / lim.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep забывает вёдра, которые успели наполниться, и вёдра маршрутов
+// без лимита: новое ведро от них не отличается. Вызывается под mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		lim, ok := l.limit(k.route)
+		if !ok || (now.Sub(b.last) > bucketTTL && b.level(lim, now) >= lim.burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// bucketGroup — вёдра одного маршрута и вида в метриках.
+type bucketGroup struct {
+	route, kind string
+}
+
+// write публикует по маршрутам и видам число вёдер и число пустых из них.
+// Адреса и пользователи в метки не попадают: рядов стало бы неограниченно много.
+func (l *RateLimiter) write(w *bufio.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	total := make(map[bucketGroup]int)
+	empty := make(map[bucketGroup]int)
+	for k, b := range l.buckets {
+		lim, ok := l.limit(k.route)
+		if !ok {
+			continue
+		}
+		g := bucketGroup{k.route, k.kind}
+		total[g]++
+		if b.level(lim, now) < 1 {
+			empty[g]++
+		}
+	}
+	groups := make([]bucketGroup, 0, len(total))
+	for g := range total {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].route != groups[j].route {
+			return groups[i].route < groups[j].route
+		}
+		return groups[i].kind < groups[j].kind
+	})
+	for _, m := range []struct {
+		name, help string
+		counts     map[bucketGroup]int
+	}{
+		{"rate_limit_buckets", "Active rate limit buckets by route and bucket kind.", total},
+		{"rate_limit_empty_buckets", "Rate limit buckets without a token by route and bucket kind.", empty},
+	} {
+		v := newVec(m.name, m.help, "gauge", []string{"route", "kind"})
+		v.header(w)
+		for _, g := range groups {
+			fmt.Fprintf(w, "%s%s %d\n", v.name, v.labelString([]string{g.route, g.kind}), m.counts[g])
+		}
+	}
+}
+
+// errRateLimited — ответ на превышение лимита.
+var errRateLimited = &statusError{status: http.StatusTooManyRequests, msg: "rate limit exceeded"}
+
+// byAddress ограничивает запросы по адресу клиента. Ставится до
+// аутентификации, чтобы ограничивать и запросы с неверными ключами.
+func (l *RateLimiter) byAddress(routes *http.ServeMux) middleware {
+	return l.middleware(routes, "ip", clientIP)
+}
+
+// byUser ограничивает запросы аутентифицированного пользователя. Ставится
+// после аутентификации; без неё пользователь неизвестен — параметру
+// user_id верить нельзя — и запрос ограничивается только по адресу.
+func (l *RateLimiter) byUser(routes *http.ServeMux) middleware {
+	return l.middleware(routes, "user", func(r *http.Request) string {
+		if p := principalFrom(r.Context()); p != nil {
+			return strconv.FormatInt(p.UserID, 10)
+		}
+		return ""
+	})
+}
+
+// middleware отклоняет запросы сверх лимита маршрута кодом 429 с
+// Retry-After. Маршрут — шаблон routes, под который попадает запрос,
+// ведро вида kind выбирает client; пустой client — запрос не ограничивается.
+func (l *RateLimiter) middleware(routes *http.ServeMux, kind string, client func(*http.Request) string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := client(r)
+			if id == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			_, route := routes.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			ok, wait := l.Allow(route, kind, id)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, errRateLimited)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP возвращает адрес клиента соединения. X-Forwarded-For не
+// учитывается: без доверенного прокси его подделывает сам клиент.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limitedHandler возвращает обработчик с аутентификацией по ключам
+// alice-key и bob-key и лимитами spec на часах clock.
+func limitedHandler(t *testing.T, spec string, clock Clock) (http.Handler, *Registry) {
+	t.Helper()
+	server := NewServer(NewCalendar(NewMemoryStore()), AllowConflicts)
+	t.Cleanup(server.CloseStreams)
+	server.SetCredentials(map[string]int64{"alice-key": 1, "bob-key": 2}, nil)
+	reg := NewRegistry()
+	limiter := NewRateLimiter(clock, reg)
+	limits, err := parseRateLimits(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Configure(limits)
+	return newHandler(server, reg, limiter, slog.New(slog.NewJSONHandler(io.Discard, nil))), reg
+}
+
+// serve выполняет GET path с адреса ip и ключом key (пустой — без него).
+func serve(h http.Handler, path, ip, key string) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = ip + ":40000"
+	if key != "" {
+		r.Header.Set("X-Api-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	h, _ := limitedHandler(t, "*=2/m", &testClock{now: time.Now()})
+	const day = "/events_for_day?date=2026-10-19"
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if got := serve(h, day, "192.0.2.1", "guess"); got != want {
+			t.Errorf("attempt %d: status %d, want %d", i+1, got, want)
+		}
+	}
+	// верный ключ с того же адреса тоже ждёт
+	if got := serve(h, day, "192.0.2.1", "alice-key"); got != http.StatusTooManyRequests {
+		t.Errorf("valid key after brute force: status %d", got)
+	}
+	if got := serve(h, day, "192.0.2.2", "alice-key"); got != http.StatusOK {
+		t.Errorf("other address: status %d", got)
+	}
+}
+
+func TestRateLimitByPrincipal(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	h, reg := limitedHandler(t, "*=1/m", clock)
+	const day = "/events_for_day?date=2026-10-19"
+	if got := serve(h, day, "192.0.2.1", "alice-key"); got != http.StatusOK {
+		t.Fatalf("first request: status %d", got)
+	}
+	// пользователь ограничен и с другого адреса
+	if got := serve(h, day, "192.0.2.2", "alice-key"); got != http.StatusTooManyRequests {
+		t.Errorf("same user from another address: status %d", got)
+	}
+	// чужой user_id в query не расходует лимит bob, у которого alice нет доступа
+	serve(h, day+"&user_id=2", "192.0.2.3", "alice-key")
+	if got := serve(h, day, "192.0.2.4", "bob-key"); got != http.StatusOK {
+		t.Errorf("bob after alice named him in user_id: status %d", got)
+	}
+	clock.set(clock.Now().Add(time.Minute))
+	if got := serve(h, day, "192.0.2.5", "alice-key"); got != http.StatusOK {
+		t.Errorf("after refill: status %d", got)
+	}
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	metrics := w.Body.String()
+	if !strings.Contains(metrics, `rate_limit_buckets{route="/events_for_day",kind="user"} 2`) {
+		t.Errorf("no per-route bucket gauge in\n%s", metrics)
+	}
+	if strings.Contains(metrics, "192.0.2.") {
+		t.Errorf("client addresses in metrics:\n%s", metrics)
+	}
+}
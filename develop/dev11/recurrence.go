@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency — частота повторения события.
+type Frequency string
+
+// Поддерживаемые частоты повторения (подмножество RRULE из RFC 5545).
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// Rule — правило повторения события в духе RRULE.
+// Count и Until взаимоисключающие, нулевые значения означают «без ограничения».
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+// maxOccurrences ограничивает разворачивание бесконечных серий.
+const maxOccurrences = 100000
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// untilLayouts — допустимые форматы UNTIL.
+var untilLayouts = []string{"20060102", "20060102T150405Z", dateLayout}
+
+// ParseRule разбирает правило вида FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10.
+// UNTIL со временем UTC задаёт дату по UTC.
+func ParseRule(s string) (*Rule, error) {
+	return parseRuleIn(s, time.UTC)
+}
+
+// parseRuleIn разбирает правило серии, заданной в зоне loc: UNTIL со временем
+// UTC переводится в дату по её часам.
+func parseRuleIn(s string, loc *time.Location) (*Rule, error) {
+	r := &Rule{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(s, "RRULE:"), ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		key, val := strings.ToUpper(kv[0]), kv[1]
+		switch key {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(val))
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("INTERVAL: %w", err)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("COUNT: %w", err)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseUntil(val, loc)
+			if err != nil {
+				return nil, err
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, code := range strings.Split(val, ",") {
+				d, ok := weekdayCodes[strings.ToUpper(code)]
+				if !ok {
+					return nil, fmt.Errorf("BYDAY: unknown day %q", code)
+				}
+				r.ByDay = append(r.ByDay, d)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func parseUntil(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range untilLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return civilDate(t, loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("UNTIL: invalid date %q", s)
+}
+
+func (r *Rule) validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly:
+	case "":
+		return errors.New("FREQ is required")
+	default:
+		return fmt.Errorf("unsupported FREQ %q", r.Freq)
+	}
+	if r.Interval < 1 {
+		return errors.New("INTERVAL must be positive")
+	}
+	if r.Count < 0 {
+		return errors.New("COUNT must be positive")
+	}
+	if r.Count > maxOccurrences {
+		return fmt.Errorf("COUNT must not exceed %d", maxOccurrences)
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("COUNT and UNTIL are mutually exclusive")
+	}
+	return nil
+}
+
+// String возвращает правило в формате RRULE, UNTIL — датой.
+func (r *Rule) String() string {
+	return r.format(r.Until.Format("20060102"))
+}
+
+// format возвращает правило в формате RRULE с UNTIL, записанным как until.
+func (r *Rule) format(until string) string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, 0, len(r.ByDay))
+		for _, d := range r.ByDay {
+			codes = append(codes, strings.ToUpper(d.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+until)
+	}
+	return strings.Join(parts, ";")
+}
+
+func (r *Rule) hasDay(d time.Weekday) bool {
+	for _, bd := range r.ByDay {
+		if bd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrences возвращает даты повторений серии, начинающейся в start,
+// попадающие в полуинтервал [from, to). Исключения здесь не учитываются.
+func (r *Rule) occurrences(start, from, to time.Time) []time.Time {
+	// повторения по COUNT приходится отсчитывать от начала серии,
+	// остальные правила перебираются сразу с from
+	skip := from
+	if r.Count > 0 {
+		skip = start
+	}
+	var res []time.Time
+	n := 0
+	for _, d := range r.candidates(start, skip, to) {
+		if r.Count > 0 && n >= r.Count {
+			break
+		}
+		if !r.Until.IsZero() && d.After(r.Until) {
+			break
+		}
+		n++
+		if !d.Before(from) {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// candidates перечисляет даты по правилу от start до to без учёта COUNT
+// и UNTIL. Периоды правила, целиком лежащие до skip, пропускаются без
+// перебора, поэтому запрос далеко от начала серии не упирается
+// в maxOccurrences.
+func (r *Rule) candidates(start, skip, to time.Time) []time.Time {
+	var res []time.Time
+	add := func(d time.Time) bool {
+		if d.Before(start) {
+			return true
+		}
+		if !d.Before(to) || len(res) >= maxOccurrences {
+			return false
+		}
+		res = append(res, d)
+		return true
+	}
+	switch r.Freq {
+	case Daily:
+		first := start
+		if skip.After(start) {
+			first = start.AddDate(0, 0, daysBetween(start, skip)/r.Interval*r.Interval)
+		}
+		for d := first; ; d = d.AddDate(0, 0, r.Interval) {
+			if len(r.ByDay) > 0 && !r.hasDay(d.Weekday()) {
+				if !d.Before(to) {
+					return res
+				}
+				continue
+			}
+			if !add(d) {
+				return res
+			}
+		}
+	case Weekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		offsets := make([]int, 0, len(days))
+		for _, d := range days {
+			offsets = append(offsets, (int(d)+6)%7)
+		}
+		sort.Ints(offsets)
+		monday := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		if skip.After(monday) {
+			period := 7 * r.Interval
+			monday = monday.AddDate(0, 0, daysBetween(monday, skip)/period*period)
+		}
+		for week := monday; week.Before(to); week = week.AddDate(0, 0, 7*r.Interval) {
+			for _, off := range offsets {
+				if !add(week.AddDate(0, 0, off)) {
+					return res
+				}
+			}
+		}
+	case Monthly:
+		first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if months := (skip.Year()-first.Year())*12 + int(skip.Month()-first.Month()); months > 0 {
+			first = first.AddDate(0, months/r.Interval*r.Interval, 0)
+		}
+		for month := first; month.Before(to); month = month.AddDate(0, r.Interval, 0) {
+			next := month.AddDate(0, 1, 0)
+			if len(r.ByDay) == 0 {
+				d := month.AddDate(0, 0, start.Day()-1)
+				// в месяце нет такого числа — повторение пропускается
+				if d.Before(next) && !add(d) {
+					return res
+				}
+				continue
+			}
+			for d := month; d.Before(next); d = d.AddDate(0, 0, 1) {
+				if r.hasDay(d.Weekday()) && !add(d) {
+					return res
+				}
+			}
+		}
+	}
+	return res
+}
+
+// occursOn сообщает, приходится ли на календарную дату day повторение серии.
+func (e Event) occursOn(day time.Time) bool {
+	if e.Rule == nil {
+		return e.Day().Equal(day)
+	}
+	if e.isException(day) {
+		return false
+	}
+	return len(e.Rule.occurrences(e.Day(), day, day.AddDate(0, 0, 1))) > 0
+}
+
+func (e Event) isException(day time.Time) bool {
+	for _, ex := range e.Exceptions {
+		if ex.Equal(day) {
+			return true
+		}
+	}
+	return false
+}
+
+// addException исключает день из серии. Срез копируется, чтобы не задеть
+// событие, полученное из хранилища.
+func (e *Event) addException(day time.Time) {
+	n := len(e.Exceptions)
+	e.Exceptions = append(e.Exceptions[:n:n], truncateDay(day))
+}
+
+// instance возвращает экземпляр серии, приходящийся на календарную дату day:
+// время суток начала и конца берётся из первого повторения по часам зоны
+// события, поэтому после перевода часов встреча остаётся в 9:00.
+func (e Event) instance(day time.Time) Event {
+	occ := e
+	occ.moveTo(day)
+	return occ
+}
+
+// expand возвращает экземпляры события, пересекающиеся с [from, to).
+// Для повторяющегося события каждый экземпляр получает своё время начала.
+func (e Event) expand(from, to time.Time) []Event {
+	window := Interval{Start: from, End: to}
+	if e.Rule == nil {
+		if e.span().overlaps(window) {
+			return []Event{e}
+		}
+		return nil
+	}
+	// повторение, начавшееся раньше from, может ещё продолжаться
+	loc := e.location()
+	dayFrom := civilDate(from.Add(-e.End.Sub(e.Start)), loc).AddDate(0, 0, -1)
+	dayTo := civilDate(to, loc).AddDate(0, 0, 1)
+	var res []Event
+	for _, d := range e.Rule.occurrences(e.Day(), dayFrom, dayTo) {
+		if e.isException(d) {
+			continue
+		}
+		if occ := e.instance(d); occ.span().overlaps(window) {
+			res = append(res, occ)
+		}
+	}
+	return res
+}
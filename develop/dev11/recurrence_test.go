@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func formatDates(days []time.Time) string {
+	s := make([]string, len(days))
+	for i, d := range days {
+		s[i] = d.Format(dateLayout)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestRuleOccurrences(t *testing.T) {
+	for _, tc := range []struct {
+		rule, start, from, to string
+		want                  string
+	}{
+		{"FREQ=DAILY;COUNT=3", "2026-10-19", "2026-10-01", "2026-11-01", "2026-10-19 2026-10-20 2026-10-21"},
+		{"FREQ=DAILY;INTERVAL=2;UNTIL=20261025", "2026-10-19", "2026-10-20", "2026-11-01", "2026-10-21 2026-10-23 2026-10-25"},
+		{"FREQ=DAILY;BYDAY=SA,SU", "2026-10-19", "2026-10-19", "2026-11-02", "2026-10-24 2026-10-25 2026-10-31 2026-11-01"},
+		{"FREQ=WEEKLY;BYDAY=MO,WE;COUNT=3", "2026-10-21", "2026-10-01", "2026-12-01", "2026-10-21 2026-10-26 2026-10-28"},
+		{"FREQ=WEEKLY;INTERVAL=2", "2026-10-19", "2026-10-20", "2026-11-20", "2026-11-02 2026-11-16"},
+		// в месяцах без 31-го числа повторения нет
+		{"FREQ=MONTHLY;COUNT=3", "2026-10-31", "2026-10-01", "2027-06-01", "2026-10-31 2026-12-31 2027-01-31"},
+		{"FREQ=MONTHLY;INTERVAL=3;BYDAY=FR", "2026-10-19", "2027-01-01", "2027-02-01", "2027-01-01 2027-01-08 2027-01-15 2027-01-22 2027-01-29"},
+		// окно далеко от начала бесконечной серии
+		{"FREQ=DAILY;INTERVAL=3", "2000-01-01", "2400-01-01", "2400-01-08", "2400-01-01 2400-01-04 2400-01-07"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", "2000-01-04", "2400-01-01", "2400-01-15", "2400-01-11"},
+		{"FREQ=MONTHLY;INTERVAL=5", "2000-01-15", "2400-01-01", "2401-01-01", "2400-01-15 2400-06-15 2400-11-15"},
+	} {
+		r, err := ParseRule(tc.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.rule, err)
+		}
+		got := formatDates(r.occurrences(date(tc.start), date(tc.from), date(tc.to)))
+		if got != tc.want {
+			t.Errorf("%s from %s in [%s, %s): %s, want %s", tc.rule, tc.start, tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, s := range []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10",
+		"FREQ=MONTHLY;UNTIL=20270101",
+	} {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if r.String() != s {
+			t.Errorf("%s formatted as %s", s, r.String())
+		}
+	}
+	for _, s := range []string{
+		"",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20270101",
+		"FREQ=DAILY;COUNT=1000000",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;BYSETPOS=1",
+	} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestSeriesExceptions(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, loc)
+	e := Event{Title: "Standup", Start: start, End: start.Add(15 * time.Minute), TZ: "Europe/Moscow",
+		Rule: &Rule{Freq: Daily, Interval: 1, Count: 4}, Exceptions: []time.Time{date("2026-10-20")}}
+	var days []time.Time
+	for _, occ := range e.expand(start, start.AddDate(0, 1, 0)) {
+		days = append(days, civilDate(occ.Start, loc))
+		if occ.Start.In(loc).Hour() != 9 {
+			t.Errorf("occurrence starts at %s", occ.Start.In(loc))
+		}
+	}
+	// исключённый день входит в COUNT
+	if got := formatDates(days); got != "2026-10-19 2026-10-21 2026-10-22" {
+		t.Errorf("occurrences %s", got)
+	}
+	if e.occursOn(date("2026-10-20")) || !e.occursOn(date("2026-10-21")) {
+		t.Error("occursOn ignores exceptions")
+	}
+}
+
+func TestRuleUntilDateTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 10, 19, 21, 0, 0, 0, loc)
+	e := Event{UID: "late@test", Title: "Late", Start: start, End: start.Add(time.Hour), TZ: "America/New_York",
+		Rule: &Rule{Freq: Daily, Interval: 1, Until: date("2026-10-21")}}
+	// 21:00 по Нью-Йорку — уже следующий день по UTC
+	if got, want := e.rrule(), "FREQ=DAILY;UNTIL=20261022T010000Z"; got != want {
+		t.Errorf("rrule %s, want %s", got, want)
+	}
+	all := e
+	all.AllDay = true
+	if got, want := all.rrule(), "FREQ=DAILY;UNTIL=20261021"; got != want {
+		t.Errorf("all-day rrule %s, want %s", got, want)
+	}
+	r, err := parseRuleIn(e.rrule(), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Until.Equal(e.Rule.Until) {
+		t.Errorf("UNTIL parsed as %s, want %s", r.Until.Format(dateLayout), e.Rule.Until.Format(dateLayout))
+	}
+}
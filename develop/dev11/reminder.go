@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReminder — самое раннее напоминание относительно начала события.
+const maxReminder = 28 * 24 * time.Hour
+
+// Reminder — напоминание о конкретном повторении события.
+type Reminder struct {
+	EventID int64
+	UserID  int64
+	Title   string
+	TZ      string
+	// Start — начало повторения, о котором напоминание.
+	Start time.Time
+	// Before — за сколько до начала срабатывает напоминание.
+	Before time.Duration
+	// At — момент срабатывания.
+	At time.Time
+}
+
+// DueReminders возвращает напоминания всех пользователей — организаторам
+// и не отказавшимся участникам, — срабатывающие в полуинтервале (from, to],
+// упорядоченные по моменту срабатывания.
+func (c *Calendar) DueReminders(from, to time.Time) ([]Reminder, error) {
+	events, err := c.store.All()
+	if err != nil {
+		return nil, err
+	}
+	var res []Reminder
+	for _, e := range events {
+		if len(e.Reminders) == 0 {
+			continue
+		}
+		var earliest time.Duration
+		for _, before := range e.Reminders {
+			if before > earliest {
+				earliest = before
+			}
+		}
+		// повторение, о котором напоминают в (from, to], начинается
+		// не раньше from и не позже to+earliest
+		for _, occ := range e.expand(from, to.Add(earliest+time.Nanosecond)) {
+			for _, before := range e.Reminders {
+				at := occ.Start.Add(-before)
+				if !at.After(from) || at.After(to) {
+					continue
+				}
+				for _, userID := range e.participants() {
+					if !e.attends(userID) {
+						continue
+					}
+					res = append(res, Reminder{
+						EventID: e.ID,
+						UserID:  userID,
+						Title:   e.Title,
+						TZ:      e.TZ,
+						Start:   occ.Start,
+						Before:  before,
+						At:      at,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].At.Before(res[j].At)
+	})
+	return res, nil
+}
+
+// ReminderMark возвращает момент, до которого напоминания уже разосланы.
+func (c *Calendar) ReminderMark() (time.Time, error) {
+	return c.store.ReminderMark()
+}
+
+// SetReminderMark сохраняет момент, до которого напоминания разосланы.
+func (c *Calendar) SetReminderMark(t time.Time) error {
+	return c.store.SetReminderMark(t)
+}
+
+// Clock — источник текущего времени, в тестах подменяется управляемым.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock — системные часы.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Dispatcher периодически находит сработавшие напоминания и рассылает их
+// через notifiers.
+//
+// Момент, до которого напоминания разосланы, хранится в EventStore и
+// сохраняется до отправки, поэтому после перезапуска напоминание не придёт
+// повторно (но может потеряться, если процесс упал во время отправки).
+// Напоминания, пропущенные пока сервер не работал, отправляются при запуске,
+// если опоздали не больше чем на maxLate.
+type Dispatcher struct {
+	cal       *Calendar
+	clock     Clock
+	maxLate   time.Duration
+	notifiers []Notifier
+
+	mu   sync.Mutex
+	mark time.Time
+}
+
+// NewDispatcher создаёт рассылку напоминаний календаря cal.
+func NewDispatcher(cal *Calendar, clock Clock, maxLate time.Duration, notifiers ...Notifier) *Dispatcher {
+	return &Dispatcher{cal: cal, clock: clock, maxLate: maxLate, notifiers: notifiers}
+}
+
+// Reconfigure меняет способы доставки и допустимое опоздание напоминаний.
+func (d *Dispatcher) Reconfigure(maxLate time.Duration, notifiers ...Notifier) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxLate = maxLate
+	d.notifiers = notifiers
+}
+
+// Tick рассылает напоминания, сработавшие с предыдущего вызова,
+// и возвращает их.
+func (d *Dispatcher) Tick() ([]Reminder, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clock.Now()
+	if d.mark.IsZero() {
+		mark, err := d.cal.ReminderMark()
+		if err != nil {
+			return nil, err
+		}
+		if mark.IsZero() {
+			// первый запуск: напоминания из прошлого не рассылаются
+			mark = now
+		}
+		d.mark = mark
+	}
+	if !now.After(d.mark) {
+		return nil, nil
+	}
+	from := d.mark
+	if d.maxLate > 0 && now.Sub(from) > d.maxLate {
+		from = now.Add(-d.maxLate)
+	}
+	due, err := d.cal.DueReminders(from, now)
+	if err != nil {
+		return nil, err
+	}
+	// без напоминаний отметка остаётся в памяти, чтобы не писать в
+	// хранилище на каждом такте; она сохраняется в Close
+	if len(due) > 0 {
+		if err := d.cal.SetReminderMark(now); err != nil {
+			return nil, err
+		}
+	}
+	d.mark = now
+	for _, r := range due {
+		for _, n := range d.notifiers {
+			if err := n.Notify(r); err != nil {
+				log.Printf("reminder for event %d: %v", r.EventID, err)
+			}
+		}
+	}
+	return due, nil
+}
+
+// Run вызывает Tick каждые interval, пока не закрыт stop.
+func (d *Dispatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Tick(); err != nil {
+			log.Printf("reminders: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close дожидается текущей рассылки и сохраняет отметку.
+func (d *Dispatcher) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mark.IsZero() {
+		return nil
+	}
+	return d.cal.SetReminderMark(d.mark)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock — часы, которые двигает тест.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// recordingNotifier запоминает доставленные напоминания.
+type recordingNotifier struct {
+	sent []Reminder
+}
+
+func (n *recordingNotifier) Notify(r Reminder) error {
+	n.sent = append(n.sent, r)
+	return nil
+}
+
+// TestRemindersAcrossRestart проверяет, что разосланное напоминание
+// не повторяется после перезапуска, а пропущенное за время простоя
+// досылается, если опоздало не больше чем на maxLate.
+func TestRemindersAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 10, 19, hour, min, 0, 0, time.UTC)
+	}
+	clock := &testClock{now: at(9, 0)}
+	// start создаёт календарь поверх хранилища в dir и его рассылку
+	start := func() (*FileStore, *Calendar, *Dispatcher, *recordingNotifier) {
+		store, err := NewFileStore(dir, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cal := NewCalendar(store)
+		n := &recordingNotifier{}
+		return store, cal, NewDispatcher(cal, clock, time.Hour, n), n
+	}
+	tick := func(d *Dispatcher, now time.Time) {
+		t.Helper()
+		clock.set(now)
+		if _, err := d.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := func(store *FileStore, d *Dispatcher) {
+		t.Helper()
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, cal, d, n := start()
+	for _, e := range []Event{
+		{UserID: 1, Title: "Standup", Start: at(10, 0), End: at(10, 15), Reminders: []time.Duration{15 * time.Minute}},
+		{UserID: 1, Title: "Review", Start: at(11, 0), End: at(12, 0), Reminders: []time.Duration{30 * time.Minute}},
+		{UserID: 1, Title: "Demo", Start: at(18, 0), End: at(19, 0), Reminders: []time.Duration{2 * time.Hour}},
+	} {
+		if _, _, err := cal.CreateEvent(e, AllowConflicts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tick(d, at(9, 0))
+	tick(d, at(9, 50))
+	if len(n.sent) != 1 || n.sent[0].Title != "Standup" || !n.sent[0].At.Equal(at(9, 45)) {
+		t.Fatalf("sent before restart %+v", n.sent)
+	}
+	stop(store, d)
+
+	// напоминание о Review срабатывает в 10:30, пока сервер не работает
+	store, _, d, n = start()
+	tick(d, at(10, 40))
+	if len(n.sent) != 1 || n.sent[0].Title != "Review" {
+		t.Fatalf("sent after restart %+v, want only the missed Review", n.sent)
+	}
+	stop(store, d)
+
+	// напоминание о Demo в 16:00 опоздало больше чем на maxLate
+	store, _, d, n = start()
+	defer stop(store, d)
+	tick(d, at(17, 30))
+	if len(n.sent) != 0 {
+		t.Errorf("sent after a long downtime %+v", n.sent)
+	}
+}
+
+func TestDueRemindersAttendees(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	e := Event{UserID: 1, Title: "Planning", Start: start, End: start.Add(time.Hour),
+		Reminders: []time.Duration{10 * time.Minute, time.Hour},
+		Rule:      &Rule{Freq: Daily, Interval: 1, Count: 2}}
+	e.setAttendees([]int64{2})
+	if _, _, err := cal.CreateEvent(e, AllowConflicts); err != nil {
+		t.Fatal(err)
+	}
+	due, err := cal.DueReminders(start.Add(-2*time.Hour), start.Add(23*time.Hour+30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// организатору и участнику оба напоминания о первом повторении
+	// и часовое о втором: десятиминутное о нём за пределами интервала
+	if len(due) != 6 {
+		t.Fatalf("%d reminders: %+v", len(due), due)
+	}
+	for i := 1; i < len(due); i++ {
+		if due[i].At.Before(due[i-1].At) {
+			t.Errorf("reminders out of order: %+v", due)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// apiParam — параметр метода API для документации OpenAPI.
+type apiParam struct {
+	name     string
+	typ      string // integer, string или boolean
+	format   string // date, date-time, duration и т.п.
+	list     bool   // несколько значений через запятую или массив в JSON
+	required bool
+	enum     []string
+	desc     string
+}
+
+// route — метод API: обработчик и его описание для /openapi.json.
+type route struct {
+	pattern string
+	method  string
+	summary string
+	params  []apiParam
+	// query — параметры POST запроса передаются в query string,
+	// тело имеет тип body.
+	query  bool
+	body   string
+	result interface{} // пример значения result для схемы ответа
+	// produces — типы ответа в порядке предпочтения, по умолчанию JSON.
+	produces []string
+	// request — пример тела JSON, если оно не сводится к параметрам.
+	request interface{}
+	// public — метод доступен без аутентификации.
+	public  bool
+	handler http.HandlerFunc
+}
+
+// Общие параметры методов.
+var (
+	userIDParam     = apiParam{name: "user_id", typ: "integer", desc: "Calendar owner; defaults to the authenticated user, required without authentication."}
+	eventIDParam    = apiParam{name: "id", typ: "integer", required: true, desc: "Event ID."}
+	tzParam         = apiParam{name: "tz", typ: "string", desc: "IANA time zone; defaults to the user's zone."}
+	occurrenceParam = apiParam{name: "occurrence", typ: "string", format: "date", desc: "Date of a single occurrence of a series to act on."}
+	conflictParam   = apiParam{name: "conflict", typ: "string", enum: []string{"allow", "warn", "reject"}, desc: "Overlap policy; defaults to the server policy."}
+	versionParam    = apiParam{name: "version", typ: "integer", desc: "Expected event version (of the series for an occurrence), like If-Match; the call fails with 412 if the event has changed."}
+)
+
+// eventParams — поля события, общие для создания и изменения.
+var eventParams = []apiParam{
+	{name: "title", typ: "string", desc: "Event title."},
+	{name: "description", typ: "string", desc: "Event description."},
+	{name: "date", typ: "string", format: "date", desc: "All-day event date (without start); on update alone moves the event to another day."},
+	{name: "days", typ: "integer", desc: "Length of an all-day event in days, 1 by default."},
+	{name: "start", typ: "string", format: "date-time", desc: "Start: RFC 3339 or YYYY-MM-DDTHH:MM local time in tz."},
+	{name: "end", typ: "string", format: "date-time", desc: "End, same format as start."},
+	{name: "duration", typ: "string", format: "duration", desc: "Length instead of end, like 30m or 1h30m; 1h by default."},
+	{name: "rrule", typ: "string", desc: "Recurrence rule like FREQ=WEEKLY;BYDAY=MO,WE; empty on update removes it."},
+	{name: "exdate", typ: "string", format: "date", list: true, desc: "Dates excluded from the series."},
+	{name: "reminders", typ: "string", format: "duration", list: true, desc: "Reminders before start, like 15m,1h; empty on update removes them."},
+	{name: "tags", typ: "string", list: true, desc: "Tags for search and filtering; empty on update removes them."},
+	{name: "attendees", typ: "integer", list: true, desc: "Invited user IDs; user_id is the organizer. On update replaces the list keeping given answers; empty removes all."},
+}
+
+// routes перечисляет методы API.
+func (s *Server) routes() []route {
+	title := eventParams[0]
+	title.required = true
+	return []route{
+		{
+			pattern: "/create_event", method: http.MethodPost,
+			summary: "Create an event: all-day with date or timed with start.",
+			params:  append([]apiParam{userIDParam, tzParam, conflictParam, title}, eventParams[1:]...),
+			result:  savedEventJSON{}, handler: s.createEvent,
+		},
+		{
+			pattern: "/update_event", method: http.MethodPost,
+			summary: "Change an event, a whole series or one occurrence of it.",
+			params:  append([]apiParam{userIDParam, eventIDParam, occurrenceParam, versionParam, tzParam, conflictParam}, eventParams...),
+			result:  savedEventJSON{}, handler: s.updateEvent,
+		},
+		{
+			pattern: "/delete_event", method: http.MethodPost,
+			summary: "Delete an event, a whole series or one occurrence of it.",
+			params:  []apiParam{userIDParam, eventIDParam, occurrenceParam, versionParam},
+			result:  "event deleted", handler: s.deleteEvent,
+		},
+		{
+			pattern: "/batch", method: http.MethodPost,
+			summary: "Run up to 1000 create_event, update_event and delete_event operations with the same params " +
+				"as the single calls. Results come per operation with the status a single call would get; " +
+				"with atomic a failed operation rolls back the ones before it and its error is the response.",
+			request: batchRequest{}, result: []batchItemJSON{}, handler: s.batch,
+		},
+		{
+			pattern: "/rpc", method: http.MethodPost,
+			summary: "JSON-RPC 2.0 calls of create_event, update_event, delete_event, events_for_day, " +
+				"events_for_week and events_for_month with the same params and results as the methods. " +
+				"The body is a call or an array of calls; the response is 200 with the JSON-RPC response, " +
+				"failed calls included, or 204 when all calls are notifications.",
+			request: rpcRequest{}, handler: s.rpc,
+		},
+		s.eventsRoute("/events_for_day", "Events of the day.", s.cal.EventsForDay),
+		s.eventsRoute("/events_for_week", "Events of the week (from Monday) containing the date.", s.cal.EventsForWeek),
+		s.eventsRoute("/events_for_month", "Events of the month containing the date.", s.cal.EventsForMonth),
+		{
+			pattern: "/events/stream", method: http.MethodGet,
+			summary: "Server-Sent Events stream of created, updated and deleted events of the calendar. " +
+				"Send Last-Event-ID (or last_event_id) to resume; a reset event means missed changes are gone.",
+			params: []apiParam{
+				userIDParam,
+				{name: "last_event_id", typ: "string", desc: "Resume after this event ID, for clients that cannot set Last-Event-ID."},
+			},
+			produces: []string{eventStreamType}, handler: s.eventStream,
+		},
+		{
+			pattern: "/event_history", method: http.MethodGet,
+			summary: "Audit trail of an event, also after it was deleted: who changed it, when, and the event after each change.",
+			params:  []apiParam{userIDParam, eventIDParam},
+			result:  []auditJSON{}, handler: s.eventHistory,
+		},
+		{
+			pattern: "/restore_event", method: http.MethodPost,
+			summary: "Return an event, also a deleted one, to a version from its history; the result gets a new version. " +
+				"Occurrences deleted with a series are restored one by one.",
+			params: []apiParam{
+				userIDParam, eventIDParam,
+				{name: "to_version", typ: "integer", required: true, desc: "Version from /event_history to return to."},
+				versionParam,
+			},
+			result: eventJSON{}, handler: s.restoreEvent,
+		},
+		{
+			pattern: "/respond_event", method: http.MethodPost,
+			summary: "Answer an invitation; the answer applies to the whole series.",
+			params: []apiParam{
+				{name: "user_id", typ: "integer", desc: "Invited user; defaults to the authenticated user."},
+				eventIDParam,
+				{name: "status", typ: "string", required: true, enum: []string{"accepted", "declined", "tentative", "pending"}, desc: "Answer."},
+			},
+			result: eventJSON{}, handler: s.respondEvent,
+		},
+		{
+			pattern: "/search", method: http.MethodGet,
+			summary: "Search events by words in title and description, tags, participants and dates.",
+			params: []apiParam{
+				userIDParam,
+				{name: "q", typ: "string", desc: "Words that must all occur in title or description; matched by prefix."},
+				{name: "tag", typ: "string", list: true, desc: "Tags that must all be set."},
+				{name: "participant", typ: "integer", list: true, desc: "User IDs that must all take part."},
+				{name: "from", typ: "string", format: "date", desc: "First day; with to, occurrences in the range are returned instead of whole series."},
+				{name: "to", typ: "string", format: "date", desc: "Last day, inclusive."},
+				tzParam,
+				{name: "limit", typ: "integer", desc: "Maximum number of results, 100 by default, at most 1000."},
+			},
+			result: []eventJSON{}, handler: s.search,
+		},
+		{
+			pattern: "/set_timezone", method: http.MethodPost,
+			summary: "Set the user's default time zone; empty tz resets it to UTC.",
+			params:  []apiParam{userIDParam, tzParam},
+			result:  timezoneJSON{}, handler: s.setTimezone,
+		},
+		{
+			pattern: "/free_busy", method: http.MethodGet,
+			summary: "Merged busy intervals of the users and free gaps between them.",
+			params: []apiParam{
+				{name: "user_id", typ: "integer", list: true, desc: "User IDs; defaults to the authenticated user."},
+				{name: "from", typ: "string", format: "date", required: true, desc: "First day."},
+				{name: "to", typ: "string", format: "date", required: true, desc: "Last day, inclusive."},
+				{name: "min", typ: "string", format: "duration", desc: "Shortest free gap to report."},
+				tzParam,
+			},
+			result: freeBusyJSON{}, handler: s.freeBusy,
+		},
+		{
+			pattern: "/export.ics", method: http.MethodGet,
+			summary:  "All events of the user as iCalendar.",
+			params:   []apiParam{userIDParam},
+			produces: []string{calendarType}, handler: s.exportICal,
+		},
+		{
+			pattern: "/import", method: http.MethodPost,
+			summary: "Import events from an iCalendar body, matching existing ones by UID.",
+			params:  []apiParam{userIDParam, tzParam},
+			query:   true, body: calendarType,
+			result: importJSON{}, handler: s.importICal,
+		},
+		{
+			pattern: "/share", method: http.MethodPost,
+			summary: "Grant another user read or write access to the calendar; none revokes it. Owner only.",
+			params: []apiParam{
+				userIDParam,
+				{name: "grantee", typ: "integer", required: true, desc: "User who gets access."},
+				{name: "access", typ: "string", required: true, enum: []string{"none", "read", "write"}, desc: "Access level."},
+			},
+			result: shareJSON{}, handler: s.share,
+		},
+		{
+			pattern: "/shares", method: http.MethodGet,
+			summary: "Users the calendar is shared with. Owner only.",
+			params:  []apiParam{userIDParam},
+			result:  []grantJSON{}, handler: s.shares,
+		},
+		{
+			pattern: "/openapi.json", method: http.MethodGet,
+			summary: "This document.",
+			public:  true, handler: s.openAPI,
+		},
+	}
+}
+
+// eventsRoute описывает метод выборки событий за период.
+func (s *Server) eventsRoute(pattern, summary string, query func(int64, time.Time) ([]Event, error)) route {
+	return route{
+		pattern: pattern, method: http.MethodGet,
+		summary: summary + " Series are expanded into occurrences.",
+		params: []apiParam{
+			userIDParam,
+			{name: "date", typ: "string", format: "date", required: true, desc: "Any day of the period."},
+			tzParam,
+		},
+		result:   []eventJSON{},
+		produces: []string{jsonType, calendarType},
+		handler:  s.eventsFor(query),
+	}
+}
+
+// Handler возвращает маршрутизатор со всеми методами API.
+func (s *Server) Handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.pattern, method(rt.method, rt.produces, rt.handler))
+	}
+	mux.HandleFunc(caldavPrefix, s.caldav)
+	return mux
+}
+
+// method пропускает только запросы с методом m, клиент которых принимает
+// один из типов ответа produces (по умолчанию JSON).
+func method(m string, produces []string, h http.HandlerFunc) http.HandlerFunc {
+	if len(produces) == 0 {
+		produces = []string{jsonType}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		if _, ok := negotiate(r, produces...); !ok {
+			writeError(w, &statusError{
+				status: http.StatusNotAcceptable,
+				msg:    "response is available as " + strings.Join(produces, ", "),
+			})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// openAPI отдаёт описание методов API в формате OpenAPI 3.
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildOpenAPI(s.routes()))
+}
+
+// obj — объект документа OpenAPI.
+type obj = map[string]interface{}
+
+// buildOpenAPI строит документ OpenAPI 3 по списку методов. Схемы
+// результатов выводятся из типов ответа, поэтому не расходятся с кодом.
+func buildOpenAPI(routes []route) obj {
+	paths := obj{}
+	for _, rt := range routes {
+		op := obj{
+			"summary":     rt.summary,
+			"operationId": strings.Trim(strings.NewReplacer("/", "", ".", "_").Replace(rt.pattern), "_"),
+			"responses":   openAPIResponses(rt),
+		}
+		if rt.public {
+			op["security"] = []obj{}
+		}
+		if rt.method == http.MethodGet || rt.query {
+			var params []obj
+			for _, p := range rt.params {
+				param := obj{
+					"name":        p.name,
+					"in":          "query",
+					"required":    p.required,
+					"description": p.desc,
+					"schema":      p.schema(),
+				}
+				if p.list {
+					// значения через запятую; повторение параметра тоже допустимо
+					param["explode"] = false
+				}
+				params = append(params, param)
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+		}
+		switch {
+		case rt.request != nil:
+			op["requestBody"] = obj{
+				"required": true,
+				"content":  obj{jsonType: obj{"schema": schemaOf(reflect.TypeOf(rt.request))}},
+			}
+		case rt.body != "":
+			op["requestBody"] = obj{
+				"required": true,
+				"content":  obj{rt.body: obj{"schema": obj{"type": "string"}}},
+			}
+		case rt.method == http.MethodPost:
+			props := obj{}
+			var required []string
+			for _, p := range rt.params {
+				props[p.name] = p.bodySchema()
+				if p.required {
+					required = append(required, p.name)
+				}
+			}
+			schema := obj{"type": "object", "properties": props}
+			if required != nil {
+				schema["required"] = required
+			}
+			op["requestBody"] = obj{
+				"required": true,
+				"content": obj{
+					formType: obj{"schema": schema},
+					jsonType: obj{"schema": schema},
+				},
+			}
+		}
+		paths[rt.pattern] = obj{strings.ToLower(rt.method): op}
+	}
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":   "dev11 calendar",
+			"version": "1.0",
+			"description": "Successful calls return {\"result\": ...}. Invalid input is answered with 400, " +
+				"business rule violations with 503 and other failures with 500, all as {\"error\": \"...\"}. " +
+				"POST parameters are accepted as a form or as a JSON object; list values may be JSON arrays. " +
+				"When the server has API keys or a token secret configured, requests must carry " +
+				"an API key or a signed token; user_id then defaults to the authenticated user, and other " +
+				"users' calendars are reachable only when shared with read or write access. " +
+				"Saved events carry a version, also sent as ETag \"v<version>\"; send it back in If-Match " +
+				"(or version) to update or delete only the version you have read. " +
+				"A POST with an Idempotency-Key header is executed once: a retry with the same key and request " +
+				"gets the original response with Idempotent-Replayed: true.",
+		},
+		"paths":    paths,
+		"security": []obj{{"bearer": []string{}}, {"apiKey": []string{}}},
+		"components": obj{
+			"securitySchemes": obj{
+				"bearer": obj{"type": "http", "scheme": "bearer", "bearerFormat": "JWT or API key"},
+				"apiKey": obj{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+			"schemas": obj{
+				"Error": obj{
+					"type":       "object",
+					"properties": obj{"error": obj{"type": "string"}},
+					"required":   []string{"error"},
+				},
+			},
+		},
+	}
+}
+
+func openAPIResponses(rt route) obj {
+	errorRef := obj{"$ref": "#/components/schemas/Error"}
+	errorResponse := func(desc string) obj {
+		return obj{"description": desc, "content": obj{jsonType: obj{"schema": errorRef}}}
+	}
+	ok := obj{"description": "OK"}
+	switch {
+	case rt.result != nil:
+		schema := obj{
+			"type":       "object",
+			"properties": obj{"result": schemaOf(reflect.TypeOf(rt.result))},
+			"required":   []string{"result"},
+		}
+		content := obj{}
+		for _, typ := range rt.produces {
+			content[typ] = obj{"schema": obj{"type": "string"}}
+		}
+		content[jsonType] = obj{"schema": schema}
+		ok["content"] = content
+	case len(rt.produces) > 0:
+		ok["content"] = obj{rt.produces[0]: obj{"schema": obj{"type": "string"}}}
+	default:
+		ok["content"] = obj{jsonType: obj{"schema": obj{"type": "object"}}}
+	}
+	return obj{
+		"200": ok,
+		"400": errorResponse("Invalid input."),
+		"405": errorResponse("Wrong HTTP method."),
+		"401": errorResponse("Missing or invalid credentials."),
+		"403": errorResponse("No access to the calendar or the event belongs to another user."),
+		"406": errorResponse("None of the response types is acceptable."),
+		"409": errorResponse("A request with the same Idempotency-Key is still in progress."),
+		"412": errorResponse("The event has changed since the version in If-Match or version."),
+		"422": errorResponse("The Idempotency-Key was already used with a different request."),
+		"429": errorResponse("Rate limit exceeded; retry after Retry-After seconds."),
+		"500": errorResponse("Internal error."),
+		"503": errorResponse("Business rule violation, e.g. the event does not exist."),
+	}
+}
+
+// schema возвращает схему параметра в query string.
+func (p apiParam) schema() obj {
+	s := obj{"type": p.typ}
+	if p.format != "" {
+		s["format"] = p.format
+	}
+	if p.enum != nil {
+		s["enum"] = p.enum
+	}
+	if p.list {
+		return obj{"type": "array", "items": s}
+	}
+	return s
+}
+
+// bodySchema возвращает схему поля тела: в JSON списки передаются массивом
+// или строкой через запятую.
+func (p apiParam) bodySchema() obj {
+	s := p.schema()
+	if p.list {
+		s = obj{"oneOf": []obj{s, {"type": "string"}}}
+	}
+	s["description"] = p.desc
+	return s
+}
+
+// textMarshaler — типы, которые JSON записывает строкой.
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// rawJSON — тип произвольного значения JSON.
+var rawJSON = reflect.TypeOf(json.RawMessage(nil))
+
+// schemaOf выводит схему JSON из типа Go по тегам json.
+func schemaOf(t reflect.Type) obj {
+	if t == rawJSON {
+		return obj{}
+	}
+	if t.Kind() != reflect.Struct && t.Implements(textMarshaler) {
+		return obj{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return obj{"type": "string"}
+	case reflect.Bool:
+		return obj{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return obj{"type": "integer"}
+	case reflect.Float64:
+		return obj{"type": "number"}
+	case reflect.Slice:
+		return obj{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return obj{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := obj{}
+		var required []string
+		addFields(t, props, &required)
+		s := obj{"type": "object", "properties": props}
+		if required != nil {
+			s["required"] = required
+		}
+		return s
+	default:
+		return obj{}
+	}
+}
+
+// addFields добавляет в props поля структуры t, раскрывая встроенные.
+// Поля без omitempty считаются обязательными.
+func addFields(t reflect.Type, props obj, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			addFields(f.Type, props, required)
+			continue
+		}
+		tag := f.Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rpcVersion — версия протокола JSON-RPC.
+const rpcVersion = "2.0"
+
+// Коды ошибок JSON-RPC: стандартные и, начиная с -32000, ошибки
+// бизнес-логики, соответствующие кодам ответа HTTP API.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcDomainError    = -32000
+	rpcAccessError    = -32003
+	rpcVersionError   = -32004
+)
+
+// rpcRequest — вызов JSON-RPC.
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	// Params — объект с параметрами одноимённого метода HTTP API.
+	Params json.RawMessage `json:"params,omitempty"`
+	// ID нет у уведомлений: на них не отвечают.
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcResponse — ответ на вызов JSON-RPC: result или error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError — ошибка вызова JSON-RPC. Data.Status — код, которым на ту же
+// ошибку ответил бы HTTP API.
+type rpcError struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Data    *rpcErrorData `json:"data,omitempty"`
+}
+
+type rpcErrorData struct {
+	Status int `json:"status"`
+}
+
+// rpcMethods — методы, доступные через JSON-RPC.
+var rpcMethods = []string{"create_event", "update_event", "delete_event", "events_for_day", "events_for_week", "events_for_month"}
+
+// errRPCMethod — вызван метод не из rpcMethods.
+var errRPCMethod = errors.New("method not found, use " + strings.Join(rpcMethods, ", "))
+
+// newRPCError сопоставляет ошибке метода код JSON-RPC по тому же виду
+// ошибки, по которому HTTP API выбирает код ответа.
+func newRPCError(err error) *rpcError {
+	if errors.Is(err, errRPCMethod) {
+		return &rpcError{Code: rpcMethodNotFound, Message: err.Error()}
+	}
+	status := errorStatus(err)
+	code := rpcInternalError
+	switch status {
+	case http.StatusBadRequest:
+		code = rpcInvalidParams
+	case http.StatusForbidden:
+		code = rpcAccessError
+	case http.StatusPreconditionFailed:
+		code = rpcVersionError
+	case http.StatusServiceUnavailable:
+		code = rpcDomainError
+	}
+	return &rpcError{Code: code, Message: err.Error(), Data: &rpcErrorData{Status: status}}
+}
+
+// handleRPC выполняет тело JSON-RPC — один вызов или массив вызовов —
+// от имени пользователя из ctx и возвращает ответ. nil — отвечать не на
+// что: в теле одни уведомления.
+func (s *Server) handleRPC(ctx context.Context, body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		return marshalRPC(rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcParseError, Message: "parse error"}})
+	}
+	if body[0] != '[' {
+		resp := s.rpcCall(ctx, body)
+		if resp == nil {
+			return nil
+		}
+		return marshalRPC(resp)
+	}
+	var calls []json.RawMessage
+	json.Unmarshal(body, &calls)
+	if len(calls) == 0 {
+		return marshalRPC(rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcInvalidRequest, Message: "empty batch"}})
+	}
+	var res []*rpcResponse
+	for _, call := range calls {
+		if resp := s.rpcCall(ctx, call); resp != nil {
+			res = append(res, resp)
+		}
+	}
+	if res == nil {
+		return nil
+	}
+	return marshalRPC(res)
+}
+
+func marshalRPC(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// ответ собран из уже сериализованных значений
+		panic(err)
+	}
+	return b
+}
+
+// rpcCall выполняет один вызов. Ответа нет, если вызов — уведомление.
+func (s *Server) rpcCall(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != rpcVersion || req.Method == "" || !validRPCID(req.ID) {
+		return &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}}
+	}
+	result, err := s.rpcMethod(ctx, req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	resp := &rpcResponse{JSONRPC: rpcVersion, ID: req.ID}
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		resp.Result, resp.Error = nil, newRPCError(err)
+	}
+	return resp
+}
+
+// validRPCID проверяет, что id вызова — строка, число, null или отсутствует.
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// rpcMethod выполняет метод с параметрами raw через те же операции
+// и возвращает тот же result, что и одноимённый метод HTTP API.
+func (s *Server) rpcMethod(ctx context.Context, method string, raw json.RawMessage) (interface{}, error) {
+	known := false
+	for _, m := range rpcMethods {
+		known = known || m == method
+	}
+	if !known {
+		return nil, errRPCMethod
+	}
+	params := url.Values{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if raw[0] != '{' {
+			return nil, &inputError{param: "params", msg: "must be an object"}
+		}
+		var err error
+		if params, err = jsonParams(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+	}
+	events := func(query func(int64, time.Time) ([]Event, error)) (interface{}, error) {
+		list, err := s.eventsOp(ctx, params, query)
+		if err != nil {
+			return nil, err
+		}
+		return newEventsJSON(list), nil
+	}
+	switch method {
+	case "create_event":
+		e, conflicts, err := s.createOp(ctx, s.cal, params)
+		if err != nil {
+			return nil, err
+		}
+		return newSavedEventJSON(e, conflicts), nil
+	case "update_event":
+		e, conflicts, err := s.updateOp(ctx, s.cal, params)
+		if err != nil {
+			return nil, err
+		}
+		return newSavedEventJSON(e, conflicts), nil
+	case "delete_event":
+		if err := s.deleteOp(ctx, s.cal, params); err != nil {
+			return nil, err
+		}
+		return "event deleted", nil
+	case "events_for_day":
+		return events(s.cal.EventsForDay)
+	case "events_for_week":
+		return events(s.cal.EventsForWeek)
+	default:
+		return events(s.cal.EventsForMonth)
+	}
+}
+
+// rpc принимает вызовы JSON-RPC 2.0 в теле POST /rpc. Ошибки вызовов
+// передаются в ответе JSON-RPC с кодом 200; на одни уведомления
+// отвечает 204.
+func (s *Server) rpc(w http.ResponseWriter, r *http.Request) {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != jsonType {
+		writeError(w, &statusError{status: http.StatusUnsupportedMediaType, msg: "rpc body must be " + jsonType})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	if err != nil {
+		writeError(w, &inputError{param: "body", msg: err.Error()})
+		return
+	}
+	resp := s.handleRPC(r.Context(), body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(append(resp, '\n'))
+}
+
+// ServeRPC принимает соединения l и выполняет вызовы JSON-RPC, по одному
+// телу на строку; ответ — тоже строка, на одни уведомления ответа нет.
+// Вызовы не аутентифицируются: доступ ограничивают права на сокет.
+// ServeRPC работает, пока l не закрыт, и дожидается открытых соединений;
+// их закрывает CloseStreams.
+func (s *Server) ServeRPC(l net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveRPCConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveRPCConn(conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.streamsDone:
+		case <-done:
+		}
+		conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, 4096), maxJSONBody)
+	w := bufio.NewWriter(conn)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		start := time.Now()
+		resp := s.handleRPC(context.Background(), line)
+		slog.Info("rpc request", "transport", "unix",
+			"latency_ms", float64(time.Since(start).Microseconds())/1000)
+		if resp == nil {
+			continue
+		}
+		w.Write(append(resp, '\n'))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		msg := fmt.Sprintf("request longer than %d bytes", maxJSONBody)
+		w.Write(append(marshalRPC(rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcInvalidRequest, Message: msg}}), '\n'))
+		w.Flush()
+	}
+}
+
+// listenRPC открывает Unix сокет path для JSON-RPC, доступный только
+// владельцу процесса. Сокет, оставшийся от упавшего сервера, удаляется;
+// занятый работающим сервером — ошибка.
+func listenRPC(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("rpc socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SearchQuery — условия поиска событий в календаре UserID, включая
+// приглашения, от которых он не отказался. Все заданные
+// условия должны выполняться одновременно. Слова Text ищутся в названии
+// и описании по началу слова: retro находит retrospective.
+type SearchQuery struct {
+	UserID       int64
+	Text         string
+	Tags         []string
+	Participants []int64
+	// From и To ограничивают поиск повторениями, пересекающимися с
+	// [From, To); без них возвращаются события и серии целиком.
+	From, To time.Time
+	// Limit — наибольшее число результатов, 0 — без ограничения.
+	Limit int
+}
+
+// Search находит события по индексу, не перебирая все события.
+func (c *Calendar) Search(q SearchQuery) ([]Event, error) {
+	ids, err := c.index.lookup(q, c.store.All)
+	if err != nil {
+		return nil, err
+	}
+	var res []Event
+	for _, id := range ids {
+		e, err := c.store.Get(id)
+		if err == ErrEventNotFound {
+			// удалено после поиска по индексу
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !e.attends(q.UserID) {
+			continue
+		}
+		if q.From.IsZero() {
+			res = append(res, e)
+			continue
+		}
+		res = append(res, e.expand(q.From, q.To)...)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Start.Before(res[j].Start)
+	})
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+// tokenize разбивает текст на слова в нижнем регистре.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// idSet — множество ID событий.
+type idSet map[int64]struct{}
+
+// indexDoc — ключи, под которыми событие записано в индекс.
+type indexDoc struct {
+	terms []string
+	keys  []string
+}
+
+// Ключи индекса для метки и участника (в том числе организатора) события.
+func tagKey(tag string) string  { return "tag:" + tag }
+func personKey(id int64) string { return "person:" + strconv.FormatInt(id, 10) }
+
+// searchIndex — инвертированный индекс событий в памяти: слова названия
+// и описания, а также владелец, метки и участники отображаются в множество
+// ID событий. Индекс строится из хранилища при первом поиске и затем
+// обновляется при каждом изменении события.
+type searchIndex struct {
+	mu    sync.Mutex
+	built bool
+	docs  map[int64]indexDoc
+	terms map[string]idSet
+	keys  map[string]idSet
+	// sorted — слова по алфавиту для поиска по префиксу, nil если устарел.
+	sorted []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:  make(map[int64]indexDoc),
+		terms: make(map[string]idSet),
+		keys:  make(map[string]idSet),
+	}
+}
+
+func addPosting(m map[string]idSet, key string, id int64) {
+	set, ok := m[key]
+	if !ok {
+		set = make(idSet)
+		m[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+// removePosting удаляет событие из множества key и сообщает, опустело ли оно.
+func removePosting(m map[string]idSet, key string, id int64) bool {
+	set := m[key]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(m, key)
+		return true
+	}
+	return false
+}
+
+// put записывает событие в индекс, заменяя прежнюю запись. До построения
+// индекса ничего не делает: событие попадёт в него из хранилища.
+func (x *searchIndex) put(e Event) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.built {
+		x.putLocked(e)
+	}
+}
+
+func (x *searchIndex) putLocked(e Event) {
+	x.removeLocked(e.ID)
+	doc := indexDoc{terms: tokenize(e.Title + " " + e.Description)}
+	for _, tag := range e.Tags {
+		doc.keys = append(doc.keys, tagKey(tag))
+	}
+	for _, p := range e.participants() {
+		doc.keys = append(doc.keys, personKey(p))
+	}
+	x.docs[e.ID] = doc
+	for _, t := range doc.terms {
+		if _, ok := x.terms[t]; !ok {
+			x.sorted = nil
+		}
+		addPosting(x.terms, t, e.ID)
+	}
+	for _, k := range doc.keys {
+		addPosting(x.keys, k, e.ID)
+	}
+}
+
+// remove удаляет событие из индекса.
+func (x *searchIndex) remove(id int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *searchIndex) removeLocked(id int64) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	delete(x.docs, id)
+	for _, t := range doc.terms {
+		if removePosting(x.terms, t, id) {
+			x.sorted = nil
+		}
+	}
+	for _, k := range doc.keys {
+		removePosting(x.keys, k, id)
+	}
+}
+
+// buildLocked при первом вызове строит индекс из событий load.
+func (x *searchIndex) buildLocked(load func() ([]Event, error)) error {
+	if x.built {
+		return nil
+	}
+	events, err := load()
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		x.putLocked(e)
+	}
+	x.built = true
+	return nil
+}
+
+// participating возвращает ID событий, где userID организатор или участник.
+func (x *searchIndex) participating(userID int64, load func() ([]Event, error)) ([]int64, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.buildLocked(load); err != nil {
+		return nil, err
+	}
+	return sortedIDs(x.keys[personKey(userID)]), nil
+}
+
+func sortedIDs(set idSet) []int64 {
+	ids := make([]int64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// lookup возвращает ID событий, подходящих под q без учёта дат: событий
+// пользователя и тех, на которые он приглашён.
+func (x *searchIndex) lookup(q SearchQuery, load func() ([]Event, error)) ([]int64, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.buildLocked(load); err != nil {
+		return nil, err
+	}
+	sets := []idSet{x.keys[personKey(q.UserID)]}
+	for _, word := range tokenize(q.Text) {
+		sets = append(sets, x.prefixLocked(word))
+	}
+	for _, tag := range normalizeTags(q.Tags) {
+		sets = append(sets, x.keys[tagKey(tag)])
+	}
+	for _, p := range q.Participants {
+		sets = append(sets, x.keys[personKey(p)])
+	}
+	// пересечение начинается с наименьшего множества
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	found := make(idSet)
+	for id := range sets[0] {
+		all := true
+		for _, set := range sets[1:] {
+			if _, ok := set[id]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			found[id] = struct{}{}
+		}
+	}
+	return sortedIDs(found), nil
+}
+
+// prefixLocked возвращает события со словами, начинающимися с prefix.
+func (x *searchIndex) prefixLocked(prefix string) idSet {
+	if x.sorted == nil {
+		x.sorted = make([]string, 0, len(x.terms))
+		for t := range x.terms {
+			x.sorted = append(x.sorted, t)
+		}
+		sort.Strings(x.sorted)
+	}
+	res := make(idSet)
+	for i := sort.SearchStrings(x.sorted, prefix); i < len(x.sorted) && strings.HasPrefix(x.sorted[i], prefix); i++ {
+		for id := range x.terms[x.sorted[i]] {
+			res[id] = struct{}{}
+		}
+	}
+	return res
+}
+
+// Ограничения числа результатов /search.
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// search ищет события календаря по тексту q, меткам tag, участникам
+// participant и датам from–to включительно в зоне tz или пользователя.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	userID, err := s.requestUser(r, params, AccessRead)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q := SearchQuery{UserID: userID, Text: params.Get("q"), Tags: parseTags(params, "tag"), Limit: defaultSearchLimit}
+	if params.Get("participant") != "" {
+		if q.Participants, err = parseIDs(params, "participant"); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			writeError(w, &inputError{param: "limit", msg: "must be between 1 and " + strconv.Itoa(maxSearchLimit)})
+			return
+		}
+		q.Limit = n
+	}
+	if params.Get("from") != "" || params.Get("to") != "" {
+		from, err := parseDate(params, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := parseDate(params, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if to.Before(from) {
+			writeError(w, &inputError{param: "to", msg: "must not be before from"})
+			return
+		}
+		loc, err := s.requestLocation(params, userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		q.From, q.To = midnight(from, loc), midnight(to.AddDate(0, 0, 1), loc)
+	}
+	events, err := s.cal.Search(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, newEventsJSON(events))
+}
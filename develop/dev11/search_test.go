@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	cal := NewCalendar(NewMemoryStore())
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC)
+	}
+	invite := Event{UserID: 2, Title: "Quarterly planning", Description: "Roadmap and budget",
+		Start: at(20, 10), End: at(20, 12), Tags: []string{"Q4"}}
+	invite.setAttendees([]int64{1})
+	declined := Event{UserID: 3, Title: "Planning poker", Start: at(21, 10), End: at(21, 11)}
+	declined.setAttendees([]int64{1})
+	events := []Event{
+		{UserID: 1, Title: "Sprint retrospective", Start: at(19, 15), End: at(19, 16), Tags: []string{"team"}},
+		{UserID: 1, Title: "Standup", Description: "Daily sync", Start: at(19, 9), End: at(19, 10),
+			Tags: []string{"team"}, Rule: &Rule{Freq: Daily, Interval: 1, Count: 5}},
+		invite,
+		declined,
+		{UserID: 2, Title: "Private retro", Start: at(19, 15), End: at(19, 16)},
+	}
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		created, _, err := cal.CreateEvent(e, AllowConflicts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = created.ID
+	}
+	if _, err := cal.RespondEvent(1, ids[3], RSVPDeclined); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		q    SearchQuery
+		want []int64
+	}{
+		{"word prefix", SearchQuery{UserID: 1, Text: "RETRO"}, []int64{ids[0]}},
+		{"description", SearchQuery{UserID: 1, Text: "roadmap"}, []int64{ids[2]}},
+		{"declined invitation", SearchQuery{UserID: 1, Text: "planning"}, []int64{ids[2]}},
+		{"tag", SearchQuery{UserID: 1, Tags: []string{"team"}}, []int64{ids[1], ids[0]}},
+		{"tag and text", SearchQuery{UserID: 1, Text: "daily", Tags: []string{"team"}}, []int64{ids[1]}},
+		{"participant", SearchQuery{UserID: 1, Participants: []int64{2}}, []int64{ids[2]}},
+		{"other user", SearchQuery{UserID: 2, Text: "retro"}, []int64{ids[4]}},
+		{"no match", SearchQuery{UserID: 1, Text: "lunch"}, nil},
+		// повторения серии в окне, по началу
+		{"window", SearchQuery{UserID: 1, Tags: []string{"team"}, From: at(20, 0), To: at(22, 0)}, []int64{ids[1], ids[1]}},
+		{"limit", SearchQuery{UserID: 1, Tags: []string{"team"}, From: at(19, 0), To: at(24, 0), Limit: 3}, []int64{ids[1], ids[0], ids[1]}},
+	} {
+		found, err := cal.Search(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, e := range found {
+			got = append(got, e.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// индекс следует за изменениями и удалением
+	title := "Sprint review"
+	if _, _, err := cal.UpdateEvent(1, ids[0], EventUpdate{Title: &title}, AllowConflicts); err != nil {
+		t.Fatal(err)
+	}
+	if err := cal.DeleteEvent(1, ids[1], 0); err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]int{"retro": 0, "review": 1, "standup": 0} {
+		if found, _ := cal.Search(SearchQuery{UserID: 1, Text: text}); len(found) != want {
+			t.Errorf("%q after changes: %d found, want %d", text, len(found), want)
+		}
+	}
+}
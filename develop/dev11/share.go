@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AccessLevel — уровень доступа пользователя к календарю.
+type AccessLevel int
+
+// Уровни доступа по возрастанию. Владелец имеет полный доступ к своему
+// календарю, другим пользователям можно открыть чтение или запись.
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessWrite
+	AccessOwner
+)
+
+var accessNames = map[AccessLevel]string{
+	AccessNone:  "none",
+	AccessRead:  "read",
+	AccessWrite: "write",
+	AccessOwner: "owner",
+}
+
+// ParseAccessLevel разбирает уровень доступа, который можно выдать:
+// none, read или write.
+func ParseAccessLevel(s string) (AccessLevel, error) {
+	switch s {
+	case "none":
+		return AccessNone, nil
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	default:
+		return 0, fmt.Errorf("unknown access level %q", s)
+	}
+}
+
+func (l AccessLevel) String() string {
+	return accessNames[l]
+}
+
+// MarshalText записывает уровень доступа по имени.
+func (l AccessLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText читает уровень доступа по имени.
+func (l *AccessLevel) UnmarshalText(text []byte) error {
+	v, err := ParseAccessLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// Share — доступ пользователя к чужому календарю.
+type Share struct {
+	Grantee int64
+	Level   AccessLevel
+}
+
+// Access возвращает уровень доступа пользователя actor к календарю owner.
+func (c *Calendar) Access(actor, owner int64) (AccessLevel, error) {
+	if actor == owner {
+		return AccessOwner, nil
+	}
+	shares, err := c.store.Shares(owner)
+	if err != nil {
+		return AccessNone, err
+	}
+	return shares[actor], nil
+}
+
+// CheckAccess возвращает ErrForbidden, если у actor меньше чем need
+// доступа к календарю owner.
+func (c *Calendar) CheckAccess(actor, owner int64, need AccessLevel) error {
+	level, err := c.Access(actor, owner)
+	if err != nil {
+		return err
+	}
+	if level < need {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// ShareCalendar открывает пользователю grantee доступ level к календарю
+// owner; AccessNone закрывает ранее выданный доступ.
+func (c *Calendar) ShareCalendar(owner, grantee int64, level AccessLevel) error {
+	if owner == grantee {
+		return fmt.Errorf("%w: cannot share a calendar with its owner", ErrInvalidEvent)
+	}
+	if level > AccessWrite {
+		return fmt.Errorf("%w: only read or write access can be granted", ErrInvalidEvent)
+	}
+	return c.store.SetShare(owner, grantee, level)
+}
+
+// Shares возвращает доступы к календарю owner, упорядоченные по пользователю.
+func (c *Calendar) Shares(owner int64) ([]Share, error) {
+	shares, err := c.store.Shares(owner)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Share, 0, len(shares))
+	for grantee, level := range shares {
+		res = append(res, Share{Grantee: grantee, Level: level})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Grantee < res[j].Grantee
+	})
+	return res, nil
+}
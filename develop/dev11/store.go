@@ -0,0 +1,292 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventStore — хранилище событий, за которым стоит бизнес-логика календаря.
+// Реализации должны быть безопасны для конкурентного использования.
+type EventStore interface {
+	// Create сохраняет новое событие и возвращает его с присвоенным ID
+	// и версией 1.
+	Create(e Event) (Event, error)
+	// Update заменяет существующее событие версии e.Version и возвращает
+	// его со следующей версией. ErrEventNotFound если события нет,
+	// ErrVersionConflict если сохранена другая версия.
+	Update(e Event) (Event, error)
+	// Delete удаляет событие, ErrEventNotFound если его нет.
+	Delete(id int64) error
+	// Restore снова сохраняет удалённое событие под прежним ID и возвращает
+	// его со следующей версией. ErrEventExists если событие с этим ID есть,
+	// ErrEventNotFound если такой ID не выдавался.
+	Restore(e Event) (Event, error)
+	// Get возвращает событие по ID.
+	Get(id int64) (Event, error)
+	// List возвращает события пользователя, упорядоченные по ID.
+	List(userID int64) ([]Event, error)
+	// All возвращает события всех пользователей, упорядоченные по ID.
+	All() ([]Event, error)
+	// SetUserZone сохраняет часовой пояс пользователя по умолчанию.
+	SetUserZone(userID int64, zone string) error
+	// UserZone возвращает часовой пояс пользователя, пустую строку если он не задан.
+	UserZone(userID int64) (string, error)
+	// SetShare открывает пользователю grantee доступ level к календарю owner,
+	// AccessNone закрывает доступ.
+	SetShare(owner, grantee int64, level AccessLevel) error
+	// Shares возвращает выданные доступы к календарю owner.
+	Shares(owner int64) (map[int64]AccessLevel, error)
+	// SetReminderMark сохраняет момент, до которого напоминания уже разосланы.
+	SetReminderMark(t time.Time) error
+	// ReminderMark возвращает сохранённый момент рассылки напоминаний,
+	// нулевое время если рассылки ещё не было.
+	ReminderMark() (time.Time, error)
+	// AppendAudit дописывает запись в журнал аудита. Записи не изменяются
+	// и не удаляются.
+	AppendAudit(rec AuditRecord) error
+	// Audit возвращает записи аудита события по порядку.
+	Audit(eventID int64) ([]AuditRecord, error)
+	// Close сбрасывает данные на диск и освобождает ресурсы.
+	Close() error
+}
+
+// MemoryStore — хранилище событий в памяти, теряет данные при перезапуске.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	events map[int64]Event
+	zones  map[int64]string
+	shares map[int64]map[int64]AccessLevel
+	audit  map[int64][]AuditRecord
+	mark   time.Time
+	lastID int64
+}
+
+// NewMemoryStore создаёт пустое хранилище в памяти.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events: make(map[int64]Event),
+		zones:  make(map[int64]string),
+		shares: make(map[int64]map[int64]AccessLevel),
+		audit:  make(map[int64][]AuditRecord),
+	}
+}
+
+// Create реализует EventStore.
+func (s *MemoryStore) Create(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	e.ID = s.lastID
+	e.Version = 1
+	s.events[e.ID] = e
+	return e, nil
+}
+
+// Update реализует EventStore.
+func (s *MemoryStore) Update(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.events[e.ID]
+	if !ok {
+		return Event{}, ErrEventNotFound
+	}
+	if old.Version != e.Version {
+		return Event{}, ErrVersionConflict
+	}
+	e.Version++
+	s.events[e.ID] = e
+	return e, nil
+}
+
+// Delete реализует EventStore.
+func (s *MemoryStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.events[id]; !ok {
+		return ErrEventNotFound
+	}
+	delete(s.events, id)
+	return nil
+}
+
+// Restore реализует EventStore.
+func (s *MemoryStore) Restore(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.ID <= 0 || e.ID > s.lastID {
+		return Event{}, ErrEventNotFound
+	}
+	if _, ok := s.events[e.ID]; ok {
+		return Event{}, ErrEventExists
+	}
+	e.Version++
+	s.events[e.ID] = e
+	return e, nil
+}
+
+// Get реализует EventStore.
+func (s *MemoryStore) Get(id int64) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrEventNotFound
+	}
+	return e, nil
+}
+
+// List реализует EventStore.
+func (s *MemoryStore) List(userID int64) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []Event
+	for _, e := range s.events {
+		if e.UserID == userID {
+			res = append(res, e)
+		}
+	}
+	sortByID(res)
+	return res, nil
+}
+
+// All реализует EventStore.
+func (s *MemoryStore) All() ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Event, 0, len(s.events))
+	for _, e := range s.events {
+		res = append(res, e)
+	}
+	sortByID(res)
+	return res, nil
+}
+
+// SetUserZone реализует EventStore.
+func (s *MemoryStore) SetUserZone(userID int64, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if zone == "" {
+		delete(s.zones, userID)
+	} else {
+		s.zones[userID] = zone
+	}
+	return nil
+}
+
+// UserZone реализует EventStore.
+func (s *MemoryStore) UserZone(userID int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.zones[userID], nil
+}
+
+// SetShare реализует EventStore.
+func (s *MemoryStore) SetShare(owner, grantee int64, level AccessLevel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if level == AccessNone {
+		delete(s.shares[owner], grantee)
+		if len(s.shares[owner]) == 0 {
+			delete(s.shares, owner)
+		}
+		return nil
+	}
+	if s.shares[owner] == nil {
+		s.shares[owner] = make(map[int64]AccessLevel)
+	}
+	s.shares[owner][grantee] = level
+	return nil
+}
+
+// Shares реализует EventStore.
+func (s *MemoryStore) Shares(owner int64) (map[int64]AccessLevel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[int64]AccessLevel, len(s.shares[owner]))
+	for grantee, level := range s.shares[owner] {
+		res[grantee] = level
+	}
+	return res, nil
+}
+
+// SetReminderMark реализует EventStore.
+func (s *MemoryStore) SetReminderMark(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mark = t
+	return nil
+}
+
+// ReminderMark реализует EventStore.
+func (s *MemoryStore) ReminderMark() (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mark, nil
+}
+
+// AppendAudit реализует EventStore.
+func (s *MemoryStore) AppendAudit(rec AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit[rec.EventID] = append(s.audit[rec.EventID], rec)
+	return nil
+}
+
+// Audit реализует EventStore.
+func (s *MemoryStore) Audit(eventID int64) ([]AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]AuditRecord(nil), s.audit[eventID]...), nil
+}
+
+// Close реализует EventStore.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// put сохраняет событие с уже известным ID, используется при восстановлении.
+func (s *MemoryStore) put(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.Version == 0 {
+		// записано до появления версий
+		e.Version = 1
+	}
+	s.events[e.ID] = e
+	if e.ID > s.lastID {
+		s.lastID = e.ID
+	}
+}
+
+// peekNextID возвращает ID, который получит следующее созданное событие.
+func (s *MemoryStore) peekNextID() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastID + 1
+}
+
+// snapshot возвращает копию всего состояния хранилища.
+func (s *MemoryStore) snapshot() snapshotData {
+	events, _ := s.All()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	zones := make(map[int64]string, len(s.zones))
+	for id, z := range s.zones {
+		zones[id] = z
+	}
+	shares := make(map[int64]map[int64]AccessLevel, len(s.shares))
+	for owner, grants := range s.shares {
+		shares[owner] = make(map[int64]AccessLevel, len(grants))
+		for grantee, level := range grants {
+			shares[owner][grantee] = level
+		}
+	}
+	return snapshotData{LastID: s.lastID, Events: events, Zones: zones, Shares: shares, ReminderMark: s.mark}
+}
+
+func sortByID(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventStreamType — тип ответа Server-Sent Events.
+const eventStreamType = "text/event-stream"
+
+// streamHeartbeat — как часто в поток пишется комментарий, чтобы прокси
+// и клиент не закрыли простаивающее соединение.
+const streamHeartbeat = 15 * time.Second
+
+// changeJSON — изменение события в потоке /events/stream.
+type changeJSON struct {
+	Kind    ChangeKind `json:"kind"`
+	EventID int64      `json:"event_id"`
+	At      string     `json:"at"`
+	Event   eventJSON  `json:"event"`
+}
+
+// changeID возвращает идентификатор события SSE: эпоха журнала и номер
+// изменения, чтобы после перезапуска сервера старый Last-Event-ID не
+// приняли за номер в новом журнале.
+func changeID(epoch int64, seq uint64) string {
+	return fmt.Sprintf("%d-%d", epoch, seq)
+}
+
+// parseChangeID разбирает Last-Event-ID. ok равно false для идентификатора
+// другого журнала или неверного формата.
+func parseChangeID(id string, epoch int64) (seq uint64, ok bool) {
+	e, s, found := strings.Cut(id, "-")
+	if !found || e != strconv.FormatInt(epoch, 10) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	return seq, err == nil
+}
+
+// writeSSE записывает одно событие SSE.
+func writeSSE(w io.Writer, id, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// eventStream передаёт изменения событий календаря user_id в виде
+// Server-Sent Events: created, updated и deleted с событием в data.
+// С заголовком Last-Event-ID (или параметром last_event_id) сначала
+// досылаются пропущенные изменения; если их уже нет в журнале, приходит
+// событие reset, и клиенту нужно перечитать календарь целиком.
+func (s *Server) eventStream(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	userID, err := s.requestUser(r, params, AccessRead)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	changes := s.cal.Changes()
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = params.Get("last_event_id")
+	}
+
+	// подписка до чтения журнала, чтобы не пропустить изменения между ними
+	notify, cancel := changes.Subscribe()
+	defer cancel()
+	last := changes.Last()
+	reset := false
+	if lastID != "" {
+		if seq, ok := parseChangeID(lastID, changes.Epoch); ok {
+			last = seq
+		} else {
+			reset = true
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// поток живёт дольше write_timeout сервера
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, fmt.Errorf("streaming not supported: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+
+	send := func() error {
+		batch, seen, ok := changes.Since(userID, last)
+		last = seen
+		if !ok || reset {
+			reset = false
+			return writeSSE(w, changeID(changes.Epoch, last), "reset", map[string]string{"reason": "missed changes are no longer available, reload the calendar"})
+		}
+		for _, c := range batch {
+			data := changeJSON{Kind: c.Kind, EventID: c.Event.ID, At: c.At.Format(time.RFC3339Nano), Event: newEventJSON(c.Event)}
+			if err := writeSSE(w, changeID(changes.Epoch, c.Seq), string(c.Kind), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		if err := send(); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case <-notify:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// CloseStreams завершает открытые потоки /events/stream и соединения
+// JSON-RPC, чтобы они не задерживали остановку сервера.
+func (s *Server) CloseStreams() {
+	s.closeStreams.Do(func() { close(s.streamsDone) })
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent — событие Server-Sent Events.
+type sseEvent struct {
+	id, event, data string
+}
+
+// openStream подключается к /events/stream пользователя 1 с Last-Event-ID
+// lastID (пустой — без него) и возвращает чтение событий потока.
+func openStream(t *testing.T, env *testEnv, lastID string) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, env.url+"/events/stream?user_id=1", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	lines := bufio.NewScanner(resp.Body)
+	return func() sseEvent {
+		t.Helper()
+		var e sseEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case line == "" && e.event != "":
+				return e
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return e
+	}
+}
+
+func TestEventStreamResume(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	first := env.create(form("user_id", "1", "title", "First", "date", "2026-10-19"))
+	next := openStream(t, env, "")
+	second := env.create(form("user_id", "1", "title", "Second", "date", "2026-10-19"))
+	seen := next()
+	if seen.event != string(ChangeCreated) || !strings.Contains(seen.data, `"title":"Second"`) {
+		t.Fatalf("live event %+v", seen)
+	}
+
+	// пока клиент отключён, событие меняется и удаляется
+	env.post("/update_event", form("user_id", "1", "id", strconv.FormatInt(first.ID, 10), "title", "First (moved)")).expect(t, http.StatusOK)
+	env.post("/delete_event", form("user_id", "1", "id", strconv.FormatInt(second.ID, 10))).expect(t, http.StatusOK)
+	env.create(form("user_id", "2", "title", "Not mine", "date", "2026-10-19"))
+
+	next = openStream(t, env, seen.id)
+	var got []string
+	for _, want := range []ChangeKind{ChangeUpdated, ChangeDeleted} {
+		e := next()
+		var c changeJSON
+		if err := json.Unmarshal([]byte(e.data), &c); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.event+"/"+c.Event.Title)
+		if e.event != string(want) {
+			t.Errorf("resumed %v, want updated First and deleted Second", got)
+		}
+	}
+}
+
+func TestEventStreamReset(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	env.create(form("user_id", "1", "title", "First", "date", "2026-10-19"))
+	// идентификатор журнала прошлого запуска
+	if e := openStream(t, env, "1-1")(); e.event != "reset" {
+		t.Errorf("stale epoch: %+v", e)
+	}
+	changes := env.cal.Changes()
+	if e := openStream(t, env, changeID(changes.Epoch, 99))(); e.event != "reset" {
+		t.Errorf("future change: %+v", e)
+	}
+	for i := 0; i < changeLogSize; i++ {
+		changes.publish(ChangeUpdated, Event{ID: 1, UserID: 1})
+	}
+	if e := openStream(t, env, changeID(changes.Epoch, 0))(); e.event != "reset" {
+		t.Errorf("evicted changes: %+v", e)
+	}
+	if _, _, ok := changes.Since(1, 1); !ok {
+		t.Error("the oldest kept change is reported as evicted")
+	}
+}
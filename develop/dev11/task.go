@@ -1,5 +1,19 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
 /*
 === HTTP server ===
 
@@ -23,5 +37,191 @@ package main
 */
 
 func main() {
+	if len(os.Args) > 1 {
+		var command func([]string) error
+		switch os.Args[1] {
+		case "token":
+			command = func(args []string) error { return tokenCommand(args, os.Getenv) }
+		case "load":
+			command = func(args []string) error { return loadCommand(args, os.Stdout, os.Getenv) }
+		}
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	loader, err := newConfigLoader(os.Args[0], os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(loader, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run запускает сервер с настройками cfg и работает до SIGINT или SIGTERM.
+// По SIGHUP настройки перечитываются через loader.
+func run(loader *configLoader, cfg Config) error {
+	// все сообщения, включая стандартный log, выводятся в JSON
+	level := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+
+	store, err := openStore(cfg.Storage, cfg.DataDir, cfg.CompactEvery)
+	if err != nil {
+		return err
+	}
+	cal := NewCalendar(store)
+	defer func() {
+		if err := cal.Close(); err != nil {
+			log.Printf("close store: %v", err)
+		}
+	}()
+
+	metrics := NewRegistry()
+	reminders := NewDispatcher(cal, systemClock{}, cfg.RemindLate)
+	server := NewServer(cal, AllowConflicts)
+	limiter := NewRateLimiter(systemClock{}, metrics)
+	if err := applyConfig(cfg, level, server, reminders, limiter); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	go reminders.Run(reminderInterval, stop)
+	defer func() {
+		close(stop)
+		if err := reminders.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      newHandler(server, metrics, limiter, logger),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(server.CloseStreams)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+	if cfg.RPCSocket != "" {
+		l, err := listenRPC(cfg.RPCSocket)
+		if err != nil {
+			return err
+		}
+		rpcDone := make(chan error, 1)
+		go func() {
+			log.Printf("json-rpc listening on %s", cfg.RPCSocket)
+			rpcDone <- server.ServeRPC(l)
+		}()
+		// соединения закрываются до хранилища, начатые вызовы дорабатывают
+		defer func() {
+			l.Close()
+			server.CloseStreams()
+			if err := <-rpcDone; err != nil {
+				log.Printf("json-rpc: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				cfg = reloadConfig(loader, cfg, level, server, reminders, limiter)
+				continue
+			}
+			log.Printf("received %s, shutting down", sig)
+			// новые соединения не принимаются, начатые запросы дорабатывают
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+			err := srv.Shutdown(ctx)
+			cancel()
+			return err
+		}
+	}
+}
+
+// newHandler собирает методы API, /metrics и middleware в порядке
+// обработки запроса.
+func newHandler(server *Server, metrics *Registry, limiter *RateLimiter, logger *slog.Logger) http.Handler {
+	mux := server.Handler()
+	mux.Handle("/metrics", metrics)
+	return chain(mux, requestID, logRequests(logger), newHTTPMetrics(metrics).instrument(mux),
+		limiter.byAddress(mux), server.Authenticate("/openapi.json", "/metrics"), limiter.byUser(mux),
+		NewIdempotency(systemClock{}).middleware)
+}
+
+// applyConfig применяет настройки, которые можно менять на ходу.
+func applyConfig(cfg Config, level *slog.LevelVar, server *Server, reminders *Dispatcher, limiter *RateLimiter) error {
+	notifiers, err := parseNotifiers(cfg.Notify)
+	if err != nil {
+		return err
+	}
+	// остальные значения уже проверены в Config.validate
+	lvl, _ := parseLogLevel(cfg.LogLevel)
+	policy, _ := ParseConflictPolicy(cfg.Conflict)
+	keys, _ := parseAPIKeys(cfg.APIKeys)
+	limits, _ := parseRateLimits(cfg.RateLimits)
+	level.Set(lvl)
+	server.SetConflictPolicy(policy)
+	server.SetCredentials(keys, []byte(cfg.TokenSecret))
+	limiter.Configure(limits)
+	if len(keys) == 0 && cfg.TokenSecret == "" {
+		slog.Warn("authentication disabled: set api_keys or token_secret")
+	}
+	reminders.Reconfigure(cfg.RemindLate, notifiers...)
+	return nil
+}
+
+// reloadConfig перечитывает настройки и возвращает действующие. При ошибке
+// остаются прежние настройки; изменения порта, таймаутов соединений,
+// хранилища и сокета JSON-RPC вступят в силу только после перезапуска.
+func reloadConfig(loader *configLoader, cur Config, level *slog.LevelVar, server *Server, reminders *Dispatcher, limiter *RateLimiter) Config {
+	next, err := loader.Load()
+	if err != nil {
+		log.Printf("reload config: %v", err)
+		return cur
+	}
+	next, changed := keepStartup(cur, next)
+	if err := applyConfig(next, level, server, reminders, limiter); err != nil {
+		log.Printf("reload config: %v", err)
+		return cur
+	}
+	if len(changed) > 0 {
+		slog.Warn("settings require restart", "keys", changed)
+	}
+	log.Print("config reloaded")
+	return next
+}
+
+// reminderInterval — как часто проверяются сработавшие напоминания.
+const reminderInterval = 5 * time.Second
 
+// openStore создаёт хранилище событий по имени бэкенда.
+func openStore(kind, dir string, compactEvery int) (EventStore, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return NewFileStore(dir, compactEvery)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", kind)
+	}
 }
@@ -1 +1,736 @@
 package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv — сервер календаря со всеми middleware за httptest.Server.
+type testEnv struct {
+	t       *testing.T
+	url     string
+	server  *Server
+	cal     *Calendar
+	limiter *RateLimiter
+}
+
+func newTestEnv(t *testing.T, store EventStore) *testEnv {
+	t.Helper()
+	cal := NewCalendar(store)
+	server := NewServer(cal, AllowConflicts)
+	limiter := NewRateLimiter(systemClock{}, NewRegistry())
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ts := httptest.NewServer(newHandler(server, NewRegistry(), limiter, logger))
+	t.Cleanup(func() {
+		server.CloseStreams()
+		ts.Close()
+	})
+	return &testEnv{t: t, url: ts.URL, server: server, cal: cal, limiter: limiter}
+}
+
+// apiResponse — ответ метода API с телом {"result": ...} или {"error": ...}.
+type apiResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// decode разбирает result в v.
+func (r *apiResponse) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.Result, v); err != nil {
+		t.Fatalf("decode result %s: %v", r.Result, err)
+	}
+}
+
+// do отправляет запрос: params GET метода уходят в query string,
+// POST метода — в тело формы, body (если задан) — как есть.
+func (env *testEnv) do(method, path string, params url.Values, header http.Header, body string) *apiResponse {
+	env.t.Helper()
+	target := env.url + path
+	var reader io.Reader
+	switch {
+	case body != "":
+		reader = strings.NewReader(body)
+		if params != nil {
+			target += "?" + params.Encode()
+		}
+	case method == http.MethodGet:
+		if params != nil {
+			target += "?" + params.Encode()
+		}
+	default:
+		reader = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if body == "" && method != http.MethodGet {
+		req.Header.Set("Content-Type", formType)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	res := &apiResponse{status: resp.StatusCode, header: resp.Header}
+	if res.body, err = io.ReadAll(resp.Body); err != nil {
+		env.t.Fatal(err)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), jsonType) {
+		if err := json.Unmarshal(res.body, res); err != nil {
+			env.t.Fatalf("%s %s: invalid JSON %q: %v", method, path, res.body, err)
+		}
+	}
+	return res
+}
+
+func (env *testEnv) get(path string, params url.Values) *apiResponse {
+	env.t.Helper()
+	return env.do(http.MethodGet, path, params, nil, "")
+}
+
+func (env *testEnv) post(path string, params url.Values) *apiResponse {
+	env.t.Helper()
+	return env.do(http.MethodPost, path, params, nil, "")
+}
+
+// expect проверяет код ответа.
+func (r *apiResponse) expect(t *testing.T, status int) *apiResponse {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("status %d, want %d: %s", r.status, status, r.body)
+	}
+	return r
+}
+
+// create создаёт событие и возвращает его.
+func (env *testEnv) create(params url.Values) eventJSON {
+	env.t.Helper()
+	var e eventJSON
+	env.post("/create_event", params).expect(env.t, http.StatusOK).decode(env.t, &e)
+	return e
+}
+
+func form(pairs ...string) url.Values {
+	v := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		v.Set(pairs[i], pairs[i+1])
+	}
+	return v
+}
+
+func eventIDs(events []eventJSON) []int64 {
+	ids := make([]int64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// failingStore — хранилище, которое не может прочитать или записать события.
+type failingStore struct {
+	*MemoryStore
+}
+
+var errDiskFailure = errors.New("disk failure")
+
+func (failingStore) Create(Event) (Event, error)    { return Event{}, errDiskFailure }
+func (failingStore) List(int64) ([]Event, error)    { return nil, errDiskFailure }
+func (failingStore) All() ([]Event, error)          { return nil, errDiskFailure }
+func (failingStore) Get(int64) (Event, error)       { return Event{}, errDiskFailure }
+func (failingStore) UserZone(int64) (string, error) { return "", nil }
+
+const icsEvent = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:planning@test\r\nDTSTAMP:20261001T000000Z\r\n" +
+	"DTSTART:20261020T090000Z\r\nDTEND:20261020T100000Z\r\nSUMMARY:Planning\r\n" +
+	"END:VEVENT\r\nEND:VCALENDAR\r\n"
+
+// routeContract — успешный вызов метода API и проверка его результата.
+type routeContract struct {
+	method string
+	path   string
+	params url.Values
+	header http.Header
+	body   string
+	check  func(t *testing.T, r *apiResponse)
+}
+
+// contracts возвращает успешные вызовы методов над календарём, в котором
+// у пользователя 1 есть ежедневная серия 1 с 2026-10-19 по 2026-10-23,
+// на которую приглашён пользователь 2.
+func contracts() []routeContract {
+	jsonHeader := http.Header{"Content-Type": {jsonType}}
+	day := form("user_id", "1", "date", "2026-10-19")
+	// standups проверяет, что найдено n повторений ежедневной серии
+	standups := func(n int) func(t *testing.T, r *apiResponse) {
+		return func(t *testing.T, r *apiResponse) {
+			var events []eventJSON
+			r.decode(t, &events)
+			if len(events) != n {
+				t.Fatalf("%d events, want %d", len(events), n)
+			}
+			for _, e := range events {
+				if e.ID != 1 || e.Title != "Standup" {
+					t.Errorf("event %+v, want the standup", e)
+				}
+			}
+		}
+	}
+	return []routeContract{
+		{method: http.MethodPost, path: "/create_event", params: form("user_id", "1", "title", "Retro", "start", "2026-10-20T15:00", "tags", "team"),
+			check: func(t *testing.T, r *apiResponse) {
+				var e savedEventJSON
+				r.decode(t, &e)
+				if e.ID == 0 || e.Version != 1 || r.header.Get("ETag") != `"v1"` {
+					t.Errorf("created %+v with ETag %q", e, r.header.Get("ETag"))
+				}
+			}},
+		{method: http.MethodPost, path: "/update_event", params: form("user_id", "1", "id", "1", "title", "Standup", "description", "daily"),
+			check: func(t *testing.T, r *apiResponse) {
+				var e savedEventJSON
+				r.decode(t, &e)
+				if e.Description != "daily" || e.Version != 2 {
+					t.Errorf("updated %+v", e)
+				}
+			}},
+		{method: http.MethodPost, path: "/delete_event", params: form("user_id", "1", "id", "1", "occurrence", "2026-10-19"),
+			check: func(t *testing.T, r *apiResponse) {
+				var msg string
+				if r.decode(t, &msg); msg != "event deleted" {
+					t.Errorf("result %q", msg)
+				}
+			}},
+		{method: http.MethodPost, path: "/batch", header: jsonHeader,
+			body: `{"operations":[{"op":"create_event","params":{"user_id":1,"title":"A","date":"2026-10-21"}},{"op":"delete_event","params":{"user_id":1,"id":99}}]}`,
+			check: func(t *testing.T, r *apiResponse) {
+				var items []batchItemJSON
+				r.decode(t, &items)
+				if len(items) != 2 || items[0].Status != http.StatusOK || items[1].Status != http.StatusServiceUnavailable {
+					t.Errorf("items %+v", items)
+				}
+			}},
+		{method: http.MethodPost, path: "/rpc", header: jsonHeader,
+			body: `{"jsonrpc":"2.0","method":"events_for_week","params":{"user_id":1,"date":"2026-10-19"},"id":7}`,
+			check: func(t *testing.T, r *apiResponse) {
+				var resp rpcResponse
+				if err := json.Unmarshal(r.body, &resp); err != nil || string(resp.ID) != "7" || resp.Error != nil {
+					t.Fatalf("response %s: %v", r.body, err)
+				}
+				standups(5)(t, r)
+			}},
+		{method: http.MethodGet, path: "/events_for_day", params: day, check: standups(1)},
+		{method: http.MethodGet, path: "/events_for_week", params: day, check: standups(5)},
+		{method: http.MethodGet, path: "/events_for_month", params: day, check: standups(5)},
+		{method: http.MethodGet, path: "/event_history", params: form("user_id", "1", "id", "1"),
+			check: func(t *testing.T, r *apiResponse) {
+				var history []auditJSON
+				r.decode(t, &history)
+				if len(history) != 1 || history[0].Action != ChangeCreated || history[0].Actor != 1 || history[0].Version != 1 {
+					t.Errorf("history %+v", history)
+				}
+			}},
+		{method: http.MethodPost, path: "/restore_event", params: form("user_id", "1", "id", "1", "to_version", "1"),
+			check: func(t *testing.T, r *apiResponse) {
+				var e eventJSON
+				if r.decode(t, &e); e.Version != 2 || e.Title != "Standup" {
+					t.Errorf("restored %+v", e)
+				}
+			}},
+		{method: http.MethodPost, path: "/respond_event", params: form("user_id", "2", "id", "1", "status", "accepted"),
+			check: func(t *testing.T, r *apiResponse) {
+				var e eventJSON
+				r.decode(t, &e)
+				if len(e.Attendees) != 1 || e.Attendees[0].Status != RSVPAccepted {
+					t.Errorf("attendees %+v", e.Attendees)
+				}
+			}},
+		{method: http.MethodGet, path: "/search", params: form("user_id", "1", "q", "stand"), check: standups(1)},
+		{method: http.MethodPost, path: "/set_timezone", params: form("user_id", "1", "tz", "Europe/Moscow"),
+			check: func(t *testing.T, r *apiResponse) {
+				var tz timezoneJSON
+				if r.decode(t, &tz); tz.TZ != "Europe/Moscow" {
+					t.Errorf("tz %+v", tz)
+				}
+			}},
+		{method: http.MethodGet, path: "/free_busy", params: form("user_id", "1,2", "from", "2026-10-19", "to", "2026-10-19"),
+			check: func(t *testing.T, r *apiResponse) {
+				var fb freeBusyJSON
+				if r.decode(t, &fb); len(fb.Busy) != 1 || fb.Busy[0].Start != "2026-10-19T10:00:00Z" {
+					t.Errorf("free/busy %+v", fb)
+				}
+			}},
+		{method: http.MethodGet, path: "/export.ics", params: form("user_id", "1"),
+			check: func(t *testing.T, r *apiResponse) {
+				if !strings.Contains(string(r.body), "SUMMARY:Standup") {
+					t.Errorf("export %s", r.body)
+				}
+			}},
+		{method: http.MethodPost, path: "/import", params: form("user_id", "1"), header: http.Header{"Content-Type": {calendarType}}, body: icsEvent,
+			check: func(t *testing.T, r *apiResponse) {
+				var res importJSON
+				if r.decode(t, &res); res.Created != 1 {
+					t.Errorf("import %+v", res)
+				}
+			}},
+		{method: http.MethodPost, path: "/share", params: form("user_id", "1", "grantee", "3", "access", "read"),
+			check: func(t *testing.T, r *apiResponse) {
+				var sh shareJSON
+				if r.decode(t, &sh); sh.Grantee != 3 || sh.Access != AccessRead {
+					t.Errorf("share %+v", sh)
+				}
+			}},
+		{method: http.MethodGet, path: "/shares", params: form("user_id", "1"),
+			check: func(t *testing.T, r *apiResponse) {
+				var grants []grantJSON
+				r.decode(t, &grants)
+				if grants == nil {
+					t.Error("shares result is not a list")
+				}
+			}},
+		{method: http.MethodGet, path: "/openapi.json",
+			check: func(t *testing.T, r *apiResponse) {
+				var doc struct {
+					Paths map[string]interface{} `json:"paths"`
+				}
+				if err := json.Unmarshal(r.body, &doc); err != nil || len(doc.Paths) == 0 {
+					t.Errorf("openapi %s: %v", r.body, err)
+				}
+			}},
+	}
+}
+
+// streamedRoutes проверяются отдельными тестами: ответ не укладывается
+// в один вызов.
+var streamedRoutes = map[string]string{"/events/stream": "TestEventStream"}
+
+// TestRouteContracts вызывает каждый метод API через все middleware и
+// проверяет код ответа, конверт {"result": ...} и сам результат.
+func TestRouteContracts(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, c := range contracts() {
+		covered[c.path] = true
+		t.Run(strings.TrimPrefix(c.path, "/"), func(t *testing.T) {
+			env := newTestEnv(t, NewMemoryStore())
+			env.create(form("user_id", "1", "title", "Standup", "start", "2026-10-19T10:00",
+				"duration", "15m", "rrule", "FREQ=DAILY;COUNT=5", "attendees", "2"))
+			r := env.do(c.method, c.path, c.params, c.header, c.body).expect(t, http.StatusOK)
+			if strings.HasPrefix(r.header.Get("Content-Type"), jsonType) && c.path != "/openapi.json" && r.Result == nil {
+				t.Fatalf("no result in %s", r.body)
+			}
+			c.check(t, r)
+		})
+	}
+	for _, rt := range NewServer(NewCalendar(NewMemoryStore()), AllowConflicts).routes() {
+		if !covered[rt.pattern] && streamedRoutes[rt.pattern] == "" {
+			t.Errorf("route %s has no contract", rt.pattern)
+		}
+	}
+}
+
+// TestStatusMapping проверяет коды ответов: 400 для неверных входных
+// данных, 503 для ошибок бизнес-логики, 500 для остальных, а также
+// ошибки протокола.
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		params url.Values
+		header http.Header
+		body   string
+		want   int
+	}{
+		{name: "invalid user_id", method: http.MethodGet, path: "/events_for_day", params: form("user_id", "abc", "date", "2026-10-19"), want: http.StatusBadRequest},
+		{name: "missing user_id", method: http.MethodGet, path: "/events_for_week", params: form("date", "2026-10-19"), want: http.StatusBadRequest},
+		{name: "invalid date", method: http.MethodGet, path: "/events_for_month", params: form("user_id", "1", "date", "19.10.2026"), want: http.StatusBadRequest},
+		{name: "missing title", method: http.MethodPost, path: "/create_event", params: form("user_id", "1", "date", "2026-10-19"), want: http.StatusBadRequest},
+		{name: "end before start", method: http.MethodPost, path: "/create_event", params: form("user_id", "1", "title", "x", "start", "2026-10-19T10:00", "end", "2026-10-19T09:00"), want: http.StatusBadRequest},
+		{name: "invalid id", method: http.MethodPost, path: "/update_event", params: form("user_id", "1", "id", "-1", "title", "x"), want: http.StatusBadRequest},
+		{name: "invalid JSON", method: http.MethodPost, path: "/create_event", header: http.Header{"Content-Type": {jsonType}}, body: "{", want: http.StatusBadRequest},
+		{name: "update missing event", method: http.MethodPost, path: "/update_event", params: form("user_id", "1", "id", "42", "title", "x"), want: http.StatusServiceUnavailable},
+		{name: "delete missing event", method: http.MethodPost, path: "/delete_event", params: form("user_id", "1", "id", "42"), want: http.StatusServiceUnavailable},
+		{name: "wrong method", method: http.MethodGet, path: "/create_event", want: http.StatusMethodNotAllowed},
+		{name: "unsupported body", method: http.MethodPost, path: "/create_event", header: http.Header{"Content-Type": {"text/xml"}}, body: "<e/>", want: http.StatusUnsupportedMediaType},
+		{name: "not acceptable", method: http.MethodGet, path: "/events_for_day", params: form("user_id", "1", "date", "2026-10-19"), header: http.Header{"Accept": {"text/html"}}, want: http.StatusNotAcceptable},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", want: http.StatusNotFound},
+	}
+	env := newTestEnv(t, NewMemoryStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := env.do(tt.method, tt.path, tt.params, tt.header, tt.body).expect(t, tt.want)
+			if tt.want != http.StatusNotFound && r.Error == "" {
+				t.Errorf("no error message in %s", r.body)
+			}
+		})
+	}
+
+	t.Run("store failure", func(t *testing.T) {
+		env := newTestEnv(t, failingStore{NewMemoryStore()})
+		for _, r := range []*apiResponse{
+			env.post("/create_event", form("user_id", "1", "title", "x", "date", "2026-10-19")),
+			env.get("/events_for_day", form("user_id", "1", "date", "2026-10-19")),
+		} {
+			r.expect(t, http.StatusInternalServerError)
+			if r.Error != errDiskFailure.Error() {
+				t.Errorf("error %q", r.Error)
+			}
+		}
+	})
+}
+
+func TestEventLifecycle(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	e := env.create(form("user_id", "7", "title", "Dentist", "start", "2026-10-21T16:00", "tz", "Europe/Moscow"))
+	if e.Start != "2026-10-21T16:00:00+03:00" || e.End != "2026-10-21T17:00:00+03:00" {
+		t.Fatalf("created %+v", e)
+	}
+
+	// JSON тело принимается наравне с формой
+	var moved eventJSON
+	env.do(http.MethodPost, "/update_event", nil, http.Header{"Content-Type": {jsonType}},
+		`{"user_id": 7, "id": `+strconv.FormatInt(e.ID, 10)+`, "date": "2026-10-23"}`).
+		expect(t, http.StatusOK).decode(t, &moved)
+	if moved.Start != "2026-10-23T16:00:00+03:00" {
+		t.Errorf("moved to %s", moved.Start)
+	}
+
+	var week []eventJSON
+	env.get("/events_for_week", form("user_id", "7", "date", "2026-10-19")).expect(t, http.StatusOK).decode(t, &week)
+	if len(week) != 1 || week[0].ID != e.ID {
+		t.Errorf("week %v, want [%d]", eventIDs(week), e.ID)
+	}
+	var other []eventJSON
+	env.get("/events_for_week", form("user_id", "8", "date", "2026-10-19")).expect(t, http.StatusOK).decode(t, &other)
+	if len(other) != 0 {
+		t.Errorf("user 8 sees %v", eventIDs(other))
+	}
+	env.post("/update_event", form("user_id", "8", "id", strconv.FormatInt(e.ID, 10), "title", "x")).expect(t, http.StatusForbidden)
+
+	env.post("/delete_event", form("user_id", "7", "id", strconv.FormatInt(e.ID, 10))).expect(t, http.StatusOK)
+	env.get("/events_for_week", form("user_id", "7", "date", "2026-10-19")).expect(t, http.StatusOK).decode(t, &week)
+	if len(week) != 0 {
+		t.Errorf("deleted event still listed: %v", eventIDs(week))
+	}
+}
+
+func TestVersionPreconditions(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	e := env.create(form("user_id", "1", "title", "Review", "date", "2026-10-19"))
+	id := strconv.FormatInt(e.ID, 10)
+	ifMatch := func(tag string) http.Header { return http.Header{"If-Match": {tag}} }
+
+	r := env.do(http.MethodPost, "/update_event", form("user_id", "1", "id", id, "title", "Review 2"), ifMatch(`"v1"`), "").expect(t, http.StatusOK)
+	if etag := r.header.Get("ETag"); etag != `"v2"` {
+		t.Errorf("ETag %q, want \"v2\"", etag)
+	}
+	env.do(http.MethodPost, "/update_event", form("user_id", "1", "id", id, "title", "stale"), ifMatch(`"v1"`), "").expect(t, http.StatusPreconditionFailed)
+	env.do(http.MethodPost, "/update_event", form("user_id", "1", "id", id, "title", "bad"), ifMatch(`abc`), "").expect(t, http.StatusPreconditionFailed)
+	env.post("/delete_event", form("user_id", "1", "id", id, "version", "1")).expect(t, http.StatusPreconditionFailed)
+	env.do(http.MethodPost, "/update_event", form("user_id", "1", "id", id, "title", "any"), ifMatch("*"), "").expect(t, http.StatusOK)
+	env.post("/delete_event", form("user_id", "1", "id", id, "version", "3")).expect(t, http.StatusOK)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	key := http.Header{idempotencyHeader: {"create-1"}}
+	params := form("user_id", "1", "title", "Once", "date", "2026-10-19")
+
+	first := env.do(http.MethodPost, "/create_event", params, key, "").expect(t, http.StatusOK)
+	again := env.do(http.MethodPost, "/create_event", params, key, "").expect(t, http.StatusOK)
+	if string(again.body) != string(first.body) || again.header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay %s (replayed %q), want %s", again.body, again.header.Get("Idempotent-Replayed"), first.body)
+	}
+	if again.header.Get("ETag") != first.header.Get("ETag") {
+		t.Errorf("replayed ETag %q, want %q", again.header.Get("ETag"), first.header.Get("ETag"))
+	}
+	var events []eventJSON
+	env.get("/events_for_day", form("user_id", "1", "date", "2026-10-19")).decode(t, &events)
+	if len(events) != 1 {
+		t.Errorf("%d events created, want 1", len(events))
+	}
+	params.Set("title", "Other")
+	env.do(http.MethodPost, "/create_event", params, key, "").expect(t, http.StatusUnprocessableEntity)
+}
+
+func TestBatchAtomic(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	keep := env.create(form("user_id", "1", "title", "Keep", "date", "2026-10-19"))
+	header := http.Header{"Content-Type": {jsonType}}
+	body := `{"atomic": true, "operations": [
+		{"op": "create_event", "params": {"user_id": 1, "title": "New", "date": "2026-10-19"}},
+		{"op": "update_event", "params": {"user_id": 1, "id": ` + strconv.FormatInt(keep.ID, 10) + `, "title": "Changed"}},
+		{"op": "update_event", "params": {"user_id": 1, "id": 999, "title": "Missing"}}
+	]}`
+	r := env.do(http.MethodPost, "/batch", nil, header, body).expect(t, http.StatusServiceUnavailable)
+	if !strings.Contains(r.Error, "operations[2]") {
+		t.Errorf("error %q does not name the failed operation", r.Error)
+	}
+	var events []eventJSON
+	env.get("/events_for_day", form("user_id", "1", "date", "2026-10-19")).decode(t, &events)
+	if len(events) != 1 || events[0].Title != "Keep" {
+		t.Errorf("after rollback %+v, want only Keep", events)
+	}
+}
+
+func TestRestoreDeletedEvent(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	env.server.SetCredentials(map[string]int64{"alice-key": 1, "bob-key": 2}, nil)
+	alice := http.Header{"X-Api-Key": {"alice-key"}}
+	bob := http.Header{"X-Api-Key": {"bob-key"}}
+	var e eventJSON
+	env.do(http.MethodPost, "/create_event", form("title", "Offsite", "date", "2026-10-19"), alice, "").
+		expect(t, http.StatusOK).decode(t, &e)
+	id := strconv.FormatInt(e.ID, 10)
+	env.do(http.MethodPost, "/share", form("grantee", "2", "access", "write"), alice, "").expect(t, http.StatusOK)
+	env.do(http.MethodPost, "/update_event", form("user_id", "1", "id", id, "title", "Offsite (moved)"), bob, "").expect(t, http.StatusOK)
+	env.do(http.MethodPost, "/delete_event", form("user_id", "1", "id", id), bob, "").expect(t, http.StatusOK)
+
+	var history []auditJSON
+	env.do(http.MethodGet, "/event_history", form("id", id), alice, "").expect(t, http.StatusOK).decode(t, &history)
+	var got []string
+	for _, h := range history {
+		got = append(got, string(h.Action)+"/"+strconv.FormatInt(h.Actor, 10)+"/v"+strconv.FormatInt(h.Version, 10))
+	}
+	if want := "created/1/v1 updated/2/v2 deleted/2/v2"; strings.Join(got, " ") != want {
+		t.Errorf("history %v, want %s", got, want)
+	}
+
+	env.do(http.MethodPost, "/restore_event", form("id", id, "to_version", "9"), alice, "").expect(t, http.StatusServiceUnavailable)
+	var restored eventJSON
+	r := env.do(http.MethodPost, "/restore_event", form("id", id, "to_version", "1"), alice, "").expect(t, http.StatusOK)
+	r.decode(t, &restored)
+	if restored.ID != e.ID || restored.Title != "Offsite" || restored.Version != 3 || r.header.Get("ETag") != `"v3"` {
+		t.Errorf("restored %+v with ETag %q", restored, r.header.Get("ETag"))
+	}
+	var day []eventJSON
+	env.do(http.MethodGet, "/events_for_day", form("date", "2026-10-19"), alice, "").decode(t, &day)
+	if len(day) != 1 || day[0].ID != e.ID {
+		t.Errorf("day after restore %v", eventIDs(day))
+	}
+	env.do(http.MethodGet, "/event_history", form("user_id", "2", "id", id), bob, "").expect(t, http.StatusForbidden)
+}
+
+func TestAuthentication(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	secret := []byte("test secret")
+	env.server.SetCredentials(map[string]int64{"alice-key": 1, "bob-key": 2}, secret)
+	alice := http.Header{"X-Api-Key": {"alice-key"}}
+	bob := http.Header{"Authorization": {"Bearer " + SignToken(secret, 2, time.Now(), time.Now().Add(time.Hour))}}
+	day := form("date", "2026-10-19")
+
+	env.get("/events_for_day", day).expect(t, http.StatusUnauthorized)
+	env.do(http.MethodGet, "/events_for_day", day, http.Header{"X-Api-Key": {"wrong"}}, "").expect(t, http.StatusUnauthorized)
+	env.get("/openapi.json", nil).expect(t, http.StatusOK)
+
+	var e eventJSON
+	env.do(http.MethodPost, "/create_event", form("title", "Private", "date", "2026-10-19"), alice, "").
+		expect(t, http.StatusOK).decode(t, &e)
+	if e.UserID != 1 {
+		t.Errorf("created for user %d, want the authenticated user 1", e.UserID)
+	}
+	aliceDay := form("user_id", "1", "date", "2026-10-19")
+	env.do(http.MethodGet, "/events_for_day", aliceDay, bob, "").expect(t, http.StatusForbidden)
+	env.do(http.MethodPost, "/share", form("grantee", "2", "access", "read"), alice, "").expect(t, http.StatusOK)
+	env.do(http.MethodGet, "/events_for_day", aliceDay, bob, "").expect(t, http.StatusOK)
+	env.do(http.MethodPost, "/delete_event", form("user_id", "1", "id", strconv.FormatInt(e.ID, 10)), bob, "").expect(t, http.StatusForbidden)
+}
+
+func TestRateLimit(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	limits, err := parseRateLimits("*=1/m:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.limiter.Configure(limits)
+	day := form("user_id", "1", "date", "2026-10-19")
+	env.get("/events_for_day", day).expect(t, http.StatusOK)
+	r := env.get("/events_for_day", day).expect(t, http.StatusTooManyRequests)
+	if r.header.Get("Retry-After") == "" {
+		t.Error("no Retry-After")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, env.url+"/events/stream?user_id=1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != eventStreamType {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	env.create(form("user_id", "2", "title", "Not mine", "date", "2026-10-19"))
+	mine := env.create(form("user_id", "1", "title", "Mine", "date", "2026-10-19"))
+
+	lines := bufio.NewScanner(resp.Body)
+	var event string
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var c changeJSON
+			if err := json.Unmarshal([]byte(data), &c); err != nil {
+				t.Fatal(err)
+			}
+			if event != string(ChangeCreated) || c.EventID != mine.ID {
+				t.Fatalf("got %s for event %d, want created for %d", event, c.EventID, mine.ID)
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended: %v", lines.Err())
+}
+
+// TestJSONRPC вызывает методы через Unix сокет и /rpc: изменения видны
+// обоим транспортам, ошибки получают коды JSON-RPC.
+func TestJSONRPC(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	l, err := listenRPC(t.TempDir() + "/rpc.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- env.server.ServeRPC(l) }()
+	defer func() {
+		l.Close()
+		env.server.CloseStreams()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	lines := bufio.NewScanner(conn)
+	call := func(line string) string {
+		t.Helper()
+		if _, err := io.WriteString(conn, line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		if !lines.Scan() {
+			t.Fatalf("no response to %s: %v", line, lines.Err())
+		}
+		return lines.Text()
+	}
+
+	var created struct {
+		Result savedEventJSON `json:"result"`
+	}
+	resp := call(`{"jsonrpc":"2.0","method":"create_event","params":{"user_id":1,"title":"Socket","date":"2026-10-19"},"id":"a"}`)
+	if err := json.Unmarshal([]byte(resp), &created); err != nil || created.Result.Version != 1 {
+		t.Fatalf("create: %s", resp)
+	}
+	id := strconv.FormatInt(created.Result.ID, 10)
+	var events []eventJSON
+	env.get("/events_for_day", form("user_id", "1", "date", "2026-10-19")).expect(t, http.StatusOK).decode(t, &events)
+	if len(events) != 1 || events[0].Title != "Socket" {
+		t.Fatalf("HTTP sees %+v", events)
+	}
+
+	// уведомление выполняется без ответа, ответы массива — по порядку вызовов
+	resp = call(`[{"jsonrpc":"2.0","method":"update_event","params":{"user_id":1,"id":` + id + `,"title":"Renamed"}},` +
+		`{"jsonrpc":"2.0","method":"update_event","params":{"user_id":1,"id":` + id + `,"title":"Stale","version":1},"id":1},` +
+		`{"jsonrpc":"2.0","method":"delete_event","params":{"user_id":1,"id":"x"},"id":2},` +
+		`{"jsonrpc":"2.0","method":"drop_calendar","id":3},` +
+		`{"jsonrpc":"1.0","method":"delete_event","id":4}]`)
+	var batch []rpcResponse
+	if err := json.Unmarshal([]byte(resp), &batch); err != nil || len(batch) != 4 {
+		t.Fatalf("batch: %s", resp)
+	}
+	want := []struct{ code, status int }{
+		{rpcVersionError, http.StatusPreconditionFailed},
+		{rpcInvalidParams, http.StatusBadRequest},
+		{rpcMethodNotFound, 0},
+		{rpcInvalidRequest, 0},
+	}
+	for i, w := range want {
+		e := batch[i].Error
+		if e == nil || e.Code != w.code || (w.status != 0 && (e.Data == nil || e.Data.Status != w.status)) {
+			t.Errorf("batch[%d] = %+v, want code %d", i, e, w.code)
+		}
+	}
+	if !strings.Contains(call(`{"jsonrpc":`), strconv.Itoa(rpcParseError)) {
+		t.Error("malformed line is not a parse error")
+	}
+
+	r := env.do(http.MethodPost, "/rpc", nil, http.Header{"Content-Type": {jsonType}},
+		`{"jsonrpc":"2.0","method":"delete_event","params":{"user_id":1,"id":`+id+`,"version":2}}`)
+	r.expect(t, http.StatusNoContent)
+	env.get("/events_for_day", form("user_id", "1", "date", "2026-10-19")).expect(t, http.StatusOK).decode(t, &events)
+	if len(events) != 0 {
+		t.Errorf("after delete %+v", events)
+	}
+}
+
+func TestCalDAV(t *testing.T) {
+	env := newTestEnv(t, NewMemoryStore())
+	put := env.do(http.MethodPut, "/caldav/1/planning@test.ics", nil, http.Header{"Content-Type": {calendarType}}, icsEvent)
+	if put.status != http.StatusCreated || put.header.Get("ETag") == "" {
+		t.Fatalf("PUT status %d ETag %q: %s", put.status, put.header.Get("ETag"), put.body)
+	}
+	get := env.do(http.MethodGet, "/caldav/1/planning@test.ics", nil, nil, "").expect(t, http.StatusOK)
+	if !strings.Contains(string(get.body), "SUMMARY:Planning") {
+		t.Errorf("GET %s", get.body)
+	}
+	env.do("PROPFIND", "/caldav/1/", nil, http.Header{"Depth": {"1"}}, " ").expect(t, http.StatusMultiStatus)
+	env.do(http.MethodDelete, "/caldav/1/planning@test.ics", nil, nil, "").expect(t, http.StatusNoContent)
+	env.do(http.MethodGet, "/caldav/1/planning@test.ics", nil, nil, "").expect(t, http.StatusNotFound)
+}
+
+func TestFileStoreRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newTestEnv(t, store)
+	e := env.create(form("user_id", "1", "title", "Persisted", "date", "2026-10-19", "tags", "keep"))
+	env.post("/update_event", form("user_id", "1", "id", strconv.FormatInt(e.ID, 10), "description", "v2")).expect(t, http.StatusOK)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	env = newTestEnv(t, store)
+	var found []eventJSON
+	env.get("/search", form("user_id", "1", "tag", "keep")).expect(t, http.StatusOK).decode(t, &found)
+	if len(found) != 1 || found[0].Version != 2 || found[0].Description != "v2" {
+		t.Errorf("after restart %+v", found)
+	}
+	var history []auditJSON
+	env.get("/event_history", form("user_id", "1", "id", strconv.FormatInt(e.ID, 10))).expect(t, http.StatusOK).decode(t, &history)
+	if len(history) != 2 {
+		t.Errorf("history after restart %+v, want created and updated", history)
+	}
+}
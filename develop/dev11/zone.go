@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	// встроенная база часовых поясов: сервер не зависит от системной
+	_ "time/tzdata"
+)
+
+// ErrUnknownZone — часовой пояс отсутствует в базе IANA.
+const ErrUnknownZone = domainError("unknown time zone")
+
+// zoneCache хранит загруженные часовые пояса по имени.
+var zoneCache sync.Map
+
+// LoadZone возвращает часовой пояс по имени IANA (Europe/Moscow).
+// Пустое имя означает UTC.
+func LoadZone(name string) (*time.Location, error) {
+	if name == "" || name == "UTC" {
+		return time.UTC, nil
+	}
+	if loc, ok := zoneCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownZone, name)
+	}
+	zoneCache.Store(name, loc)
+	return loc, nil
+}
+
+// civilDate возвращает календарную дату момента t в зоне loc.
+// Календарные даты (дни серий, исключения) хранятся как полночь UTC.
+func civilDate(t time.Time, loc *time.Location) time.Time {
+	return truncateDay(t.In(loc))
+}
+
+// atDate возвращает момент на календарную дату day в зоне loc со временем
+// суток clock. Несуществующее из-за перевода часов время сдвигается по
+// правилам time.Date.
+func atDate(day, clock time.Time, loc *time.Location) time.Time {
+	clock = clock.In(loc)
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), loc)
+}
+
+// midnight возвращает начало календарной даты day в зоне loc.
+func midnight(day time.Time, loc *time.Location) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+}
+
+// daysBetween возвращает число календарных дней между датами a и b.
+func daysBetween(a, b time.Time) int {
+	return int(truncateDay(b).Sub(truncateDay(a)) / (24 * time.Hour))
+}
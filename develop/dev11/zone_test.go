@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSeriesAcrossDST(t *testing.T) {
+	ny, err := LoadZone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 ноября 2026 часы в Нью-Йорке переводятся назад
+	start := time.Date(2026, 10, 31, 9, 0, 0, 0, ny)
+	e := Event{Title: "Standup", Start: start, End: start.Add(30 * time.Minute), TZ: "America/New_York",
+		Rule: &Rule{Freq: Daily, Interval: 1, Count: 3}}
+	occs := e.expand(start, start.AddDate(0, 0, 3))
+	if len(occs) != 3 {
+		t.Fatalf("%d occurrences", len(occs))
+	}
+	for i, wantUTC := range []int{13, 14, 14} {
+		local, utc := occs[i].Start.In(ny), occs[i].Start.UTC()
+		if local.Hour() != 9 || utc.Hour() != wantUTC || occs[i].End.Sub(occs[i].Start) != 30*time.Minute {
+			t.Errorf("occurrence %d at %s (%s UTC)", i, local, utc.Format(time.Kitchen))
+		}
+	}
+
+	// 14 марта 2027 времени 2:30 нет: повторение остаётся в своём дне
+	// и длится столько же, а на следующий день снова начинается в 2:30
+	night := time.Date(2027, 3, 13, 2, 30, 0, 0, ny)
+	e = Event{Title: "Backup", Start: night, End: night.Add(time.Hour), TZ: "America/New_York",
+		Rule: &Rule{Freq: Daily, Interval: 1, Count: 3}}
+	occs = e.expand(night, night.AddDate(0, 0, 3))
+	if len(occs) != 3 {
+		t.Fatalf("%d occurrences across the gap", len(occs))
+	}
+	if gap := occs[1]; !civilDate(gap.Start, ny).Equal(date("2027-03-14")) || gap.End.Sub(gap.Start) != time.Hour {
+		t.Errorf("occurrence in the gap %s - %s", gap.Start, gap.End)
+	}
+	if after := occs[2].Start.In(ny); after.Hour() != 2 || after.Minute() != 30 {
+		t.Errorf("occurrence after the gap at %s", after)
+	}
+}
+
+func TestDayBoundsAcrossDST(t *testing.T) {
+	ny, err := LoadZone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal := NewCalendar(NewMemoryStore())
+	day := date("2026-11-01")
+	holiday := Event{UserID: 1, Title: "Day off", AllDay: true, TZ: "America/New_York"}
+	holiday.Start = midnight(day, ny)
+	holiday.End = midnight(day.AddDate(0, 0, 1), ny)
+	if _, _, err := cal.CreateEvent(holiday, AllowConflicts); err != nil {
+		t.Fatal(err)
+	}
+	if d := holiday.End.Sub(holiday.Start); d != 25*time.Hour {
+		t.Errorf("all-day event lasts %s", d)
+	}
+	late := time.Date(2026, 11, 1, 23, 30, 0, 0, ny)
+	if _, _, err := cal.CreateEvent(Event{UserID: 1, Title: "Late", Start: late, End: late.Add(15 * time.Minute)}, AllowConflicts); err != nil {
+		t.Fatal(err)
+	}
+	events, err := cal.EventsForDay(1, midnight(day, ny))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("events on a 25-hour day: %+v", events)
+	}
+	// по UTC то же позднее событие приходится на следующий день
+	for _, d := range []string{"2026-11-01", "2026-11-02"} {
+		events, err = cal.EventsForDay(1, date(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		late := false
+		for _, e := range events {
+			late = late || e.Title == "Late"
+		}
+		if late != (d == "2026-11-02") {
+			t.Errorf("events on UTC day %s: %+v", d, events)
+		}
+	}
+}
+
+func TestLoadZone(t *testing.T) {
+	for _, name := range []string{"", "UTC"} {
+		if loc, err := LoadZone(name); err != nil || loc != time.UTC {
+			t.Errorf("LoadZone(%q) = %v, %v", name, loc, err)
+		}
+	}
+	if _, err := LoadZone("Mars/Olympus"); !errors.Is(err, ErrUnknownZone) {
+		t.Errorf("unknown zone: %v", err)
+	}
+}
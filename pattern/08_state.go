@@ -1,8 +1,17 @@
 package pattern
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 /*
@@ -28,224 +37,1209 @@ import (
 */
 
 type State interface {
-	AddItem(int) error
-	RequestItem() error
+	AddItem(code string, count int) error
+	RequestItem(code string) error
 	InsertMoney(money int) error
 	DispenseItem() error
+	Cancel() error
 }
 
+// Slot — ячейка автомата со своим товаром, ценой и остатком
+type Slot struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+	Count int    `json:"count"`
+}
+
+// VendingMachine — автомат с товарами. Действия с ним можно вызывать из
+// нескольких горутин: каждое выполняется целиком под блокировкой.
+// Поля меняются только под ней, из других горутин автомат читается
+// через Status. Действия состояний HasItem, ItemRequested, HasMoney и
+// NoItem тоже выполняются под блокировкой и записываются в события
 type VendingMachine struct {
 	HasItem       State
 	ItemRequested State
 	HasMoney      State
 	NoItem        State
-	CurrentState  State
-	ItemCount     int
-	ItemPrice     int
+
+	slots map[string]*Slot
+	// coins — запас монет и купюр для сдачи: номинал и количество.
+	// Автомат принимает только номиналы из coins
+	coins map[int]int
+	// selected — код выбранного товара
+	selected string
+	// credit — сколько внесено за выбранный товар; inserted — чем именно.
+	// Внесённые деньги лежат в coins и возвращаются при отмене
+	credit   int
+	inserted []int
+	// change — сдача, отложенная из coins до выдачи товара
+	change []int
+	// tray — лоток выдачи: сдача и возвращённые деньги
+	tray []int
+	// timeout — через сколько без действий покупателя начатая покупка
+	// отменяется с возвратом денег; 0 — не отменяется
+	timeout time.Duration
+	// lastActivity — время последнего действия покупателя
+	lastActivity time.Time
+	// now — часы автомата
+	now func() time.Time
+
+	// current — текущее состояние, одно из четырёх выше
+	current   State
+	fsm       *FSM
+	mu        sync.Mutex
+	observers []Observer
+	// seq — номер последнего события
+	seq int
+	// events — последние eventHistory событий по возрастанию Seq
+	events []VendingEvent
 }
 
-func NewVendingMachine(itemCount, itemPrice int) *VendingMachine {
-	v := &VendingMachine{
-		ItemCount: itemCount,
-		ItemPrice: itemPrice,
-	}
+// DefaultTimeout — время на покупку по умолчанию
+const DefaultTimeout = 2 * time.Minute
+
+// eventHistory — сколько последних событий автомат хранит для Events и
+// Subscribe. Полный журнал ведёт Journal, подписанный сразу после создания
+const eventHistory = 1024
+
+// Trigger — действие с автоматом
+type Trigger string
+
+const (
+	TriggerInit        Trigger = "init"
+	TriggerAddItem     Trigger = "add_item"
+	TriggerAddCoins    Trigger = "add_coins"
+	TriggerRequestItem Trigger = "request_item"
+	TriggerInsertMoney Trigger = "insert_money"
+	TriggerDispense    Trigger = "dispense_item"
+	TriggerCancel      Trigger = "cancel"
+	TriggerTimeout     Trigger = "timeout"
+	TriggerTakeTray    Trigger = "take_tray"
+	TriggerSetState    Trigger = "set_state"
+	TriggerSetTimeout  Trigger = "set_timeout"
+)
+
+// Payload — параметры действия; заполнены только относящиеся к нему поля.
+// Для add_coins Money — номинал, Count — количество
+type Payload struct {
+	Code  string `json:"code,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Money int    `json:"money,omitempty"`
+	// State — состояние для set_state
+	State string `json:"state,omitempty"`
+	// Timeout — время на покупку для set_timeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Slots и Coins — начальное содержимое автомата в событии init
+	Slots []Slot      `json:"slots,omitempty"`
+	Coins map[int]int `json:"coins,omitempty"`
+}
+
+// VendingEvent — действие с автоматом и переход, к которому оно привело.
+// Теги задают формат журнала, который пишет Journal
+type VendingEvent struct {
+	Seq     int       `json:"seq"`
+	At      time.Time `json:"at"`
+	Trigger Trigger   `json:"trigger"`
+	Payload Payload   `json:"payload"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	// Err — почему действие отклонено. Отклонённые действия тоже
+	// записываются: они могут вернуть деньги в лоток
+	Err string `json:"err,omitempty"`
+}
+
+// Observer получает события автомата по порядку. Notify вызывается под
+// блокировкой автомата, поэтому не должен обращаться к нему
+type Observer interface {
+	Notify(e VendingEvent)
+}
+
+// ObserverFunc позволяет использовать функцию как Observer
+type ObserverFunc func(e VendingEvent)
 
-	hasItemState := &HasItemState{
-		VendingMachine: v,
+func (f ObserverFunc) Notify(e VendingEvent) {
+	f(e)
+}
+
+func NewVendingMachine(slots []Slot, coins map[int]int) *VendingMachine {
+	v := &VendingMachine{
+		slots:   make(map[string]*Slot, len(slots)),
+		coins:   make(map[int]int, len(coins)),
+		timeout: DefaultTimeout,
+		now:     time.Now,
 	}
-	itemRequestedState := &ItemRequestedState{
-		VendingMachine: v,
+	for _, slot := range slots {
+		slot := slot
+		v.slots[slot.Code] = &slot
 	}
-	hasMoneyState := &HasMoneyState{
-		VendingMachine: v,
+	for coin, count := range coins {
+		v.coins[coin] = count
 	}
-	noItemState := &NoItemState{
-		VendingMachine: v,
+
+	v.HasItem = &vendingState{v: v, name: StateHasItem}
+	v.ItemRequested = &vendingState{v: v, name: StateItemRequested}
+	v.HasMoney = &vendingState{v: v, name: StateHasMoney}
+	v.NoItem = &vendingState{v: v, name: StateNoItem}
+	init := Payload{Slots: append([]Slot(nil), slots...), Coins: make(map[int]int, len(coins))}
+	for coin, count := range coins {
+		init.Coins[coin] = count
 	}
-	v.HasItem = hasItemState
-	v.ItemRequested = itemRequestedState
-	v.HasMoney = hasMoneyState
-	v.NoItem = noItemState
-	v.SetState(hasItemState)
+	v.record(TriggerInit, init, func() error {
+		initial := StateNoItem
+		if v.inStock() {
+			initial = StateHasItem
+		}
+		// таблица проверена в init
+		v.fsm, _ = NewFSM(initial, v.transitions())
+		v.sync()
+		return nil
+	})
 
 	return v
 }
 
-func (v *VendingMachine) AddItem(count int) error {
-	return v.CurrentState.AddItem(count)
+// init проверяет таблицу переходов автомата. Она одинакова у всех
+// автоматов, поэтому ошибка в ней — ошибка программы, а не NewVendingMachine
+func init() {
+	if _, err := NewFSM(StateNoItem, (&VendingMachine{}).transitions()); err != nil {
+		panic("vending machine transitions: " + err.Error())
+	}
+}
+
+func (v *VendingMachine) AddItem(code string, count int) error {
+	return v.fire(TriggerAddItem, Payload{Code: code, Count: count})
 }
 
-func (v *VendingMachine) RequestItem() error {
-	return v.CurrentState.RequestItem()
+func (v *VendingMachine) RequestItem(code string) error {
+	return v.fire(TriggerRequestItem, Payload{Code: code})
 }
 
 func (v *VendingMachine) InsertMoney(money int) error {
-	return v.CurrentState.InsertMoney(money)
+	return v.fire(TriggerInsertMoney, Payload{Money: money})
 }
 
 func (v *VendingMachine) DispenseItem() error {
-	return v.CurrentState.DispenseItem()
+	return v.fire(TriggerDispense, Payload{})
 }
 
-func (v *VendingMachine) SetState(s State) {
-	v.CurrentState = s
+// Cancel прерывает покупку и возвращает внесённые деньги в лоток
+func (v *VendingMachine) Cancel() error {
+	return v.fire(TriggerCancel, Payload{})
 }
 
-func (v *VendingMachine) IncrementItemCount(count int) {
-	v.ItemCount += count
+// IncrementItemCount пополняет ячейку code; то же, что AddItem
+func (v *VendingMachine) IncrementItemCount(code string, count int) error {
+	return v.AddItem(code, count)
 }
 
-// STATES
+// SetState переводит автомат в состояние s в обход таблицы переходов,
+// например чтобы вывести его из продажи через NoItem. Начатая покупка
+// отменяется с возвратом денег. В ItemRequested и HasMoney автомат
+// попадает только покупкой
+func (v *VendingMachine) SetState(s State) error {
+	return v.fire(TriggerSetState, Payload{State: v.stateName(s)})
+}
 
-// No item
-type NoItemState struct {
-	VendingMachine *VendingMachine
+// SetTimeout задаёт, через сколько без действий покупателя начатая
+// покупка отменяется; 0 — не отменяется
+func (v *VendingMachine) SetTimeout(d time.Duration) error {
+	return v.fire(TriggerSetTimeout, Payload{Timeout: d})
 }
 
-func (s *NoItemState) AddItem(count int) error {
-	s.VendingMachine.IncrementItemCount(count)
-	fmt.Printf("%d items added \n", count)
-	s.VendingMachine.SetState(s.VendingMachine.HasItem)
-	return nil
+// SetClock подменяет часы автомата, например в тестах
+func (v *VendingMachine) SetClock(now func() time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.now = now
 }
 
-func (s *NoItemState) RequestItem() error {
-	return errors.New("item out of stock")
+// AddCoins пополняет запас номинала coin для сдачи
+func (v *VendingMachine) AddCoins(coin, count int) error {
+	return v.fire(TriggerAddCoins, Payload{Money: coin, Count: count})
 }
 
-func (s *NoItemState) InsertMoney(money int) error {
-	return errors.New("item out of stock")
+// CurrentState возвращает текущее состояние автомата
+func (v *VendingMachine) CurrentState() State {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.current
 }
 
-func (s *NoItemState) DispenseItem() error {
-	return errors.New("item out of stock")
+// TakeTray забирает деньги из лотка выдачи
+func (v *VendingMachine) TakeTray() []int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.checkTimeout()
+	tray := v.tray
+	v.record(TriggerTakeTray, Payload{}, v.action(TriggerTakeTray, Payload{}))
+	return tray
 }
 
-// Requested
-type ItemRequestedState struct {
-	VendingMachine *VendingMachine
+// CheckTimeout отменяет покупку, брошенную дольше Timeout, и сообщает,
+// была ли она отменена. Кроме вызова перед каждым действием, его стоит
+// вызывать периодически, например через WatchTimeout, чтобы деньги
+// возвращались без нового покупателя
+func (v *VendingMachine) CheckTimeout() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.checkTimeout()
 }
 
-func (s *ItemRequestedState) AddItem(count int) error {
-	return errors.New("item dispense in progress")
+// WatchTimeout вызывает CheckTimeout каждые interval до вызова stop
+func (v *VendingMachine) WatchTimeout(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				v.CheckTimeout()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
 }
 
-func (s *ItemRequestedState) RequestItem() error {
-	return errors.New("item already requested")
+func (v *VendingMachine) checkTimeout() bool {
+	if v.timeout <= 0 || (v.current != v.ItemRequested && v.current != v.HasMoney) {
+		return false
+	}
+	if v.now().Sub(v.lastActivity) < v.timeout {
+		return false
+	}
+	fmt.Println("Session timed out")
+	v.record(TriggerTimeout, Payload{}, v.action(TriggerTimeout, Payload{}))
+	return true
 }
 
-func (s *ItemRequestedState) InsertMoney(money int) error {
-	if money < s.VendingMachine.ItemPrice {
-		return fmt.Errorf("received [%d], needed [%d]", money, s.VendingMachine.ItemPrice)
+// Subscribe добавляет наблюдателя и сразу передаёт ему хранящиеся
+// события: подписанный сразу после создания наблюдатель получает журнал
+// с init, подписанный позже — не больше eventHistory последних событий
+func (v *VendingMachine) Subscribe(o Observer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, e := range v.events {
+		o.Notify(e)
 	}
-	fmt.Println("Money received")
-	s.VendingMachine.SetState(s.VendingMachine.HasMoney)
+	v.observers = append(v.observers, o)
+}
+
+// Events возвращает последние eventHistory событий автомата
+func (v *VendingMachine) Events() []VendingEvent {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]VendingEvent(nil), v.events...)
+}
+
+// VendingStatus — снимок автомата
+type VendingStatus struct {
+	State    string      `json:"state"`
+	Slots    []Slot      `json:"slots"`
+	Coins    map[int]int `json:"coins"`
+	Selected string      `json:"selected,omitempty"`
+	Credit   int         `json:"credit"`
+	Tray     []int       `json:"tray"`
+}
+
+func (st VendingStatus) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "state %s", st.State)
+	if st.Selected != "" {
+		fmt.Fprintf(&b, ", selected %s, credit %d", st.Selected, st.Credit)
+	}
+	fmt.Fprintf(&b, ", tray %v\n", st.Tray)
+	for _, slot := range st.Slots {
+		fmt.Fprintf(&b, "  %s %s: price %d, %d left\n", slot.Code, slot.Name, slot.Price, slot.Count)
+	}
+	coins := make([]int, 0, len(st.Coins))
+	for coin := range st.Coins {
+		coins = append(coins, coin)
+	}
+	sort.Ints(coins)
+	b.WriteString("  coins")
+	for _, coin := range coins {
+		fmt.Fprintf(&b, " %dx%d", coin, st.Coins[coin])
+	}
+	return b.String()
+}
+
+// Status возвращает снимок автомата; ячейки упорядочены по коду
+func (v *VendingMachine) Status() VendingStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	st := VendingStatus{
+		State:    v.stateName(v.current),
+		Coins:    make(map[int]int, len(v.coins)),
+		Selected: v.selected,
+		Credit:   v.credit,
+		Tray:     append([]int{}, v.tray...),
+	}
+	for _, slot := range v.slots {
+		st.Slots = append(st.Slots, *slot)
+	}
+	sort.Slice(st.Slots, func(i, j int) bool { return st.Slots[i].Code < st.Slots[j].Code })
+	for coin, count := range v.coins {
+		st.Coins[coin] = count
+	}
+	return st
+}
+
+// fire выполняет действие под блокировкой, отменив перед этим брошенную
+// покупку
+func (v *VendingMachine) fire(trigger Trigger, p Payload) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.checkTimeout()
+	return v.record(trigger, p, v.action(trigger, p))
+}
+
+// action возвращает выполнение действия trigger с параметрами p
+func (v *VendingMachine) action(trigger Trigger, p Payload) func() error {
+	switch trigger {
+	case TriggerAddItem, TriggerRequestItem, TriggerInsertMoney, TriggerDispense, TriggerCancel:
+		return func() error { return v.step(trigger, p) }
+	case TriggerAddCoins:
+		return func() error { return v.addCoins(p.Money, p.Count) }
+	case TriggerTimeout:
+		return func() error { return v.step(TriggerCancel, p) }
+	case TriggerTakeTray:
+		return func() error {
+			v.tray = nil
+			return nil
+		}
+	case TriggerSetState:
+		return func() error { return v.setState(p.State) }
+	case TriggerSetTimeout:
+		return func() error { return v.setTimeout(p.Timeout) }
+	default:
+		return func() error { return fmt.Errorf("unknown trigger [%s]", trigger) }
+	}
+}
+
+// step передаёт событие таблице переходов текущего состояния
+func (v *VendingMachine) step(trigger Trigger, p Payload) error {
+	err := v.fsm.Fire(string(trigger), p)
+	v.sync()
+	return err
+}
+
+// record выполняет действие, записывает событие о нём и рассылает его
+// наблюдателям
+func (v *VendingMachine) record(trigger Trigger, p Payload, action func() error) error {
+	from := v.stateName(v.current)
+	err := action()
+	v.seq++
+	e := VendingEvent{
+		Seq:     v.seq,
+		At:      v.now(),
+		Trigger: trigger,
+		Payload: p,
+		From:    from,
+		To:      v.stateName(v.current),
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	v.events = append(v.events, e)
+	if len(v.events) > eventHistory {
+		v.events = append(v.events[:0], v.events[len(v.events)-eventHistory:]...)
+	}
+	for _, o := range v.observers {
+		o.Notify(e)
+	}
+	return err
+}
+
+func (v *VendingMachine) stateName(s State) string {
+	if vs, ok := s.(*vendingState); ok {
+		return vs.name
+	}
+	return ""
+}
+
+// RestoreVendingMachine восстанавливает автомат по журналу событий,
+// например после сбоя: начальное содержимое берётся из события init,
+// остальные действия, включая set_timeout, повторяются по порядку с их
+// временем
+func RestoreVendingMachine(events []VendingEvent) (*VendingMachine, error) {
+	if len(events) == 0 || events[0].Trigger != TriggerInit {
+		return nil, errors.New("event log must start with init")
+	}
+	v := NewVendingMachine(events[0].Payload.Slots, events[0].Payload.Coins)
+	v.events[0].At = events[0].At
+	for _, e := range events[1:] {
+		at := e.At
+		v.now = func() time.Time { return at }
+		// брошенные покупки отменяются событиями timeout из журнала
+		v.record(e.Trigger, e.Payload, v.action(e.Trigger, e.Payload))
+		got := v.events[len(v.events)-1]
+		if got.To != e.To || got.Err != e.Err {
+			return nil, fmt.Errorf("event %d: replayed to [%s] with error [%s], log says [%s] with error [%s]",
+				e.Seq, got.To, got.Err, e.To, e.Err)
+		}
+	}
+	v.now = time.Now
+	return v, nil
+}
+
+// Journal — наблюдатель, который дописывает события в w по строке JSON.
+// По такому журналу ReadJournal и RestoreVendingMachine восстанавливают
+// автомат после сбоя
+type Journal struct {
+	w   io.Writer
+	err error
+}
+
+func NewJournal(w io.Writer) *Journal {
+	return &Journal{w: w}
+}
+
+func (j *Journal) Notify(e VendingEvent) {
+	if j.err != nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err == nil {
+		_, err = j.w.Write(append(b, '\n'))
+	}
+	j.err = err
+}
+
+// Err возвращает первую ошибку записи; после неё журнал не пишется
+func (j *Journal) Err() error {
+	return j.err
+}
+
+// ReadJournal читает события, записанные Journal. Недописанная при сбое
+// последняя строка пропускается
+func ReadJournal(r io.Reader) ([]VendingEvent, error) {
+	var events []VendingEvent
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e VendingEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events)+1, err)
+		}
+		events = append(events, e)
+	}
+}
+
+// sync приводит current в соответствие с таблицей переходов
+func (v *VendingMachine) sync() {
+	switch v.fsm.Current() {
+	case StateHasItem:
+		v.current = v.HasItem
+	case StateItemRequested:
+		v.current = v.ItemRequested
+	case StateHasMoney:
+		v.current = v.HasMoney
+	case StateNoItem:
+		v.current = v.NoItem
+	}
+}
+
+// DOT возвращает граф переходов автомата для Graphviz
+func (v *VendingMachine) DOT() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.fsm.DOT("VendingMachine")
+}
+
+// Mermaid возвращает граф переходов автомата для Mermaid
+func (v *VendingMachine) Mermaid() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.fsm.Mermaid()
+}
+
+// touch отмечает действие покупателя
+func (v *VendingMachine) touch() {
+	v.lastActivity = v.now()
+}
+
+func (v *VendingMachine) incrementItemCount(code string, count int) error {
+	slot, ok := v.slots[code]
+	if !ok {
+		return fmt.Errorf("no slot [%s]", code)
+	}
+	if count <= 0 {
+		return fmt.Errorf("invalid item count [%d]", count)
+	}
+	slot.Count += count
+	return nil
+}
+
+func (v *VendingMachine) setState(state string) error {
+	if state == "" {
+		return errors.New("unknown state")
+	}
+	if state != StateHasItem && state != StateNoItem {
+		return fmt.Errorf("cannot set state [%s]", state)
+	}
+	if v.selected != "" {
+		v.refund()
+	}
+	if err := v.fsm.SetCurrent(state); err != nil {
+		return err
+	}
+	v.sync()
+	return nil
+}
+
+func (v *VendingMachine) setTimeout(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("negative timeout %s", d)
+	}
+	v.timeout = d
+	return nil
+}
+
+func (v *VendingMachine) addCoins(coin, count int) error {
+	if coin <= 0 || count <= 0 {
+		return fmt.Errorf("invalid coins [%d x %d]", count, coin)
+	}
+	v.coins[coin] += count
 	return nil
 }
 
-func (s *ItemRequestedState) DispenseItem() error {
-	return errors.New("please insert money first")
+// inStock сообщает, есть ли в автомате хоть один товар
+func (v *VendingMachine) inStock() bool {
+	for _, slot := range v.slots {
+		if slot.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// refund возвращает в лоток внесённые деньги, а отложенную сдачу — в Coins
+func (v *VendingMachine) refund() {
+	for _, coin := range v.change {
+		v.coins[coin]++
+	}
+	for _, coin := range v.inserted {
+		v.coins[coin]--
+	}
+	v.tray = append(v.tray, v.inserted...)
+	fmt.Printf("Refunded [%d]\n", v.credit)
+	v.idle()
+}
+
+// idle возвращает автомат к выбору товара
+func (v *VendingMachine) idle() {
+	v.selected = ""
+	v.credit = 0
+	v.inserted = nil
+	v.change = nil
+}
+
+// makeChange набирает сумму amount из запаса coins, начиная с крупных
+// номиналов. Жадный выбор не всегда находит сдачу при ограниченном запасе,
+// поэтому перебираются и меньшие количества крупных монет
+func makeChange(amount int, coins map[int]int) ([]int, bool) {
+	var denoms []int
+	for coin, count := range coins {
+		if count > 0 {
+			denoms = append(denoms, coin)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(denoms)))
+
+	// failed — остатки, которые не набрать номиналами начиная с i
+	failed := make(map[[2]int]bool)
+	var pick func(i, rest int) ([]int, bool)
+	pick = func(i, rest int) ([]int, bool) {
+		if rest == 0 {
+			return nil, true
+		}
+		if i == len(denoms) || failed[[2]int{i, rest}] {
+			return nil, false
+		}
+		coin := denoms[i]
+		n := rest / coin
+		if n > coins[coin] {
+			n = coins[coin]
+		}
+		for ; n >= 0; n-- {
+			if tail, ok := pick(i+1, rest-n*coin); ok {
+				change := make([]int, 0, n+len(tail))
+				for j := 0; j < n; j++ {
+					change = append(change, coin)
+				}
+				return append(change, tail...), true
+			}
+		}
+		failed[[2]int{i, rest}] = true
+		return nil, false
+	}
+	return pick(0, amount)
+}
+
+// STATES
+
+// Состояния автомата в таблице переходов
+const (
+	StateNoItem        = "NoItem"
+	StateHasItem       = "HasItem"
+	StateItemRequested = "ItemRequested"
+	StateHasMoney      = "HasMoney"
+)
+
+// vendingState — состояние автомата. Вместо отдельной структуры на каждое
+// состояние действия передаются событиями в таблицу переходов
+type vendingState struct {
+	v    *VendingMachine
+	name string
+}
+
+func (s *vendingState) AddItem(code string, count int) error {
+	return s.fire(TriggerAddItem, Payload{Code: code, Count: count})
+}
+
+func (s *vendingState) RequestItem(code string) error {
+	return s.fire(TriggerRequestItem, Payload{Code: code})
+}
+
+func (s *vendingState) InsertMoney(money int) error {
+	return s.fire(TriggerInsertMoney, Payload{Money: money})
 }
 
-// Has money
-type HasMoneyState struct {
-	VendingMachine *VendingMachine
+func (s *vendingState) DispenseItem() error {
+	return s.fire(TriggerDispense, Payload{})
 }
 
-func (s *HasMoneyState) AddItem(count int) error {
-	return errors.New("item dispense in progress")
+func (s *vendingState) Cancel() error {
+	return s.fire(TriggerCancel, Payload{})
 }
 
-func (s *HasMoneyState) RequestItem() error {
-	return errors.New("item dispense in progress")
+// fire выполняет действие так же, как одноимённый метод автомата, если
+// s — текущее состояние
+func (s *vendingState) fire(trigger Trigger, p Payload) error {
+	v := s.v
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.checkTimeout()
+	if v.current != State(s) {
+		return fmt.Errorf("state [%s] is not current", s.name)
+	}
+	return v.record(trigger, p, v.action(trigger, p))
 }
 
-func (s *HasMoneyState) InsertMoney(money int) error {
-	return errors.New("item dispense in progress")
+// transitions — таблица переходов автомата. Переходы одного события
+// проверяются по порядку, первый с выполненным условием срабатывает
+func (v *VendingMachine) transitions() []Transition {
+	// on передаёт действию параметры события
+	on := func(action func(p Payload) error) func(interface{}) error {
+		return func(p interface{}) error { return action(p.(Payload)) }
+	}
+	when := func(guard func(p Payload) bool) func(interface{}) bool {
+		return func(p interface{}) bool { return guard(p.(Payload)) }
+	}
+	reject := func(from, msg string, events ...Trigger) []Transition {
+		var t []Transition
+		for _, e := range events {
+			t = append(t, Transition{From: from, Event: string(e), Reject: msg})
+		}
+		return t
+	}
+	underpaid := func(p Payload) bool { return v.credit+p.Money < v.slots[v.selected].Price }
+	var t []Transition
+
+	// No item
+	t = append(t, Transition{From: StateNoItem, Event: string(TriggerAddItem), To: StateHasItem, Action: on(v.addItem)})
+	t = append(t, reject(StateNoItem, "item out of stock", TriggerRequestItem, TriggerInsertMoney, TriggerDispense)...)
+	t = append(t, reject(StateNoItem, "nothing to cancel", TriggerCancel)...)
+
+	// Has item
+	t = append(t,
+		Transition{From: StateHasItem, Event: string(TriggerAddItem), To: StateHasItem, Action: on(v.addItem)},
+		Transition{From: StateHasItem, Event: string(TriggerRequestItem), To: StateItemRequested, Action: on(v.selectItem)},
+	)
+	t = append(t, reject(StateHasItem, "please select item first", TriggerInsertMoney, TriggerDispense)...)
+	t = append(t, reject(StateHasItem, "nothing to cancel", TriggerCancel)...)
+
+	// Requested
+	t = append(t,
+		Transition{From: StateItemRequested, Event: string(TriggerInsertMoney), To: StateItemRequested,
+			When: "credit < price", Guard: when(underpaid), Action: on(v.addCredit)},
+		Transition{From: StateItemRequested, Event: string(TriggerInsertMoney), To: StateHasMoney,
+			When: "credit >= price", Action: on(v.pay)},
+		Transition{From: StateItemRequested, Event: string(TriggerCancel), To: StateHasItem, Action: on(v.cancel)},
+	)
+	t = append(t, reject(StateItemRequested, "item dispense in progress", TriggerAddItem)...)
+	t = append(t, reject(StateItemRequested, "item already requested", TriggerRequestItem)...)
+	t = append(t, reject(StateItemRequested, "please insert money first", TriggerDispense)...)
+
+	// Has money
+	t = append(t,
+		Transition{From: StateHasMoney, Event: string(TriggerDispense), To: StateHasItem,
+			When: "items left", Guard: when(func(Payload) bool { return v.stockAfterSale() > 0 }), Action: on(v.dispense)},
+		Transition{From: StateHasMoney, Event: string(TriggerDispense), To: StateNoItem,
+			When: "sold out", Action: on(v.dispense)},
+		Transition{From: StateHasMoney, Event: string(TriggerCancel), To: StateHasItem, Action: on(v.cancel)},
+	)
+	t = append(t, reject(StateHasMoney, "item dispense in progress", TriggerAddItem, TriggerRequestItem, TriggerInsertMoney)...)
+	return t
 }
 
-func (s *HasMoneyState) DispenseItem() error {
-	fmt.Println("Dispensing Item")
-	s.VendingMachine.ItemCount -= 1
-	if s.VendingMachine.ItemCount == 0 {
-		s.VendingMachine.SetState(s.VendingMachine.NoItem)
-	} else {
-		s.VendingMachine.SetState(s.VendingMachine.HasItem)
+// Действия переходов
+
+func (v *VendingMachine) addItem(p Payload) error {
+	if err := v.incrementItemCount(p.Code, p.Count); err != nil {
+		return err
 	}
+	fmt.Printf("%d items added to [%s]\n", p.Count, p.Code)
 	return nil
 }
 
-// Has item
-type HasItemState struct {
-	VendingMachine *VendingMachine
+func (v *VendingMachine) selectItem(p Payload) error {
+	slot, ok := v.slots[p.Code]
+	if !ok {
+		return fmt.Errorf("no slot [%s]", p.Code)
+	}
+	if slot.Count == 0 {
+		return fmt.Errorf("[%s] out of stock", slot.Name)
+	}
+	fmt.Printf("[%s] requested, price [%d]\n", slot.Name, slot.Price)
+	v.selected = p.Code
+	v.touch()
+	return nil
 }
 
-func (s *HasItemState) AddItem(count int) error {
-	fmt.Printf("%d items added\n", count)
-	s.VendingMachine.IncrementItemCount(count)
+// accept принимает монету или купюру в счёт выбранного товара
+func (v *VendingMachine) accept(money int) error {
+	if _, ok := v.coins[money]; !ok {
+		return fmt.Errorf("[%d] not accepted", money)
+	}
+	v.touch()
+	// сдачу можно выдать и только что принятыми деньгами
+	v.coins[money]++
+	v.credit += money
+	v.inserted = append(v.inserted, money)
 	return nil
 }
 
-func (s *HasItemState) RequestItem() error {
-	if s.VendingMachine.ItemCount == 0 {
-		s.VendingMachine.SetState(s.VendingMachine.NoItem)
-		return errors.New("no item present")
+func (v *VendingMachine) addCredit(p Payload) error {
+	if err := v.accept(p.Money); err != nil {
+		return err
 	}
-	fmt.Println("Item requested")
-	s.VendingMachine.SetState(s.VendingMachine.ItemRequested)
+	fmt.Printf("Credit [%d] of [%d]\n", v.credit, v.slots[v.selected].Price)
 	return nil
 }
 
-func (s *HasItemState) InsertMoney(money int) error {
-	return errors.New("please select item first")
+// pay завершает оплату и откладывает сдачу; если её не набрать,
+// последняя монета возвращается
+func (v *VendingMachine) pay(p Payload) error {
+	if err := v.accept(p.Money); err != nil {
+		return err
+	}
+	change, ok := makeChange(v.credit-v.slots[v.selected].Price, v.coins)
+	if !ok {
+		credit := v.credit
+		v.coins[p.Money]--
+		v.credit -= p.Money
+		v.inserted = v.inserted[:len(v.inserted)-1]
+		v.tray = append(v.tray, p.Money)
+		return fmt.Errorf("cannot give change from [%d], please insert exact amount", credit)
+	}
+	for _, coin := range change {
+		v.coins[coin]--
+	}
+	v.change = change
+	fmt.Println("Money received")
+	return nil
 }
 
-func (s *HasItemState) DispenseItem() error {
-	return errors.New("please select item first")
+func (v *VendingMachine) dispense(Payload) error {
+	slot := v.slots[v.selected]
+	fmt.Printf("Dispensing [%s]\n", slot.Name)
+	slot.Count--
+	v.tray = append(v.tray, v.change...)
+	v.idle()
+	return nil
 }
 
-// Ниже код для проверки работы паттерна
+func (v *VendingMachine) cancel(Payload) error {
+	v.refund()
+	return nil
+}
 
-// func main() {
+// stockAfterSale возвращает, сколько товаров останется после выдачи выбранного
+func (v *VendingMachine) stockAfterSale() int {
+	n := -1
+	for _, slot := range v.slots {
+		n += slot.Count
+	}
+	return n
+}
 
-// 	vendingMachine := NewVendingMachine(1, 10)
+// FSM
 
-// 	err := vendingMachine.RequestItem()
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// Transition — строка таблицы переходов: событие Event в состоянии From
+// переводит автомат в To, если Guard (условие When) выполнено и Action
+// завершилось без ошибки. Строка с Reject при выполненном Guard отклоняет
+// событие с этим сообщением и в граф не попадает
+type Transition struct {
+	From   string
+	Event  string
+	To     string
+	When   string
+	Guard  func(payload interface{}) bool
+	Action func(payload interface{}) error
+	Reject string
+}
 
-// 	err = vendingMachine.InsertMoney(10)
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// FSM — конечный автомат, заданный таблицей переходов. Не
+// синхронизирован: блокировку берёт на себя владелец
+type FSM struct {
+	initial string
+	current string
+	// states — состояния по порядку появления в таблице
+	states []string
+	table  []Transition
+	byKey  map[[2]string][]Transition
+}
 
-// 	err = vendingMachine.DispenseItem()
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// NewFSM строит автомат по таблице переходов в состоянии initial
+func NewFSM(initial string, table []Transition) (*FSM, error) {
+	f := &FSM{initial: initial, current: initial, table: table, byKey: make(map[[2]string][]Transition)}
+	seen := make(map[string]bool)
+	addState := func(s string) {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			f.states = append(f.states, s)
+		}
+	}
+	addState(initial)
+	for i, t := range table {
+		if t.From == "" || t.Event == "" || (t.To == "") == (t.Reject == "") {
+			return nil, fmt.Errorf("transition %d: need From, Event and either To or Reject", i)
+		}
+		key := [2]string{t.From, t.Event}
+		if rows := f.byKey[key]; len(rows) > 0 && rows[len(rows)-1].Guard == nil {
+			return nil, fmt.Errorf("transition %d: unreachable after unconditional [%s] on [%s]", i, t.From, t.Event)
+		}
+		f.byKey[key] = append(f.byKey[key], t)
+		addState(t.From)
+		addState(t.To)
+	}
+	return f, nil
+}
 
-// 	fmt.Println()
+// Current возвращает текущее состояние
+func (f *FSM) Current() string {
+	return f.current
+}
 
-// 	err = vendingMachine.AddItem(2)
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// SetCurrent переводит автомат в состояние state без перехода из таблицы
+func (f *FSM) SetCurrent(state string) error {
+	for _, s := range f.states {
+		if s == state {
+			f.current = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown state [%s]", state)
+}
+
+// Fire обрабатывает событие event с параметрами payload в текущем состоянии
+func (f *FSM) Fire(event string, payload interface{}) error {
+	rows := f.byKey[[2]string{f.current, event}]
+	if len(rows) == 0 {
+		return fmt.Errorf("event [%s] is not allowed in state [%s]", event, f.current)
+	}
+	for _, t := range rows {
+		if t.Guard != nil && !t.Guard(payload) {
+			continue
+		}
+		if t.Reject != "" {
+			return errors.New(t.Reject)
+		}
+		if t.Action != nil {
+			if err := t.Action(payload); err != nil {
+				return err
+			}
+		}
+		f.current = t.To
+		return nil
+	}
+	return fmt.Errorf("event [%s] in state [%s]: no transition condition holds", event, f.current)
+}
 
-// 	fmt.Println()
+// label — подпись перехода в графе: событие и условие
+func (t Transition) label() string {
+	if t.When == "" {
+		return t.Event
+	}
+	return t.Event + " [" + t.When + "]"
+}
 
-// 	err = vendingMachine.RequestItem()
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// DOT возвращает граф переходов для Graphviz
+func (f *FSM) DOT(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n\trankdir=LR;\n\t__start [shape=point];\n", name)
+	for _, s := range f.states {
+		fmt.Fprintf(&b, "\t%q [shape=box, style=rounded];\n", s)
+	}
+	fmt.Fprintf(&b, "\t__start -> %q;\n", f.initial)
+	for _, t := range f.table {
+		if t.Reject == "" {
+			fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", t.From, t.To, t.label())
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
 
-// 	err = vendingMachine.InsertMoney(10)
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
-// 	}
+// Mermaid возвращает граф переходов для Mermaid stateDiagram
+func (f *FSM) Mermaid() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "stateDiagram-v2\n    [*] --> %s\n", f.initial)
+	for _, t := range f.table {
+		if t.Reject == "" {
+			// двоеточие отделяет подпись перехода
+			fmt.Fprintf(&b, "    %s --> %s: %s\n", t.From, t.To, strings.ReplaceAll(t.label(), ":", " "))
+		}
+	}
+	return b.String()
+}
+
+// HTTP API
+
+// vendingRequest — тело запроса HTTP API; используются поля действия
+type vendingRequest struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+	Money int    `json:"money"`
+}
+
+// NewVendingHandler возвращает HTTP API автомата v:
+//
+//	POST /add-item     {"code": "A1", "count": 2}
+//	POST /add-coins    {"money": 10, "count": 20}
+//	POST /request      {"code": "A1"}
+//	POST /insert-money {"money": 50}
+//	POST /dispense
+//	POST /cancel
+//	POST /take-tray
+//	GET  /status
+//
+// Ответ — {"status": ...} со снимком автомата после действия. Неверный
+// запрос получает 400, действие, отклонённое автоматом, — 409 с
+// {"error": ..., "status": ...}
+func NewVendingHandler(v *VendingMachine) http.Handler {
+	mux := http.NewServeMux()
+	action := func(do func(req vendingRequest) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				writeVendingJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+				return
+			}
+			var req vendingRequest
+			dec := json.NewDecoder(io.LimitReader(r.Body, 1<<16))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&req); err != nil && err != io.EOF {
+				writeVendingJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid body: " + err.Error()})
+				return
+			}
+			if err := do(req); err != nil {
+				writeVendingJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "status": v.Status()})
+				return
+			}
+			writeVendingJSON(w, http.StatusOK, map[string]interface{}{"status": v.Status()})
+		}
+	}
+	mux.HandleFunc("/add-item", action(func(req vendingRequest) error { return v.AddItem(req.Code, req.Count) }))
+	mux.HandleFunc("/add-coins", action(func(req vendingRequest) error { return v.AddCoins(req.Money, req.Count) }))
+	mux.HandleFunc("/request", action(func(req vendingRequest) error { return v.RequestItem(req.Code) }))
+	mux.HandleFunc("/insert-money", action(func(req vendingRequest) error { return v.InsertMoney(req.Money) }))
+	mux.HandleFunc("/dispense", action(func(vendingRequest) error { return v.DispenseItem() }))
+	mux.HandleFunc("/cancel", action(func(vendingRequest) error { return v.Cancel() }))
+	mux.HandleFunc("/take-tray", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeVendingJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+			return
+		}
+		taken := v.TakeTray()
+		if taken == nil {
+			taken = []int{}
+		}
+		writeVendingJSON(w, http.StatusOK, map[string]interface{}{"taken": taken, "status": v.Status()})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeVendingJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+			return
+		}
+		writeVendingJSON(w, http.StatusOK, map[string]interface{}{"status": v.Status()})
+	})
+	return mux
+}
+
+func writeVendingJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// CLI
+
+// RunSimulator выполняет команды из in, по одной на строку, и после каждой
+// выводит в out результат и состояние автомата. in — файл сценария или
+// os.Stdin для работы вручную. Команды:
+//
+//	add CODE COUNT      coins COIN COUNT
+//	request CODE        insert MONEY
+//	dispense            cancel
+//	take                status
+//	graph               expect STATE
+//
+// Пустые строки и строки с # пропускаются. Отклонённое автоматом действие
+// выводится как ошибка, и сценарий продолжается; expect с другим
+// состоянием прерывает его
+func RunSimulator(v *VendingMachine, in io.Reader, out io.Writer) error {
+	sc := bufio.NewScanner(in)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		fmt.Fprintf(out, "> %s\n", strings.Join(fields, " "))
+		cmd, args := fields[0], fields[1:]
+		if cmd == "expect" {
+			if len(args) != 1 {
+				return fmt.Errorf("line %d: usage: expect STATE", line)
+			}
+			if st := v.Status().State; st != args[0] {
+				return fmt.Errorf("line %d: state is %s, expected %s", line, st, args[0])
+			}
+			fmt.Fprintln(out, "ok")
+			continue
+		}
+		if err := simulate(v, cmd, args, out); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		} else {
+			fmt.Fprintln(out, "ok")
+		}
+		fmt.Fprintln(out, v.Status())
+	}
+	return sc.Err()
+}
+
+// simulate выполняет одну команду симулятора
+func simulate(v *VendingMachine, cmd string, args []string, out io.Writer) error {
+	usage := map[string]string{
+		"add": "add CODE COUNT", "coins": "coins COIN COUNT", "request": "request CODE", "insert": "insert MONEY",
+		"dispense": "dispense", "cancel": "cancel", "take": "take", "status": "status", "graph": "graph",
+	}
+	want := map[string]int{"add": 2, "coins": 2, "request": 1, "insert": 1}
+	if _, ok := usage[cmd]; !ok {
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	if len(args) != want[cmd] {
+		return fmt.Errorf("usage: %s", usage[cmd])
+	}
+	number := func(i int) (int, error) {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return 0, fmt.Errorf("usage: %s", usage[cmd])
+		}
+		return n, nil
+	}
+	switch cmd {
+	case "add":
+		count, err := number(1)
+		if err != nil {
+			return err
+		}
+		return v.AddItem(args[0], count)
+	case "coins":
+		coin, err := number(0)
+		if err != nil {
+			return err
+		}
+		count, err := number(1)
+		if err != nil {
+			return err
+		}
+		return v.AddCoins(coin, count)
+	case "request":
+		return v.RequestItem(args[0])
+	case "insert":
+		money, err := number(0)
+		if err != nil {
+			return err
+		}
+		return v.InsertMoney(money)
+	case "dispense":
+		return v.DispenseItem()
+	case "cancel":
+		return v.Cancel()
+	case "take":
+		fmt.Fprintf(out, "taken %v\n", v.TakeTray())
+	case "graph":
+		fmt.Fprint(out, v.Mermaid())
+	}
+	return nil
+}
+
+// Ниже код для проверки работы паттерна
 
-// 	err = vendingMachine.DispenseItem()
-// 	if err != nil {
-// 		log.Fatalf(err.Error())
+// func main() {
+
+// 	vendingMachine := NewVendingMachine(
+// 		[]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}, {Code: "A2", Name: "Juice", Price: 90, Count: 0}},
+// 		map[int]int{10: 5, 20: 5, 50: 2, 100: 0},
+// 	)
+// 	stop := vendingMachine.WatchTimeout(time.Second)
+// 	defer stop()
+
+// 	// HTTP API: curl -d '{"code":"A1"}' localhost:8080/request
+// 	go func() {
+// 		log.Fatal(http.ListenAndServe(":8080", NewVendingHandler(vendingMachine)))
+// 	}()
+
+// 	// сценарий из файла или команды вручную: go run . scenario.txt
+// 	in := io.Reader(os.Stdin)
+// 	if len(os.Args) > 1 {
+// 		f, err := os.Open(os.Args[1])
+// 		if err != nil {
+// 			log.Fatal(err)
+// 		}
+// 		defer f.Close()
+// 		in = f
+// 	}
+// 	if err := RunSimulator(vendingMachine, in, os.Stdout); err != nil {
+// 		log.Fatal(err)
 // 	}
 // }
+
+// Пример сценария:
+//
+// 	request A1
+// 	insert 50
+// 	insert 20
+// 	expect HasMoney
+// 	dispense
+// 	take
+// 	expect NoItem
+// 	add A2 2
+// 	request A2
+// 	insert 50
+// 	cancel
+// 	expect HasItem
@@ -0,0 +1,625 @@
+package pattern
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMakeChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int
+		coins  map[int]int
+		want   []int
+		ok     bool
+	}{
+		{"nothing owed", 0, map[int]int{10: 1}, nil, true},
+		{"largest first", 70, map[int]int{10: 5, 20: 5, 50: 2}, []int{50, 20}, true},
+		{"exact stock", 30, map[int]int{10: 1, 20: 1}, []int{20, 10}, true},
+		{"greedy dead end", 60, map[int]int{50: 1, 20: 3}, []int{20, 20, 20}, true},
+		{"empty denomination skipped", 20, map[int]int{50: 0, 10: 2}, []int{10, 10}, true},
+		{"not enough coins", 40, map[int]int{10: 3}, nil, false},
+		{"no denomination fits", 30, map[int]int{20: 2}, nil, false},
+		{"empty stock", 10, map[int]int{}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := makeChange(tt.amount, tt.coins)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("makeChange(%d, %v) = %v, %v, want %v, %v", tt.amount, tt.coins, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVendingMachineChange(t *testing.T) {
+	newMachine := func(coins map[int]int) *VendingMachine {
+		v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 2}}, coins)
+		if err := v.RequestItem("A1"); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	t.Run("exact amount", func(t *testing.T) {
+		v := newMachine(map[int]int{10: 0, 50: 0})
+		for _, money := range []int{50, 10} {
+			if err := v.InsertMoney(money); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := v.DispenseItem(); err != nil {
+			t.Fatal(err)
+		}
+		st := v.Status()
+		if len(st.Tray) != 0 || st.Coins[50] != 1 || st.Coins[10] != 1 || st.Slots[0].Count != 1 {
+			t.Errorf("status after exact payment: %+v", st)
+		}
+	})
+
+	t.Run("change from stock", func(t *testing.T) {
+		v := newMachine(map[int]int{20: 2, 100: 0})
+		if err := v.InsertMoney(100); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.DispenseItem(); err != nil {
+			t.Fatal(err)
+		}
+		st := v.Status()
+		if !reflect.DeepEqual(st.Tray, []int{20, 20}) || st.Coins[20] != 0 || st.Coins[100] != 1 {
+			t.Errorf("status after payment with change: %+v", st)
+		}
+	})
+
+	t.Run("no change possible", func(t *testing.T) {
+		v := newMachine(map[int]int{20: 0, 50: 0})
+		if err := v.InsertMoney(50); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.InsertMoney(20); err == nil {
+			t.Fatal("paid 70 for 60 with no 10 in stock")
+		}
+		st := v.Status()
+		if st.State != StateItemRequested || st.Credit != 50 || !reflect.DeepEqual(st.Tray, []int{20}) || st.Coins[20] != 0 {
+			t.Errorf("status after rejected coin: %+v", st)
+		}
+		if err := v.InsertMoney(10); err == nil {
+			t.Fatal("accepted a denomination the machine does not take")
+		}
+	})
+}
+
+func TestVendingMachineTimeout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	newMachine := func() *VendingMachine {
+		v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}}, map[int]int{10: 5, 50: 0})
+		v.SetClock(func() time.Time { return now })
+		if err := v.RequestItem("A1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.InsertMoney(50); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	t.Run("refund after timeout", func(t *testing.T) {
+		v := newMachine()
+		now = now.Add(DefaultTimeout - time.Second)
+		if v.CheckTimeout() {
+			t.Fatal("timed out before Timeout passed")
+		}
+		now = now.Add(time.Second)
+		if !v.CheckTimeout() {
+			t.Fatal("no timeout after Timeout passed")
+		}
+		st := v.Status()
+		if st.State != StateHasItem || st.Credit != 0 || !reflect.DeepEqual(st.Tray, []int{50}) || st.Coins[50] != 0 {
+			t.Errorf("status after timeout: %+v", st)
+		}
+		events := v.Events()
+		if last := events[len(events)-1]; last.Trigger != TriggerTimeout || last.From != StateItemRequested || last.To != StateHasItem {
+			t.Errorf("last event = %+v, want timeout", last)
+		}
+	})
+
+	t.Run("activity extends session", func(t *testing.T) {
+		v := newMachine()
+		now = now.Add(DefaultTimeout - time.Second)
+		if err := v.InsertMoney(10); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+		if v.CheckTimeout() {
+			t.Fatal("timed out right after insert")
+		}
+	})
+
+	t.Run("next action refunds first", func(t *testing.T) {
+		v := newMachine()
+		now = now.Add(DefaultTimeout)
+		if err := v.InsertMoney(10); err == nil {
+			t.Fatal("insert accepted into an abandoned purchase")
+		}
+		if st := v.Status(); st.State != StateHasItem || !reflect.DeepEqual(st.Tray, []int{50}) {
+			t.Errorf("status after action on timed out purchase: %+v", st)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		v := newMachine()
+		if err := v.SetTimeout(0); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(24 * time.Hour)
+		if v.CheckTimeout() {
+			t.Fatal("timed out with Timeout 0")
+		}
+	})
+}
+
+func TestVendingMachineConcurrent(t *testing.T) {
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 50}}, map[int]int{10: 20, 20: 20, 50: 0})
+	var seen []VendingEvent
+	v.Subscribe(ObserverFunc(func(e VendingEvent) { seen = append(seen, e) }))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				v.RequestItem("A1")
+				v.InsertMoney(50)
+				v.ItemRequested.InsertMoney(20)
+				v.HasMoney.DispenseItem()
+				v.Cancel()
+				v.TakeTray()
+				v.Status()
+				v.CurrentState()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// автомат хранит только последние события, полный журнал — у наблюдателя
+	if len(seen) <= eventHistory {
+		t.Fatalf("observer saw %d events, want more than %d", len(seen), eventHistory)
+	}
+	if events := v.Events(); !reflect.DeepEqual(seen[len(seen)-eventHistory:], events) {
+		t.Fatalf("machine keeps %d events, want the last %d seen by observer", len(events), eventHistory)
+	}
+	events := seen
+	for i, e := range events {
+		if e.Seq != i+1 {
+			t.Fatalf("event %d has Seq %d", i+1, e.Seq)
+		}
+		if i > 0 && e.From != events[i-1].To {
+			t.Fatalf("event %d starts in [%s] after [%s]", e.Seq, e.From, events[i-1].To)
+		}
+	}
+	restored, err := RestoreVendingMachine(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Status(), v.Status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored status = %+v, want %+v", got, want)
+	}
+}
+
+func TestVendingStateNotCurrent(t *testing.T) {
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}}, map[int]int{50: 0})
+	if err := v.HasMoney.DispenseItem(); err == nil {
+		t.Fatal("dispensed from a state that is not current")
+	}
+	if err := v.HasItem.RequestItem("A1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.CurrentState(); got != v.ItemRequested {
+		t.Errorf("state = %s, want %s", v.stateName(got), StateItemRequested)
+	}
+	events := v.Events()
+	if len(events) != 2 || events[1].Trigger != TriggerRequestItem {
+		t.Errorf("events = %+v, want init and request_item", events)
+	}
+}
+
+func TestJournalRestore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}, {Code: "A2", Name: "Juice", Price: 90, Count: 0}},
+		map[int]int{10: 5, 20: 0, 50: 2})
+	v.SetClock(func() time.Time { return now })
+	var log bytes.Buffer
+	journal := NewJournal(&log)
+	v.Subscribe(journal)
+
+	v.SetTimeout(time.Minute)
+	v.RequestItem("A2")
+	v.AddItem("A2", 2)
+	v.RequestItem("A2")
+	v.InsertMoney(50)
+	v.InsertMoney(20)
+	now = now.Add(time.Minute)
+	v.CheckTimeout()
+	v.RequestItem("A1")
+	v.InsertMoney(100)
+	v.InsertMoney(50)
+	v.InsertMoney(20)
+	v.DispenseItem()
+	v.TakeTray()
+	v.AddCoins(20, 3)
+	if err := journal.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// недописанная при сбое строка
+	log.WriteString(`{"seq":99,"trig`)
+	events, err := ReadJournal(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := v.Events()
+	if len(events) != len(want) {
+		t.Fatalf("read %d events, want %d", len(events), len(want))
+	}
+	restored, err := RestoreVendingMachine(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Status(), v.Status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored status = %+v, want %+v", got, want)
+	}
+	if restored.timeout != time.Minute {
+		t.Errorf("restored timeout = %s, want %s", restored.timeout, time.Minute)
+	}
+	for i, e := range restored.Events() {
+		w := want[i]
+		if e.Seq != w.Seq || !e.At.Equal(w.At) || e.Trigger != w.Trigger || e.From != w.From || e.To != w.To || e.Err != w.Err {
+			t.Errorf("restored event %d = %+v, want %+v", i+1, e, w)
+		}
+	}
+
+	tampered := append([]VendingEvent(nil), events...)
+	tampered[len(tampered)-2].To = StateNoItem
+	if _, err := RestoreVendingMachine(tampered); err == nil {
+		t.Error("restored from a log that does not replay")
+	}
+	tampered = append([]VendingEvent(nil), events...)
+	tampered[1].Err = "out of order"
+	if _, err := RestoreVendingMachine(tampered); err == nil || !strings.Contains(err.Error(), "[out of order]") {
+		t.Errorf("restored from a log with a wrong error: %v", err)
+	}
+	if _, err := RestoreVendingMachine(events[1:]); err == nil {
+		t.Error("restored from a log without init")
+	}
+}
+
+func TestFSMGuardOrder(t *testing.T) {
+	var ran []string
+	act := func(name string, err error) func(interface{}) error {
+		return func(interface{}) error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+	above := func(n int) func(interface{}) bool {
+		return func(p interface{}) bool { return p.(int) > n }
+	}
+	table := []Transition{
+		{From: "A", Event: "go", To: "B", When: "> 10", Guard: above(10), Action: act("big", nil)},
+		{From: "A", Event: "go", To: "C", When: "> 5", Guard: above(5), Action: act("medium", nil)},
+		{From: "A", Event: "go", To: "D", Action: act("small", nil)},
+		{From: "A", Event: "fail", To: "B", Action: act("fail", errors.New("action failed"))},
+		{From: "A", Event: "stop", Reject: "stopped"},
+		{From: "A", Event: "skip", When: "> 10", Guard: above(10), Reject: "too big"},
+		{From: "A", Event: "skip", To: "C", Action: act("skip", nil)},
+		{From: "B", Event: "go", To: "A", Guard: above(0)},
+	}
+	tests := []struct {
+		event   string
+		payload int
+		want    string
+		ran     []string
+		err     string
+	}{
+		{"go", 20, "B", []string{"big"}, ""},
+		{"go", 7, "C", []string{"medium"}, ""},
+		{"go", 1, "D", []string{"small"}, ""},
+		{"fail", 0, "A", []string{"fail"}, "action failed"},
+		{"stop", 0, "A", nil, "stopped"},
+		{"skip", 20, "A", nil, "too big"},
+		{"skip", 1, "C", []string{"skip"}, ""},
+		{"jump", 0, "A", nil, "event [jump] is not allowed in state [A]"},
+	}
+	for _, tt := range tests {
+		f, err := NewFSM("A", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ran = nil
+		err = f.Fire(tt.event, tt.payload)
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("Fire(%s, %d) error = %v, want %q", tt.event, tt.payload, err, tt.err)
+		}
+		if f.Current() != tt.want || !reflect.DeepEqual(ran, tt.ran) {
+			t.Errorf("Fire(%s, %d): state %s, ran %v, want %s, %v", tt.event, tt.payload, f.Current(), ran, tt.want, tt.ran)
+		}
+	}
+
+	f, _ := NewFSM("B", table)
+	if err := f.Fire("go", 0); err == nil || f.Current() != "B" {
+		t.Errorf("Fire with no guard holding: state %s, error %v", f.Current(), err)
+	}
+	if err := f.SetCurrent("A"); err != nil || f.Current() != "A" {
+		t.Errorf("SetCurrent(A): state %s, error %v", f.Current(), err)
+	}
+	if err := f.SetCurrent("Z"); err == nil {
+		t.Error("SetCurrent accepted an unknown state")
+	}
+}
+
+func TestNewFSMErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		table []Transition
+	}{
+		{"no event", []Transition{{From: "A", To: "B"}}},
+		{"no target", []Transition{{From: "A", Event: "go"}}},
+		{"target and reject", []Transition{{From: "A", Event: "go", To: "B", Reject: "no"}}},
+		{"after unconditional", []Transition{{From: "A", Event: "go", To: "B"}, {From: "A", Event: "go", To: "C"}}},
+		{"after reject", []Transition{{From: "A", Event: "go", Reject: "no"}, {From: "A", Event: "go", To: "C"}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewFSM("A", tt.table); err == nil {
+			t.Errorf("%s: NewFSM accepted the table", tt.name)
+		}
+	}
+}
+
+func TestVendingMachineGraph(t *testing.T) {
+	v := NewVendingMachine(nil, nil)
+	dot := `digraph "VendingMachine" {
+	rankdir=LR;
+	__start [shape=point];
+	"NoItem" [shape=box, style=rounded];
+	"HasItem" [shape=box, style=rounded];
+	"ItemRequested" [shape=box, style=rounded];
+	"HasMoney" [shape=box, style=rounded];
+	__start -> "NoItem";
+	"NoItem" -> "HasItem" [label="add_item"];
+	"HasItem" -> "HasItem" [label="add_item"];
+	"HasItem" -> "ItemRequested" [label="request_item"];
+	"ItemRequested" -> "ItemRequested" [label="insert_money [credit < price]"];
+	"ItemRequested" -> "HasMoney" [label="insert_money [credit >= price]"];
+	"ItemRequested" -> "HasItem" [label="cancel"];
+	"HasMoney" -> "HasItem" [label="dispense_item [items left]"];
+	"HasMoney" -> "NoItem" [label="dispense_item [sold out]"];
+	"HasMoney" -> "HasItem" [label="cancel"];
+}
+`
+	mermaid := `stateDiagram-v2
+    [*] --> NoItem
+    NoItem --> HasItem: add_item
+    HasItem --> HasItem: add_item
+    HasItem --> ItemRequested: request_item
+    ItemRequested --> ItemRequested: insert_money [credit < price]
+    ItemRequested --> HasMoney: insert_money [credit >= price]
+    ItemRequested --> HasItem: cancel
+    HasMoney --> HasItem: dispense_item [items left]
+    HasMoney --> NoItem: dispense_item [sold out]
+    HasMoney --> HasItem: cancel
+`
+	if got := v.DOT(); got != dot {
+		t.Errorf("DOT() =\n%s\nwant\n%s", got, dot)
+	}
+	if got := v.Mermaid(); got != mermaid {
+		t.Errorf("Mermaid() =\n%s\nwant\n%s", got, mermaid)
+	}
+
+	f, _ := NewFSM("A", []Transition{{From: "A", Event: "go", To: "B", When: "a: b"}})
+	if got, want := f.Mermaid(), "stateDiagram-v2\n    [*] --> A\n    A --> B: go [a  b]\n"; got != want {
+		t.Errorf("Mermaid() with colon = %q, want %q", got, want)
+	}
+}
+
+func TestVendingMachineSetState(t *testing.T) {
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}}, map[int]int{50: 0})
+	if err := v.IncrementItemCount("A1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.IncrementItemCount("B1", 1); err == nil {
+		t.Error("IncrementItemCount accepted an unknown slot")
+	}
+	v.RequestItem("A1")
+	v.InsertMoney(50)
+
+	if err := v.SetState(v.HasMoney); err == nil {
+		t.Error("SetState(HasMoney) accepted")
+	}
+	if err := v.SetState(&vendingState{name: "Broken"}); err == nil {
+		t.Error("SetState accepted a state of another machine")
+	}
+	if err := v.SetState(v.NoItem); err != nil {
+		t.Fatal(err)
+	}
+	st := v.Status()
+	if st.State != StateNoItem || st.Selected != "" || !reflect.DeepEqual(st.Tray, []int{50}) || st.Slots[0].Count != 3 {
+		t.Errorf("status after SetState(NoItem): %+v", st)
+	}
+	if err := v.RequestItem("A1"); err == nil {
+		t.Error("sold an item from a machine out of service")
+	}
+	if err := v.SetState(v.HasItem); err != nil {
+		t.Fatal(err)
+	}
+
+	events := v.Events()
+	restored, err := RestoreVendingMachine(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Status(), v.Status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored status = %+v, want %+v", got, want)
+	}
+}
+
+func TestVendingEventJSON(t *testing.T) {
+	e := VendingEvent{
+		Seq:     2,
+		At:      time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Trigger: TriggerInsertMoney,
+		Payload: Payload{Money: 50},
+		From:    StateItemRequested,
+		To:      StateItemRequested,
+		Err:     "[50] not accepted",
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"seq":2,"at":"2024-01-01T12:00:00Z","trigger":"insert_money","payload":{"money":50},` +
+		`"from":"ItemRequested","to":"ItemRequested","err":"[50] not accepted"}`
+	if string(b) != want {
+		t.Errorf("journal line = %s, want %s", b, want)
+	}
+
+	// журналы, записанные до тегов
+	old := `{"Seq":2,"At":"2024-01-01T12:00:00Z","Trigger":"insert_money","Payload":{"Money":50},` +
+		`"From":"ItemRequested","To":"ItemRequested","Err":"[50] not accepted"}` + "\n"
+	events, err := ReadJournal(strings.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], e) {
+		t.Errorf("read %+v, want %+v", events, e)
+	}
+}
+
+func TestVendingHandler(t *testing.T) {
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}}, map[int]int{10: 5, 50: 0})
+	srv := httptest.NewServer(NewVendingHandler(v))
+	defer srv.Close()
+
+	type response struct {
+		Error  string        `json:"error"`
+		Taken  []int         `json:"taken"`
+		Status VendingStatus `json:"status"`
+	}
+	do := func(method, path, body string, code int) response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %s %s: status %d, want %d", method, path, body, resp.StatusCode, code)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+			t.Errorf("%s %s: Content-Type %q", method, path, ct)
+		}
+		var r response
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	if r := do(http.MethodGet, "/status", "", http.StatusOK); r.Status.State != StateHasItem || len(r.Status.Slots) != 1 {
+		t.Errorf("status = %+v", r.Status)
+	}
+	if r := do(http.MethodPost, "/request", `{"code":"A1"}`, http.StatusOK); r.Status.Selected != "A1" {
+		t.Errorf("status after request = %+v", r.Status)
+	}
+	if r := do(http.MethodPost, "/insert-money", `{"money":20}`, http.StatusConflict); r.Error != "[20] not accepted" || r.Status.Credit != 0 {
+		t.Errorf("rejected insert = %+v", r)
+	}
+	do(http.MethodPost, "/insert-money", `{"money":50}`, http.StatusOK)
+	if r := do(http.MethodPost, "/insert-money", `{"money":50}`, http.StatusOK); r.Status.State != StateHasMoney {
+		t.Errorf("status after payment = %+v", r.Status)
+	}
+	if r := do(http.MethodPost, "/dispense", "", http.StatusOK); r.Status.State != StateNoItem || !reflect.DeepEqual(r.Status.Tray, []int{10, 10, 10, 10}) {
+		t.Errorf("status after dispense = %+v", r.Status)
+	}
+	if r := do(http.MethodPost, "/take-tray", "", http.StatusOK); !reflect.DeepEqual(r.Taken, []int{10, 10, 10, 10}) || len(r.Status.Tray) != 0 {
+		t.Errorf("take tray = %+v", r)
+	}
+	if r := do(http.MethodPost, "/take-tray", "", http.StatusOK); r.Taken == nil || len(r.Taken) != 0 {
+		t.Errorf("take empty tray = %+v", r)
+	}
+	do(http.MethodPost, "/cancel", "", http.StatusConflict)
+	if r := do(http.MethodPost, "/add-item", `{"code":"A1","count":2}`, http.StatusOK); r.Status.State != StateHasItem || r.Status.Slots[0].Count != 2 {
+		t.Errorf("status after add item = %+v", r.Status)
+	}
+	if r := do(http.MethodPost, "/add-coins", `{"money":20,"count":3}`, http.StatusOK); r.Status.Coins[20] != 3 {
+		t.Errorf("status after add coins = %+v", r.Status)
+	}
+
+	do(http.MethodPost, "/request", `{"code":`, http.StatusBadRequest)
+	do(http.MethodPost, "/request", `{"slot":"A1"}`, http.StatusBadRequest)
+	do(http.MethodGet, "/dispense", "", http.StatusMethodNotAllowed)
+	do(http.MethodGet, "/take-tray", "", http.StatusMethodNotAllowed)
+	do(http.MethodPost, "/status", "", http.StatusMethodNotAllowed)
+}
+
+func TestRunSimulator(t *testing.T) {
+	v := NewVendingMachine([]Slot{{Code: "A1", Name: "Water", Price: 60, Count: 1}}, map[int]int{10: 5, 20: 0, 50: 0})
+	script := `# покупка со сдачей
+request A1
+insert 50
+insert 20
+expect HasMoney
+dispense
+take
+
+expect NoItem
+request A1
+insert x
+frobnicate
+add A1 2
+coins 20 3
+`
+	var out strings.Builder
+	if err := RunSimulator(v, strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"> request A1\nok\nstate ItemRequested, selected A1, credit 0, tray []\n",
+		"> take\ntaken [10]\nok\nstate NoItem, tray []\n",
+		"> expect NoItem\nok\n",
+		"> request A1\nerror: item out of stock\n",
+		"> insert x\nerror: usage: insert MONEY\n",
+		"> frobnicate\nerror: unknown command \"frobnicate\"\n",
+		"  A1 Water: price 60, 2 left\n  coins 10x4 20x4 50x1\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output has no %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "покупка") {
+		t.Error("comment line echoed")
+	}
+
+	err := RunSimulator(v, strings.NewReader("request A1\nexpect HasMoney\ncancel\n"), &out)
+	if err == nil || err.Error() != "line 2: state is ItemRequested, expected HasMoney" {
+		t.Errorf("failed expect: %v", err)
+	}
+	if st := v.Status(); st.State != StateItemRequested {
+		t.Errorf("script continued after failed expect: %+v", st)
+	}
+	if err := RunSimulator(v, strings.NewReader("expect\n"), &out); err == nil {
+		t.Error("expect without state accepted")
+	}
+}